@@ -1,17 +1,23 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"os"
 	"time"
 
 	"github.com/tls-handshake/internal"
+	"github.com/tls-handshake/internal/certs"
 )
 
 func main() {
 	port := flag.Int("p", 8081, "Port to connect to (optional)")
 	address := flag.String("ip", "127.0.0.2", "IP address to connect to (optional)")
+	caFile := flag.String("ca", "", "PEM file with the root CAs which verify the server, the system roots by default (optional)")
+	insecure := flag.Bool("insecure", false, "Skip the verification of the server certificate (optional)")
+	certFile := flag.String("cert", "", "PEM file with the client certificate chain, sent when the server asks for it (optional)")
+	keyFile := flag.String("key", "", "PEM file with the private key of the client certificate (optional)")
 	flag.Parse()
 
 	if port == nil || address == nil {
@@ -19,7 +25,25 @@ func main() {
 		os.Exit(1)
 	}
 
-	var client internal.Client
+	config := &internal.Config{InsecureSkipVerify: *insecure}
+	if *caFile != "" {
+		roots, err := certs.LoadCertPool(*caFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		config.RootCAs = roots
+	}
+	if *certFile != "" || *keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	client := internal.Client{Config: config}
 	if err := client.Connect(*address, uint16(*port)); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -1,16 +1,27 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/tls-handshake/internal"
+	"github.com/tls-handshake/internal/certs"
 )
 
+const shutdownTimeout = time.Second * 5
+
 func main() {
 	port := flag.Int("p", 8081, "Port to listen on (optional)")
 	address := flag.String("ip", "127.0.0.2", "IP address to use (optional)")
+	certFile := flag.String("cert", "", "PEM file with the certificate chain, a self-signed certificate by default (optional)")
+	keyFile := flag.String("key", "", "PEM file with the private key of the certificate (optional)")
+	clientCAFile := flag.String("client-ca", "", "PEM file with the CAs which verify the required client certificates (optional)")
 	flag.Parse()
 
 	if port == nil || address == nil {
@@ -18,9 +29,43 @@ func main() {
 		os.Exit(1)
 	}
 
-	var srv internal.Server
-	if err := srv.Listen(*address, uint16(*port)); err != nil {
+	config := &internal.Config{}
+	if *certFile != "" || *keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if *clientCAFile != "" {
+		clientCAs, err := certs.LoadCertPool(*clientCAFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = clientCAs
+	}
+
+	srv := internal.Server{Config: config}
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			fmt.Println(err)
+		}
+	}()
+
+	if err := srv.Listen(*address, uint16(*port)); err != internal.ServerClosedErr {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	<-shutdownDone // Listen returns as soon as the listener is closed, wait for the connections to finish.
 }
@@ -1,37 +1,46 @@
 package e2e
 
 import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/tls-handshake/internal"
+	"github.com/tls-handshake/internal/certs"
+	tlstypes "github.com/tls-handshake/internal/tls_types"
+	"github.com/tls-handshake/internal/tls_types/extensions"
 )
 
 func Test_e2e_SingleClient(t *testing.T) {
-	const (
-		address = "127.0.0.3"
-		port    = 8082
-	)
-
 	var wg sync.WaitGroup
 	wg.Add(1)
 
-	go func ()  {
-		var srv internal.Server
-		// don't wait for the server to stop, because it runs forever right now.
-		if err := srv.Listen(address, port); err != nil {
-			t.Error(err)
-		}
-	}()
-
-	time.Sleep(time.Millisecond * 2) // client needs to wait for server to start listening
+	srv := internal.Server{Config: testServerConfig(nil)}
+	dial, stop := servePipe(t, &srv)
+	defer stop()
 
 	go func ()  {
 		defer wg.Done()
 
-		var client internal.Client
-		if err := client.Connect(address, port); err != nil {
+		client := internal.Client{Config: testClientConfig(nil), Dial: dial}
+		if err := client.Connect("127.0.0.1", 0); err != nil {
 			t.Error(err)
 		}
 		if err := client.Ping(); err != nil {
@@ -46,29 +55,18 @@ func Test_e2e_SingleClient(t *testing.T) {
 }
 
 func Test_e2e_ClientReconnect(t *testing.T) {
-	const (
-		address = "127.0.0.3"
-		port    = 8083
-	)
-
 	var wg sync.WaitGroup
 	wg.Add(1)
 
-	go func ()  {
-		// don't wait for the server to stop, because it runs forever right now.
-		var srv internal.Server
-		if err := srv.Listen(address, port); err != nil {
-			t.Error(err)
-		}
-	}()
-
-	time.Sleep(time.Millisecond * 2) // client needs to wait for server to start listening
+	srv := internal.Server{Config: testServerConfig(nil)}
+	dial, stop := servePipe(t, &srv)
+	defer stop()
 
 	go func ()  {
 		defer wg.Done()
 
-		var client internal.Client
-		if err := client.Connect(address, port); err != nil {
+		client := internal.Client{Config: testClientConfig(nil), Dial: dial}
+		if err := client.Connect("127.0.0.1", 0); err != nil {
 			t.Error(err)
 		}
 		if err := client.Ping(); err != nil {
@@ -77,7 +75,7 @@ func Test_e2e_ClientReconnect(t *testing.T) {
 		client.Disconnect()
 
 		// second ping should also work:
-		if err := client.Connect(address, port); err != nil {
+		if err := client.Connect("127.0.0.1", 0); err != nil {
 			t.Error(err)
 		}
 		if err := client.Ping(); err != nil {
@@ -92,29 +90,18 @@ func Test_e2e_ClientReconnect(t *testing.T) {
 }
 
 func Test_e2e_MultipleClients(t *testing.T) {
-	const (
-		address = "127.0.0.3"
-		port    = 8084
-	)
-
 	var wg sync.WaitGroup
 	wg.Add(2)
 
-	go func ()  {
-		var srv internal.Server
-		// don't wait for the server to stop, because it runs forever right now.
-		if err := srv.Listen(address, port); err != nil {
-			t.Error(err)
-		}
-	}()
-
-	time.Sleep(time.Millisecond * 2) // client needs to wait for server to start listening
+	srv := internal.Server{Config: testServerConfig(nil)}
+	dial, stop := servePipe(t, &srv)
+	defer stop()
 
 	go func ()  {
 		defer wg.Done()
 
-		var client internal.Client
-		if err := client.Connect(address, port); err != nil {
+		client := internal.Client{Config: testClientConfig(nil), Dial: dial}
+		if err := client.Connect("127.0.0.1", 0); err != nil {
 			t.Error(err)
 		}
 		if err := client.Ping(); err != nil {
@@ -128,8 +115,8 @@ func Test_e2e_MultipleClients(t *testing.T) {
 	go func ()  {
 		defer wg.Done()
 
-		var client internal.Client
-		if err := client.Connect(address, port); err != nil {
+		client := internal.Client{Config: testClientConfig(nil), Dial: dial}
+		if err := client.Connect("127.0.0.1", 0); err != nil {
 			t.Error(err)
 		}
 
@@ -144,4 +131,1522 @@ func Test_e2e_MultipleClients(t *testing.T) {
 	}()
 
 	wg.Wait()
-}
\ No newline at end of file
+}
+
+func Test_e2e_RecordPadding(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	srv := internal.Server{Config: testServerConfig(&internal.Config{PaddingBlockSize: 64})}
+	dial, stop := servePipe(t, &srv)
+	defer stop()
+
+	go func() {
+		defer wg.Done()
+
+		client := internal.Client{Config: testClientConfig(&internal.Config{PaddingBlockSize: 32}), Dial: dial}
+		if err := client.Connect("127.0.0.1", 0); err != nil {
+			t.Error(err)
+		}
+		for i := 0; i < 3; i++ {
+			if err := client.Ping(); err != nil {
+				t.Error(err)
+			}
+		}
+
+		client.Disconnect()
+		time.Sleep(time.Millisecond * 2) // wait a bit, to see if the server errors on Disconnect
+	}()
+
+	wg.Wait()
+}
+
+func Test_e2e_ConnAsReadWriter(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	srv := internal.Server{Config: testServerConfig(nil)}
+	address, port, stop := serveTCP(t, &srv)
+	defer stop()
+
+	go func() {
+		defer wg.Done()
+
+		client := internal.Client{Config: testClientConfig(nil)}
+		if err := client.Connect(address, port); err != nil {
+			t.Error(err)
+			return
+		}
+
+		var conn net.Conn = client.Conn()
+		if conn.RemoteAddr().String() != net.JoinHostPort(address, strconv.Itoa(int(port))) {
+			t.Errorf("unexpected remote address %s", conn.RemoteAddr())
+		}
+
+		var rw io.ReadWriter = conn
+		if _, err := io.WriteString(rw, "PINGPING"); err != nil {
+			t.Error(err)
+		}
+		pongs := make([]byte, len("PONGPONG"))
+		if _, err := io.ReadFull(rw, pongs); err != nil {
+			t.Error(err)
+		}
+		if string(pongs) != "PONGPONG" {
+			t.Errorf("unexpected response %q", pongs)
+		}
+
+		if err := conn.Close(); err != nil {
+			t.Error(err)
+		}
+		if _, err := conn.Write([]byte("PING")); err == nil {
+			t.Error("expected write on closed conn to fail")
+		}
+		time.Sleep(time.Millisecond * 2) // wait a bit, to see if the server errors on Close
+	}()
+
+	wg.Wait()
+}
+
+func Test_e2e_PacketHandler(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	srv := internal.Server{
+		Config: testServerConfig(nil),
+		Handler: internal.PacketHandler(func(data []byte) ([]byte, error) {
+			return bytes.ToUpper(data), nil
+		}),
+	}
+	dial, stop := servePipe(t, &srv)
+	defer stop()
+
+	go func() {
+		defer wg.Done()
+
+		client := internal.Client{Config: testClientConfig(nil), Dial: dial}
+		if err := client.Connect("127.0.0.1", 0); err != nil {
+			t.Error(err)
+			return
+		}
+		defer client.Disconnect()
+
+		for _, msg := range []string{"hello", "custom protocol"} {
+			if _, err := client.Conn().Write([]byte(msg)); err != nil {
+				t.Error(err)
+				return
+			}
+			resp := make([]byte, len(msg))
+			if _, err := io.ReadFull(client.Conn(), resp); err != nil {
+				t.Error(err)
+				return
+			}
+			if string(resp) != strings.ToUpper(msg) {
+				t.Errorf("expected %q, got %q", strings.ToUpper(msg), resp)
+			}
+		}
+	}()
+
+	wg.Wait()
+}
+
+func Test_e2e_ShutdownNotifiesLiveConnections(t *testing.T) {
+	srv := internal.Server{Config: testServerConfig(nil)}
+	l := newPipeListener()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(l)
+	}()
+
+	client := internal.Client{Config: testClientConfig(nil), Dial: l.Dial}
+	if err := client.Connect("127.0.0.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Ping(); err != nil {
+		t.Error(err)
+	}
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		shutdownErr <- srv.Shutdown(ctx)
+	}()
+
+	// The server sends close_notify, the client sees EOF and closes its side, which lets the handler return.
+	if _, err := client.Conn().Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected EOF after shutdown, got %v", err)
+	}
+	client.Disconnect()
+
+	if err := <-shutdownErr; err != nil {
+		t.Error(err)
+	}
+	if err := <-serveErr; err != internal.ServerClosedErr {
+		t.Errorf("expected ServerClosedErr, got %v", err)
+	}
+	if err := client.Connect("127.0.0.1", 0); err == nil {
+		t.Error("expected connect to a closed server to fail")
+		client.Disconnect()
+	}
+}
+
+func Test_e2e_ShutdownWithBlockedWriter(t *testing.T) {
+	// The handler blocks in Write, since the client never reads:
+	srv := internal.Server{
+		Config: testServerConfig(nil),
+		Handler: internal.HandlerFunc(func(conn *internal.Conn) error {
+			_, err := conn.Write(make([]byte, 1<<20))
+			return err
+		}),
+	}
+	l := newPipeListener()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(l)
+	}()
+
+	client := internal.Client{Config: testClientConfig(nil), Dial: l.Dial}
+	if err := client.Connect("127.0.0.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	// Shutdown gives up when ctx expires and closes the connection:
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("shutdown took %v", elapsed)
+	}
+	if err := <-serveErr; err != internal.ServerClosedErr {
+		t.Errorf("expected ServerClosedErr, got %v", err)
+	}
+}
+
+func Test_e2e_CloseWithBlockedWriter(t *testing.T) {
+	// The handler never reads, so a large Write of the client blocks on the pipe:
+	release := make(chan struct{})
+	srv := internal.Server{
+		Config: testServerConfig(nil),
+		Handler: internal.HandlerFunc(func(conn *internal.Conn) error {
+			<-release
+			return nil
+		}),
+	}
+	dial, stop := servePipe(t, &srv)
+	defer stop()
+	defer close(release)
+
+	writing := make(chan struct{})
+	var once sync.Once
+	client := internal.Client{
+		Config: testClientConfig(nil),
+		Dial: func(network, address string) (net.Conn, error) {
+			conn, err := dial(network, address)
+			return &writeStartConn{Conn: conn, onLargeWrite: func() { once.Do(func() { close(writing) }) }}, err
+		},
+	}
+	if err := client.Connect("127.0.0.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	conn := client.Conn()
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(make([]byte, 1<<20))
+		writeErr <- err
+	}()
+	<-writing
+
+	start := time.Now()
+	if err := conn.Close(); err != nil {
+		t.Error(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("close took %v", elapsed)
+	}
+	select {
+	case err := <-writeErr:
+		if err == nil {
+			t.Error("blocked write succeeded")
+		}
+	case <-time.After(time.Second):
+		t.Error("close did not unblock the write")
+	}
+}
+
+func Test_e2e_ConnectConnOverPipe(t *testing.T) {
+	srv := internal.Server{Config: testServerConfig(nil)}
+	l := newPipeListener()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(l)
+	}()
+
+	conn, err := l.Dial("pipe", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The address of the server is not known, the certificate is verified against the configured server name:
+	client := internal.Client{Config: testClientConfig(&internal.Config{ServerName: "localhost"})}
+	if err := client.ConnectConn(conn); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Ping(); err != nil {
+		t.Error(err)
+	}
+	client.Disconnect()
+
+	if err := srv.Close(); err != nil {
+		t.Error(err)
+	}
+	if err := <-serveErr; err != internal.ServerClosedErr {
+		t.Errorf("expected ServerClosedErr, got %v", err)
+	}
+}
+
+func Test_e2e_UnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "server.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skip("unix sockets are not supported:", err)
+	}
+
+	srv := internal.Server{Config: testServerConfig(nil)}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(l)
+	}()
+
+	client := internal.Client{
+		Config: testClientConfig(nil),
+		Dial: func(_, _ string) (net.Conn, error) {
+			return net.Dial("unix", socket)
+		},
+	}
+	if err := client.Connect("127.0.0.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Ping(); err != nil {
+		t.Error(err)
+	}
+	client.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Error(err)
+	}
+	if err := <-serveErr; err != internal.ServerClosedErr {
+		t.Errorf("expected ServerClosedErr, got %v", err)
+	}
+}
+
+func Test_e2e_KeyExchangeGroups(t *testing.T) {
+	for _, curve := range []tls.CurveID{tls.X25519, tls.CurveP256} {
+		srv := internal.Server{Config: testServerConfig(nil)}
+		dial, stop := servePipe(t, &srv)
+
+		client := internal.Client{Config: testClientConfig(&internal.Config{CurvePreferences: []tls.CurveID{curve}}), Dial: dial}
+		if err := client.Connect("127.0.0.1", 0); err != nil {
+			t.Errorf("group %d: %v", curve, err)
+		} else if err := client.Ping(); err != nil {
+			t.Errorf("group %d: %v", curve, err)
+		}
+		client.Disconnect()
+		stop()
+	}
+
+	// The server prefers P-256 but accepts the X25519 share of a default client:
+	srvPrefs := internal.Server{Config: testServerConfig(&internal.Config{CurvePreferences: []tls.CurveID{tls.CurveP256, tls.X25519}})}
+	dialPrefs, stopPrefs := servePipe(t, &srvPrefs)
+	client := internal.Client{Config: testClientConfig(nil), Dial: dialPrefs}
+	if err := client.Connect("127.0.0.1", 0); err != nil {
+		t.Error(err)
+	} else if err := client.Ping(); err != nil {
+		t.Error(err)
+	}
+	client.Disconnect()
+	stopPrefs()
+
+	// The server refuses key shares for groups it is not configured with:
+	srv := internal.Server{Config: testServerConfig(&internal.Config{CurvePreferences: []tls.CurveID{tls.X25519}})}
+	dial, stop := servePipe(t, &srv)
+	defer stop()
+
+	client = internal.Client{Config: testClientConfig(&internal.Config{CurvePreferences: []tls.CurveID{tls.CurveP256}}), Dial: dial}
+	if err := client.Connect("127.0.0.1", 0); err == nil {
+		t.Error("expected the handshake to fail for an unsupported group")
+		client.Disconnect()
+	}
+}
+
+func Test_e2e_HelloRetryRequest(t *testing.T) {
+	// The client sends an X25519 key share, the server only accepts P-256 and asks for it:
+	srv := internal.Server{Config: testServerConfig(&internal.Config{CurvePreferences: []tls.CurveID{tls.CurveP256}})}
+	dial, stop := servePipe(t, &srv)
+	defer stop()
+
+	client := internal.Client{
+		Config: testClientConfig(&internal.Config{CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256}}),
+		Dial:   dial,
+	}
+	if err := client.Connect("127.0.0.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := client.Ping(); err != nil {
+			t.Error(err)
+		}
+	}
+	client.Disconnect()
+}
+
+func Test_e2e_CipherSuites(t *testing.T) {
+	suites := []uint16{tls.TLS_AES_128_GCM_SHA256, tls.TLS_AES_256_GCM_SHA384, tls.TLS_CHACHA20_POLY1305_SHA256}
+	for _, cs := range suites {
+		srv := internal.Server{Config: testServerConfig(nil)}
+		dial, stop := servePipe(t, &srv)
+
+		client := internal.Client{Config: testClientConfig(&internal.Config{CipherSuites: []uint16{cs}}), Dial: dial}
+		if err := client.Connect("127.0.0.1", 0); err != nil {
+			t.Errorf("cipher suite %#04x: %v", cs, err)
+		} else if err := client.Ping(); err != nil {
+			t.Errorf("cipher suite %#04x: %v", cs, err)
+		}
+		client.Disconnect()
+		stop()
+	}
+
+	// The server preference wins and the transcript hash of the selected suite is used with a HelloRetryRequest:
+	srvPrefs := internal.Server{Config: testServerConfig(&internal.Config{
+		CipherSuites:     []uint16{tls.TLS_AES_256_GCM_SHA384, tls.TLS_CHACHA20_POLY1305_SHA256},
+		CurvePreferences: []tls.CurveID{tls.CurveP256},
+	})}
+	dialPrefs, stopPrefs := servePipe(t, &srvPrefs)
+	client := internal.Client{Config: testClientConfig(&internal.Config{CipherSuites: suites}), Dial: dialPrefs}
+	if err := client.Connect("127.0.0.1", 0); err != nil {
+		t.Error(err)
+	} else if err := client.Ping(); err != nil {
+		t.Error(err)
+	}
+	client.Disconnect()
+	stopPrefs()
+
+	// The handshake fails without a common cipher suite:
+	srv := internal.Server{Config: testServerConfig(&internal.Config{CipherSuites: []uint16{tls.TLS_CHACHA20_POLY1305_SHA256}})}
+	dial, stop := servePipe(t, &srv)
+	defer stop()
+
+	client = internal.Client{Config: testClientConfig(&internal.Config{CipherSuites: []uint16{tls.TLS_AES_128_GCM_SHA256}}), Dial: dial}
+	if err := client.Connect("127.0.0.1", 0); err == nil {
+		t.Error("expected the handshake to fail without a common cipher suite")
+		client.Disconnect()
+	}
+}
+
+func Test_e2e_CertificateValidation(t *testing.T) {
+	// Runs over TCP, the alert of a failed client would block on net.Pipe until the server is done writing.
+	srv := internal.Server{Config: testServerConfig(nil)}
+	address, port, stop := serveTCP(t, &srv)
+	defer stop()
+
+	connect := func(cfg *internal.Config) error {
+		client := internal.Client{Config: cfg}
+		err := client.Connect(address, port)
+		if err == nil {
+			err = client.Ping()
+			client.Disconnect()
+		}
+		return err
+	}
+
+	if err := connect(testClientConfig(nil)); err != nil {
+		t.Errorf("trusted certificate: %v", err)
+	}
+	if err := connect(testClientConfig(&internal.Config{ServerName: "localhost"})); err != nil {
+		t.Errorf("trusted certificate for server name: %v", err)
+	}
+	if err := connect(&internal.Config{InsecureSkipVerify: true}); err != nil {
+		t.Errorf("insecure skip verify: %v", err)
+	}
+
+	// The root pool has only an unrelated certificate authority:
+	otherCA, err := certs.GenerateCA("tls-handshake other CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(otherCA.Leaf)
+	var unknownAuthority x509.UnknownAuthorityError
+	if err := connect(&internal.Config{RootCAs: otherRoots}); !errors.As(err, &unknownAuthority) {
+		t.Errorf("expected an unknown authority error, got %v", err)
+	}
+
+	var hostname x509.HostnameError
+	if err := connect(testClientConfig(&internal.Config{ServerName: "example.com"})); !errors.As(err, &hostname) {
+		t.Errorf("expected a host name error, got %v", err)
+	}
+
+	var invalid x509.CertificateInvalidError
+	expired := testClientConfig(&internal.Config{Time: func() time.Time { return time.Now().AddDate(2, 0, 0) }})
+	if err := connect(expired); !errors.As(err, &invalid) || invalid.Reason != x509.Expired {
+		t.Errorf("expected a certificate expired error, got %v", err)
+	}
+
+	var client internal.Client
+	if err := client.ConnectConn(&net.TCPConn{}); err != internal.MissingServerNameErr {
+		t.Errorf("expected MissingServerNameErr, got %v", err)
+	}
+}
+
+func Test_e2e_ClientAuth(t *testing.T) {
+	clientCert, err := certs.GenerateSigned(testCA, "client.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCA, err := certs.GenerateCA("tls-handshake other CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherClientCert, err := certs.GenerateSigned(otherCA, "client.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(testCA.Leaf)
+
+	// The handler answers with the name in the client certificate:
+	peerName := internal.HandlerFunc(func(conn *internal.Conn) error {
+		name := "none"
+		if certs := conn.PeerCertificates(); len(certs) > 0 {
+			name = certs[0].DNSNames[0]
+		}
+		_, err := io.WriteString(conn, name)
+		return err
+	})
+
+	tests := []struct {
+		clientAuth tls.ClientAuthType
+		clientCert *tls.Certificate
+		expected   string // the name seen by the handler, empty when the handshake fails
+	}{
+		{tls.RequireAndVerifyClientCert, &clientCert, "client.test"},
+		{tls.RequireAndVerifyClientCert, nil, ""},
+		{tls.RequireAndVerifyClientCert, &otherClientCert, ""},
+		{tls.VerifyClientCertIfGiven, nil, "none"},
+		{tls.VerifyClientCertIfGiven, &otherClientCert, ""},
+		{tls.RequireAnyClientCert, &otherClientCert, "client.test"},
+		{tls.RequireAnyClientCert, nil, ""},
+		{tls.RequestClientCert, nil, "none"},
+		{tls.NoClientCert, &clientCert, "none"},
+	}
+	for i, tt := range tests {
+		srv := internal.Server{
+			Config:  testServerConfig(&internal.Config{ClientAuth: tt.clientAuth, ClientCAs: clientCAs}),
+			Handler: peerName,
+		}
+		address, port, stop := serveTCP(t, &srv)
+
+		clientConfig := testClientConfig(nil)
+		if tt.clientCert != nil {
+			clientConfig.Certificates = []tls.Certificate{*tt.clientCert}
+		}
+		client := internal.Client{Config: clientConfig}
+		if err := client.Connect(address, port); err != nil {
+			t.Fatalf("test %d: %v", i, err)
+		}
+		// The client finishes the handshake before the server checks its certificate, a rejection is seen on read.
+		resp, err := io.ReadAll(client.Conn())
+		switch {
+		case tt.expected == "" && err == nil:
+			t.Errorf("test %d: expected the server to reject the client, got %q", i, resp)
+		case tt.expected != "" && (err != nil || string(resp) != tt.expected):
+			t.Errorf("test %d: expected %q, got %q, %v", i, tt.expected, resp, err)
+		}
+		client.Disconnect()
+		stop()
+	}
+}
+
+func Test_e2e_SignatureSchemes(t *testing.T) {
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, ed, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(testCA.Leaf)
+
+	// Both peers sign their CertificateVerify with the scheme of their certificate key:
+	for _, key := range []crypto.Signer{p384, ed, rsaKey} {
+		serverCert, err := certs.GenerateSignedWithKey(testCA, key, "localhost")
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientCert, err := certs.GenerateSignedWithKey(testCA, key, "client.test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srvConfig := testServerConfig(&internal.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs})
+		srvConfig.Certificates = []tls.Certificate{serverCert}
+		srv := internal.Server{Config: srvConfig}
+		dial, stop := servePipe(t, &srv)
+
+		client := internal.Client{
+			Config: testClientConfig(&internal.Config{ServerName: "localhost", Certificates: []tls.Certificate{clientCert}}),
+			Dial:   dial,
+		}
+		if err := client.Connect("127.0.0.1", 0); err != nil {
+			t.Errorf("%T key: %v", key, err)
+		} else if err := client.Ping(); err != nil {
+			t.Errorf("%T key: %v", key, err)
+		}
+		client.Disconnect()
+		stop()
+	}
+}
+
+func Test_e2e_SessionResumption(t *testing.T) {
+	// connect does a handshake and a ping, which also delivers the session ticket of the server:
+	connect := func(client *internal.Client) (resumed bool) {
+		t.Helper()
+		defer client.Disconnect()
+		if err := client.Connect("127.0.0.1", 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Ping(); err != nil {
+			t.Fatal(err)
+		}
+		if len(client.Conn().PeerCertificates()) == 0 {
+			t.Error("connection has no server certificate")
+		}
+		return client.Conn().DidResume()
+	}
+
+	tests := []struct {
+		name         string
+		serverConfig *internal.Config
+		clientConfig *internal.Config
+		resumes      bool
+	}{
+		{"default", nil, nil, true},
+		{"hello retry request", &internal.Config{CurvePreferences: []tls.CurveID{tls.CurveP256}}, nil, true},
+		{"sha384 cipher suite", nil, &internal.Config{CipherSuites: []uint16{tls.TLS_AES_256_GCM_SHA384}}, true},
+		{"client tickets disabled", nil, &internal.Config{SessionTicketsDisabled: true}, false},
+		{"server tickets disabled", &internal.Config{SessionTicketsDisabled: true}, nil, false},
+	}
+	for _, tt := range tests {
+		srv := internal.Server{Config: testServerConfig(tt.serverConfig)}
+		dial, stop := servePipe(t, &srv)
+
+		client := internal.Client{Config: testClientConfig(tt.clientConfig), Dial: dial}
+		if connect(&client) {
+			t.Errorf("%s: first connection resumed a session", tt.name)
+		}
+		if resumed := connect(&client); resumed != tt.resumes {
+			t.Errorf("%s: expected resumed to be %v", tt.name, tt.resumes)
+		}
+		stop()
+	}
+
+	// Sessions are shared through the config cache, but a server with another ticket key can't resume them:
+	cache := internal.NewLRUClientSessionCache(1)
+	var ticketKey [32]byte
+	ticketKey[0] = 1
+	srv := internal.Server{Config: testServerConfig(&internal.Config{SessionTicketKey: ticketKey})}
+	dial, stop := servePipe(t, &srv)
+	defer stop()
+
+	first := internal.Client{Config: testClientConfig(&internal.Config{ClientSessionCache: cache}), Dial: dial}
+	second := internal.Client{Config: testClientConfig(&internal.Config{ClientSessionCache: cache}), Dial: dial}
+	connect(&first)
+	if !connect(&second) {
+		t.Error("session of the shared cache was not resumed")
+	}
+
+	otherSrv := internal.Server{Config: testServerConfig(nil)}
+	otherDial, otherStop := servePipe(t, &otherSrv)
+	defer otherStop()
+	other := internal.Client{Config: testClientConfig(&internal.Config{ClientSessionCache: cache}), Dial: otherDial}
+	if connect(&other) {
+		t.Error("server resumed a session of a server with another ticket key")
+	}
+}
+
+// Test_e2e_SessionResumptionCertificateChecks checks that the client only resumes sessions whose server certificate
+// it would still accept in a full handshake.
+func Test_e2e_SessionResumptionCertificateChecks(t *testing.T) {
+	srv := internal.Server{Config: testServerConfig(nil)}
+	address, port, stop := serveTCP(t, &srv)
+	defer stop()
+
+	// A session of a client which skipped the verification isn't used by a client which verifies:
+	cache := internal.NewLRUClientSessionCache(1)
+	insecure := internal.Client{Config: &internal.Config{InsecureSkipVerify: true, ClientSessionCache: cache}}
+	if err := insecure.Connect(address, port); err != nil {
+		t.Fatal(err)
+	}
+	if err := insecure.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	insecure.Disconnect()
+
+	verifying := internal.Client{Config: testClientConfig(&internal.Config{ClientSessionCache: cache})}
+	if err := verifying.Connect(address, port); err != nil {
+		t.Fatal(err)
+	}
+	if verifying.Conn().DidResume() {
+		t.Error("verifying client resumed a session whose certificate was not verified")
+	}
+	verifying.Disconnect()
+
+	// A session isn't resumed once the server certificate expired, the full handshake rejects the certificate:
+	notAfter := testServerCert.Leaf.NotAfter
+	now := notAfter.Add(-time.Minute)
+	client := internal.Client{Config: testClientConfig(&internal.Config{Time: func() time.Time { return now }})}
+	if err := client.Connect(address, port); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	client.Disconnect()
+
+	now = notAfter.Add(time.Minute)
+	if err := client.Connect(address, port); err == nil {
+		resumed := client.Conn().DidResume()
+		client.Disconnect()
+		t.Fatalf("connected with an expired server certificate, resumed: %v", resumed)
+	}
+}
+
+// Test_e2e_SessionResumptionClientAuth checks that the client certificate is kept in a resumed session, until it
+// expires.
+func Test_e2e_SessionResumptionClientAuth(t *testing.T) {
+	clientCert, err := certs.GenerateSigned(testCA, "client.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(testCA.Leaf)
+	peerName := internal.HandlerFunc(func(conn *internal.Conn) error {
+		name := "none"
+		if certs := conn.PeerCertificates(); len(certs) > 0 && conn.DidResume() {
+			name = certs[0].DNSNames[0]
+		}
+		_, err := io.WriteString(conn, name)
+		return err
+	})
+	srv := internal.Server{
+		Config:  testServerConfig(&internal.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}),
+		Handler: peerName,
+	}
+	dial, stop := servePipe(t, &srv)
+	defer stop()
+
+	client := internal.Client{
+		Config: testClientConfig(&internal.Config{Certificates: []tls.Certificate{clientCert}}),
+		Dial:   dial,
+	}
+	for i, expected := range []string{"none", "client.test"} {
+		if err := client.Connect("127.0.0.1", 0); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := io.ReadAll(client.Conn())
+		if err != nil || string(resp) != expected {
+			t.Errorf("connection %d: expected %q, got %q, %v", i, expected, resp, err)
+		}
+		client.Disconnect()
+	}
+
+	// The server doesn't resume a session once the client certificate expired:
+	notAfter := clientCert.Leaf.NotAfter
+	now := notAfter.Add(-2 * time.Minute)
+	expiringSrv := internal.Server{
+		Config: testServerConfig(&internal.Config{
+			ClientAuth: tls.RequireAnyClientCert,
+			Time:       func() time.Time { return now },
+		}),
+		Handler: peerName,
+	}
+	expiringDial, expiringStop := servePipe(t, &expiringSrv)
+	defer expiringStop()
+
+	client = internal.Client{
+		Config: testClientConfig(&internal.Config{Certificates: []tls.Certificate{clientCert}}),
+		Dial:   expiringDial,
+	}
+	for i, expected := range []string{"none", "client.test", "none"} {
+		if i == 2 {
+			now = notAfter.Add(time.Minute)
+		}
+		if err := client.Connect("127.0.0.1", 0); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := io.ReadAll(client.Conn())
+		if err != nil || string(resp) != expected {
+			t.Errorf("connection %d to the expiring server: expected %q, got %q, %v", i, expected, resp, err)
+		}
+		client.Disconnect()
+	}
+}
+
+func Test_e2e_EarlyData(t *testing.T) {
+	// pingPong answers a PING with a PONG and reports whether the PING was accepted as early data:
+	accepted := make(chan bool, 1)
+	pingPong := internal.HandlerFunc(func(conn *internal.Conn) error {
+		ping := make([]byte, len("PING"))
+		if _, err := io.ReadFull(conn, ping); err != nil {
+			return err
+		}
+		accepted <- conn.EarlyDataAccepted()
+		_, err := io.WriteString(conn, "PONG")
+		return err
+	})
+	connect := func(client *internal.Client, address string, port uint16) (resumed, earlyDataAccepted bool) {
+		t.Helper()
+		defer client.Disconnect()
+		if err := client.ConnectWithEarlyData(address, port, []byte("PING")); err != nil {
+			t.Fatal(err)
+		}
+		pong := make([]byte, len("PONG"))
+		if _, err := io.ReadFull(client.Conn(), pong); err != nil || string(pong) != "PONG" {
+			t.Fatalf("unexpected response %q, %v", pong, err)
+		}
+		if client.Conn().EarlyDataAccepted() != <-accepted {
+			t.Error("client and server disagree on the early data")
+		}
+		return client.Conn().DidResume(), client.Conn().EarlyDataAccepted()
+	}
+
+	tests := []struct {
+		name         string
+		serverConfig *internal.Config
+		accepts      bool
+	}{
+		{"accepted", &internal.Config{MaxEarlyData: 1024}, true},
+		{"server rejects early data", nil, false},
+		{"early data exceeds max size", &internal.Config{MaxEarlyData: 2}, false},
+		{"hello retry request", &internal.Config{MaxEarlyData: 1024, CurvePreferences: []tls.CurveID{tls.CurveP256}},
+			false},
+	}
+	for _, tt := range tests {
+		srv := internal.Server{Config: testServerConfig(tt.serverConfig), Handler: pingPong}
+		address, port, stop := serveTCP(t, &srv)
+
+		client := internal.Client{Config: testClientConfig(nil)}
+		if _, earlyDataAccepted := connect(&client, address, port); earlyDataAccepted {
+			t.Errorf("%s: early data accepted without a session", tt.name)
+		}
+		resumed, earlyDataAccepted := connect(&client, address, port)
+		if !resumed || earlyDataAccepted != tt.accepts {
+			t.Errorf("%s: expected early data accepted to be %v, resumed %v", tt.name, tt.accepts, resumed)
+		}
+		stop()
+	}
+}
+
+// Test_e2e_EarlyDataReplay replays the first flight of a client whose early data was accepted. The server must reject
+// the early data of the replayed ClientHello: it then skips the records it can't decrypt and waits for the client
+// Finished, while a server which accepted the early data fails on the Finished of the original connection right away.
+func Test_e2e_EarlyDataReplay(t *testing.T) {
+	srv := internal.Server{Config: testServerConfig(&internal.Config{MaxEarlyData: 1024})}
+	address, port, stop := serveTCP(t, &srv)
+	defer stop()
+
+	var flight bytes.Buffer
+	client := internal.Client{Config: testClientConfig(nil)}
+	for i := 0; i < 2; i++ {
+		flight.Reset()
+		client.Dial = func(network, address string) (net.Conn, error) {
+			conn, err := net.Dial(network, address)
+			return &recordingConn{Conn: conn, w: &flight}, err
+		}
+		if err := client.ConnectWithEarlyData(address, port, []byte("PING")); err != nil {
+			t.Fatal(err)
+		}
+		// The session ticket is delivered with the PONG:
+		if _, err := io.ReadFull(client.Conn(), make([]byte, len("PONG"))); err != nil {
+			t.Fatal(err)
+		}
+		client.Disconnect()
+	}
+	if !client.Conn().EarlyDataAccepted() {
+		t.Fatal("early data of the resumed connection was not accepted")
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(address, strconv.Itoa(int(port))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(flight.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Millisecond * 500))
+	_, err = io.Copy(io.Discard, conn)
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("expected the server to wait for the client Finished, got %v", err)
+	}
+}
+
+func Test_e2e_KeyUpdate(t *testing.T) {
+	countRecords := func(stream *bytes.Buffer) (n int) {
+		rr := tlstypes.NewRecordReader(stream)
+		for ; ; n++ {
+			if _, err := rr.ReadRecord(); err != nil {
+				return n
+			}
+		}
+	}
+
+	tests := []struct {
+		name       string
+		config     *internal.Config
+		updateKeys bool
+		sent       int // records sent by the client after the handshake
+		received   int // records received by the client after the handshake, including the session ticket
+	}{
+		{"no key update", nil, false, 3, 4},
+		{"requested key update", nil, true, 4, 5},
+		{"record limit", &internal.Config{KeyUpdateRecords: 2}, false, 4, 5},
+		{"byte limit", &internal.Config{KeyUpdateBytes: 4}, false, 5, 7},
+	}
+	for _, tt := range tests {
+		srv := internal.Server{Config: testServerConfig(tt.config)}
+		dial, stop := servePipe(t, &srv)
+
+		var sent, received bytes.Buffer
+		client := internal.Client{Config: testClientConfig(tt.config)}
+		client.Dial = func(network, address string) (net.Conn, error) {
+			conn, err := dial(network, address)
+			return &recordingConn{Conn: conn, w: &sent, r: &received}, err
+		}
+		if err := client.Connect("127.0.0.1", 0); err != nil {
+			t.Fatal(err)
+		}
+		sent.Reset()
+		received.Reset()
+		if tt.updateKeys {
+			if err := client.Conn().UpdateKeys(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 0; i < 3; i++ {
+			if err := client.Ping(); err != nil {
+				t.Fatalf("%s: ping %d: %v", tt.name, i, err)
+			}
+		}
+		client.Disconnect()
+		stop()
+
+		if n := countRecords(&sent); n != tt.sent+1 { // and the close_notify alert
+			t.Errorf("%s: expected the client to send %d records, got %d", tt.name, tt.sent, n-1)
+		}
+		if n := countRecords(&received); n != tt.received {
+			t.Errorf("%s: expected the client to receive %d records, got %d", tt.name, tt.received, n)
+		}
+	}
+}
+
+// Test_e2e_ExportKeyingMaterial checks that client and server derive the same keying material and channel binding, in
+// a full and in a resumed handshake, and that it differs between connections.
+func Test_e2e_ExportKeyingMaterial(t *testing.T) {
+	const ekmLen = 48
+	exportAll := func(conn *internal.Conn) ([]byte, error) {
+		ekm, err := conn.ExportKeyingMaterial("EXPORTER-test", []byte("context"), ekmLen)
+		if err != nil {
+			return nil, err
+		}
+		binding, err := conn.ChannelBinding()
+		return append(ekm, binding...), err
+	}
+	srv := internal.Server{
+		Config: testServerConfig(nil),
+		Handler: internal.HandlerFunc(func(conn *internal.Conn) error {
+			ekm, err := exportAll(conn)
+			if err != nil {
+				return err
+			}
+			_, err = conn.Write(ekm)
+			return err
+		}),
+	}
+	dial, stop := servePipe(t, &srv)
+	defer stop()
+
+	var previous []byte
+	client := internal.Client{Config: testClientConfig(nil), Dial: dial}
+	for i := 0; i < 2; i++ {
+		if err := client.Connect("127.0.0.1", 0); err != nil {
+			t.Fatal(err)
+		}
+		ekm, err := exportAll(client.Conn())
+		if err != nil {
+			t.Fatal(err)
+		}
+		serverEKM, err := io.ReadAll(client.Conn())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ekm) != ekmLen+32 || !bytes.Equal(ekm, serverEKM) {
+			t.Errorf("connection %d: client and server keying material differ", i)
+		}
+		if bytes.Equal(ekm, previous) {
+			t.Errorf("connection %d: keying material is the same as of the previous connection", i)
+		}
+		if i == 1 && !client.Conn().DidResume() {
+			t.Error("second connection did not resume")
+		}
+		previous = ekm
+		client.Disconnect()
+	}
+}
+
+func Test_e2e_ALPN(t *testing.T) {
+	// The handler dispatches on the negotiated protocol, like a server which multiplexes protocols on one port:
+	srv := internal.Server{
+		Config: testServerConfig(&internal.Config{NextProtos: []string{"h2", "http/1.1"}}),
+		Handler: internal.HandlerFunc(func(conn *internal.Conn) error {
+			var err error
+			switch conn.NegotiatedProtocol() {
+			case "h2":
+				_, err = conn.Write([]byte("h2 handler"))
+			case "http/1.1":
+				_, err = conn.Write([]byte("http/1.1 handler"))
+			default:
+				_, err = conn.Write([]byte("default handler"))
+			}
+			return err
+		}),
+	}
+	dial, stop := servePipe(t, &srv)
+	defer stop()
+
+	tests := []struct {
+		name       string
+		nextProtos []string
+		want       string
+	}{
+		{"server preference", []string{"http/1.1", "h2"}, "h2"},
+		{"single common protocol", []string{"spdy/3", "http/1.1"}, "http/1.1"},
+		{"client without alpn", nil, ""},
+	}
+	for _, tt := range tests {
+		client := internal.Client{Config: testClientConfig(&internal.Config{NextProtos: tt.nextProtos}), Dial: dial}
+		if err := client.Connect("127.0.0.1", 0); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := client.Conn().NegotiatedProtocol(); got != tt.want {
+			t.Errorf("%s: client negotiated %q, want %q", tt.name, got, tt.want)
+		}
+		reply, err := io.ReadAll(client.Conn())
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		want := tt.want
+		if want == "" {
+			want = "default"
+		}
+		if string(reply) != want+" handler" {
+			t.Errorf("%s: got reply %q", tt.name, reply)
+		}
+		client.Disconnect()
+	}
+
+	// The handshake fails with no_application_protocol without a common protocol:
+	client := internal.Client{Config: testClientConfig(&internal.Config{NextProtos: []string{"spdy/3"}}), Dial: dial}
+	if err := client.Connect("127.0.0.1", 0); err == nil {
+		t.Error("expected the handshake to fail without a common application protocol")
+		client.Disconnect()
+	}
+
+	// A server without protocols ignores the extension:
+	plain := internal.Server{
+		Config: testServerConfig(nil),
+		Handler: internal.HandlerFunc(func(conn *internal.Conn) error {
+			_, err := conn.Write([]byte(conn.NegotiatedProtocol()))
+			return err
+		}),
+	}
+	plainDial, stopPlain := servePipe(t, &plain)
+	defer stopPlain()
+
+	client = internal.Client{Config: testClientConfig(&internal.Config{NextProtos: []string{"h2"}}), Dial: plainDial}
+	if err := client.Connect("127.0.0.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := io.ReadAll(client.Conn())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Conn().NegotiatedProtocol() != "" || len(reply) != 0 {
+		t.Errorf("expected no protocol, got %q and %q", client.Conn().NegotiatedProtocol(), reply)
+	}
+	client.Disconnect()
+
+	client = internal.Client{Config: testClientConfig(&internal.Config{NextProtos: []string{""}}), Dial: plainDial}
+	if err := client.Connect("127.0.0.1", 0); err == nil {
+		t.Error("expected an empty protocol name to be rejected")
+		client.Disconnect()
+	}
+}
+
+func Test_e2e_ServerNameIndication(t *testing.T) {
+	apiCert, err := certs.GenerateSigned(testCA, "api.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wwwCert, err := certs.GenerateSigned(testCA, "www.test", "*.www.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := internal.HandlerFunc(func(conn *internal.Conn) error {
+		_, err := conn.Write([]byte(conn.ServerName()))
+		return err
+	})
+	// The certificate is picked from Config.Certificates by the server name. Runs over TCP, the alert of a client which
+	// rejects the certificate would block on net.Pipe until the server is done writing.
+	srv := internal.Server{
+		Config:  &internal.Config{Certificates: []tls.Certificate{testServerCert, apiCert, wwwCert}},
+		Handler: handler,
+	}
+	address, port, stop := serveTCP(t, &srv)
+	defer stop()
+
+	connect := func(dial func(network, address string) (net.Conn, error), serverName string) (string, error) {
+		client := internal.Client{Config: testClientConfig(&internal.Config{ServerName: serverName}), Dial: dial}
+		if err := client.Connect(address, port); err != nil {
+			return "", err
+		}
+		defer client.Disconnect()
+		reply, err := io.ReadAll(client.Conn())
+		return string(reply), err
+	}
+
+	for _, name := range []string{"api.test", "www.test", "static.www.test", "localhost"} {
+		reply, err := connect(nil, name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if reply != name {
+			t.Errorf("%s: server got server name %q", name, reply)
+		}
+	}
+	// An unknown name gets the first certificate, which is not valid for it:
+	var hostname x509.HostnameError
+	if _, err := connect(nil, "unknown.test"); !errors.As(err, &hostname) {
+		t.Errorf("expected a host name error, got %v", err)
+	}
+
+	// The certificate is picked by GetCertificate from a map of virtual hosts:
+	hosts := map[string]*tls.Certificate{"api.test": &apiCert, "www.test": &wwwCert}
+	mapSrv := internal.Server{
+		Config: &internal.Config{
+			GetCertificate: func(hello *internal.ClientHelloInfo) (*tls.Certificate, error) {
+				if cert, ok := hosts[hello.ServerName]; ok {
+					return cert, nil
+				}
+				return nil, fmt.Errorf("unknown host %q", hello.ServerName)
+			},
+		},
+		Handler: handler,
+	}
+	mapDial, stopMap := servePipe(t, &mapSrv)
+	defer stopMap()
+
+	for _, name := range []string{"api.test", "WWW.test."} {
+		reply, err := connect(mapDial, name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if want := strings.TrimSuffix(strings.ToLower(name), "."); reply != want {
+			t.Errorf("%s: server got server name %q, want %q", name, reply, want)
+		}
+	}
+	if _, err := connect(mapDial, "unknown.test"); err == nil {
+		t.Error("expected the handshake to fail for a host without a certificate")
+	}
+}
+
+// tenantExtensionType is a private use extension which carries the tenant of a client.
+const tenantExtensionType extensions.ExtensionType = 0xff10
+
+type tenantExtension struct {
+	tenant string
+}
+
+func parseTenantExtension(buf []byte, ctx extensions.MsgContext) (extensions.Extension, error) {
+	raw, err := extensions.ParseRawExtension(buf)
+	if err != nil {
+		return nil, err
+	}
+	return &tenantExtension{tenant: string(raw.Data)}, nil
+}
+
+func (e *tenantExtension) GetType() extensions.ExtensionType { return tenantExtensionType }
+
+func (e *tenantExtension) ToBinary() []byte {
+	raw := &extensions.RawExtension{Type: tenantExtensionType, ExtensionLen: uint16(len(e.tenant)), Data: []byte(e.tenant)}
+	return raw.ToBinary()
+}
+
+func (e *tenantExtension) GetFullExtLen() int { return len(e.tenant) + 4 }
+
+func Test_e2e_CustomExtension(t *testing.T) {
+	err := extensions.RegisterExtension(tenantExtensionType, parseTenantExtension,
+		extensions.ClientHelloMsgContext, extensions.EncryptedExtensionsMsgContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The handler gets the tenant of the client and the server acknowledges it in EncryptedExtensions:
+	srv := internal.Server{
+		Config: testServerConfig(&internal.Config{Extensions: []extensions.Extension{&tenantExtension{"ack"}}}),
+		Handler: internal.HandlerFunc(func(conn *internal.Conn) error {
+			var tenant string
+			for _, ext := range conn.Extensions() {
+				tenant = ext.(*tenantExtension).tenant
+			}
+			_, err := conn.Write([]byte(tenant))
+			return err
+		}),
+	}
+	dial, stop := servePipe(t, &srv)
+	defer stop()
+
+	cfg := testClientConfig(&internal.Config{Extensions: []extensions.Extension{&tenantExtension{"tenant-42"}}})
+	client := internal.Client{Config: cfg, Dial: dial}
+	if err := client.Connect("127.0.0.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := io.ReadAll(client.Conn())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != "tenant-42" {
+		t.Errorf("server got tenant %q", reply)
+	}
+	exts := client.Conn().Extensions()
+	if len(exts) != 1 || exts[0].(*tenantExtension).tenant != "ack" {
+		t.Errorf("client got custom extensions %v", exts)
+	}
+	client.Disconnect()
+
+	// The server doesn't send the extension to a client which didn't send it:
+	client = internal.Client{Config: testClientConfig(nil), Dial: dial}
+	if err := client.Connect("127.0.0.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := io.ReadAll(client.Conn()); err != nil || len(reply) != 0 {
+		t.Errorf("server got tenant %q, %v", reply, err)
+	}
+	if exts := client.Conn().Extensions(); len(exts) != 0 {
+		t.Errorf("client got unsolicited custom extensions %v", exts)
+	}
+	client.Disconnect()
+}
+
+func Test_e2e_CryptoTLSInterop(t *testing.T) {
+	const label = "EXPORTER-interop"
+
+	// A crypto/tls client offers TLS 1.2 cipher suites, several versions and a session id, and sends a
+	// change_cipher_spec record:
+	srv := internal.Server{
+		Config: testServerConfig(&internal.Config{NextProtos: []string{"h2"}}),
+		Handler: internal.HandlerFunc(func(conn *internal.Conn) error {
+			ekm, err := conn.ExportKeyingMaterial(label, nil, 32)
+			if err != nil {
+				return err
+			}
+			_, err = conn.Write(ekm)
+			return err
+		}),
+	}
+	dial, stop := servePipe(t, &srv)
+	defer stop()
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(testCA.Leaf)
+	raw, err := dial("pipe", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsClient := tls.Client(raw, &tls.Config{ServerName: "localhost", RootCAs: rootCAs, NextProtos: []string{"h2"}})
+	reply, err := io.ReadAll(tlsClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := tlsClient.ConnectionState()
+	if state.Version != tls.VersionTLS13 || state.NegotiatedProtocol != "h2" {
+		t.Errorf("crypto/tls client negotiated version %#04x and protocol %q", state.Version, state.NegotiatedProtocol)
+	}
+	ekm, err := state.ExportKeyingMaterial(label, nil, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ekm, reply) {
+		t.Error("crypto/tls client and server keying material differ")
+	}
+	_ = tlsClient.Close()
+
+	// The client against a crypto/tls server, which sends a change_cipher_spec record after the ServerHello:
+	tlsServerCfg := &tls.Config{Certificates: []tls.Certificate{testServerCert}, MinVersion: tls.VersionTLS13}
+	serverEKM := make(chan []byte, 1)
+	client := internal.Client{
+		Config: testClientConfig(nil),
+		Dial: func(_, _ string) (net.Conn, error) {
+			clientConn, serverConn := net.Pipe()
+			go func() {
+				defer close(serverEKM)
+				tlsServer := tls.Server(serverConn, tlsServerCfg)
+				defer tlsServer.Close()
+				if err := tlsServer.Handshake(); err != nil {
+					t.Error(err)
+					return
+				}
+				state := tlsServer.ConnectionState()
+				ekm, err := state.ExportKeyingMaterial(label, nil, 32)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				serverEKM <- ekm
+				_, _ = io.Copy(io.Discard, tlsServer) // until the close_notify of the client
+			}()
+			return clientConn, nil
+		},
+	}
+	if err := client.Connect("127.0.0.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	ekm, err = client.Conn().ExportKeyingMaterial(label, nil, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ekm, <-serverEKM) {
+		t.Error("client and crypto/tls server keying material differ")
+	}
+	client.Disconnect()
+}
+
+func Test_e2e_PanicIsolation(t *testing.T) {
+	// The handler panics for clients which negotiate the "panic" protocol:
+	srv := internal.Server{
+		Config: testServerConfig(&internal.Config{NextProtos: []string{"panic", "serve"}}),
+		Handler: internal.HandlerFunc(func(conn *internal.Conn) error {
+			if conn.NegotiatedProtocol() == "panic" {
+				panic("handler panic")
+			}
+			_, err := conn.Write([]byte("served"))
+			return err
+		}),
+	}
+	dial, stop := servePipe(t, &srv)
+	defer stop()
+
+	// A ClientHello with a trailing byte counted in its length is a decode_error:
+	clientHello := tlstypes.MakeClientHelloMessage([]tlstypes.CipherSuite{tlstypes.TLS_AES_128_GCM_SHA256},
+		&tlstypes.ClientHelloExtParams{}).ToBinary()
+	clientHello = append(clientHello, 0x00)
+	clientHello[3]++
+	raw, err := dial("pipe", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tlstypes.MakePlaintextRecord(tlstypes.HandshakeRecord, clientHello).WriteTo(raw); err != nil {
+		t.Fatal(err)
+	}
+	record, err := tlstypes.NewRecordReader(raw).ReadRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alert, err := tlstypes.ParseAlert(record.Data)
+	if err != nil || alert.Description != tlstypes.DecodeError {
+		t.Errorf("expected a decode_error alert, got %v, %v", alert, err)
+	}
+	raw.Close()
+
+	// A panic of the Handler closes only its connection:
+	tests := []struct {
+		proto string
+		want  string
+	}{
+		{"panic", ""},
+		{"serve", "served"},
+	}
+	for _, tt := range tests {
+		client := internal.Client{Config: testClientConfig(&internal.Config{NextProtos: []string{tt.proto}}), Dial: dial}
+		if err := client.Connect("127.0.0.1", 0); err != nil {
+			t.Fatal(err)
+		}
+		reply, _ := io.ReadAll(client.Conn())
+		if string(reply) != tt.want {
+			t.Errorf("%s: got reply %q, want %q", tt.proto, reply, tt.want)
+		}
+		client.Disconnect()
+	}
+}
+
+// recordingConn copies everything written to the connection into w and, if r is set, everything read from it into r.
+type recordingConn struct {
+	net.Conn
+	w io.Writer
+	r io.Writer
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	_, _ = c.w.Write(b)
+	return c.Conn.Write(b)
+}
+
+func (c *recordingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if c.r != nil {
+		_, _ = c.r.Write(b[:n])
+	}
+	return n, err
+}
+
+// writeStartConn calls onLargeWrite when a write of more than 1024 bytes starts, the handshake records are smaller.
+type writeStartConn struct {
+	net.Conn
+	onLargeWrite func()
+}
+
+func (c *writeStartConn) Write(b []byte) (int, error) {
+	if len(b) > 1024 {
+		c.onLargeWrite()
+	}
+	return c.Conn.Write(b)
+}
+
+// serveTCP runs srv on an ephemeral port of 127.0.0.1 and returns the address to connect to, with a function which
+// shuts it down and waits for Serve to return. The listener is bound before serveTCP returns, clients can dial at once.
+func serveTCP(t *testing.T, srv *internal.Server) (address string, port uint16, stop func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(l)
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	return addr.IP.String(), uint16(addr.Port), func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			t.Error(err)
+		}
+		if err := <-serveErr; err != internal.ServerClosedErr {
+			t.Errorf("expected ServerClosedErr, got %v", err)
+		}
+	}
+}
+
+// servePipe runs srv on an in-memory listener and returns a dial function for internal.Client, which connects to it
+// over net.Pipe, and a function which shuts the server down.
+func servePipe(t *testing.T, srv *internal.Server) (dial func(network, address string) (net.Conn, error), stop func()) {
+	l := newPipeListener()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(l)
+	}()
+
+	return l.Dial, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			t.Error(err)
+		}
+		if err := <-serveErr; err != internal.ServerClosedErr {
+			t.Errorf("expected ServerClosedErr, got %v", err)
+		}
+	}
+}
+
+// pipeListener is an in-memory net.Listener. Dial connects to it with net.Pipe.
+type pipeListener struct {
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ net.Listener = (*pipeListener)(nil) // interface compliance check
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *pipeListener) Dial(_, _ string) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr{} }
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+
+func (pipeAddr) String() string { return "pipe" }
+
+// testCA issues the certificates of the test servers. The clients trust it through testClientConfig.
+var testCA, testServerCert = newTestPKI()
+
+func newTestPKI() (ca, server tls.Certificate) {
+	ca, err := certs.GenerateCA("tls-handshake test CA")
+	if err != nil {
+		panic(err)
+	}
+	server, err = certs.GenerateSigned(ca, "localhost", "127.0.0.1")
+	if err != nil {
+		panic(err)
+	}
+	return ca, server
+}
+
+// testServerConfig sets the certificate issued by testCA in cfg, a nil cfg is replaced with an empty one.
+func testServerConfig(cfg *internal.Config) *internal.Config {
+	if cfg == nil {
+		cfg = &internal.Config{}
+	}
+	cfg.Certificates = []tls.Certificate{testServerCert}
+	return cfg
+}
+
+// testClientConfig sets testCA as the only root CA in cfg, a nil cfg is replaced with an empty one.
+func testClientConfig(cfg *internal.Config) *internal.Config {
+	if cfg == nil {
+		cfg = &internal.Config{}
+	}
+	cfg.RootCAs = x509.NewCertPool()
+	cfg.RootCAs.AddCert(testCA.Leaf)
+	return cfg
+}
@@ -2,4 +2,7 @@ module github.com/tls-handshake
 
 go 1.16
 
-require golang.org/x/crypto v0.0.0-20210513164829-c07d793c2f9a
+require (
+	golang.org/x/crypto v0.0.0-20210513164829-c07d793c2f9a
+	golang.org/x/sys v0.0.0-20201119102817-f84b799fce68
+)
@@ -0,0 +1,154 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"time"
+)
+
+const selfSignedValidFor = time.Hour * 24 * 365
+
+// GenerateSelfSigned creates a self-signed ECDSA P-256 certificate which is valid for the given hosts. A host can be
+// either a DNS name or an IP address.
+func GenerateSelfSigned(hosts ...string) (tls.Certificate, error) {
+	template, err := newTemplate("tls-handshake self-signed", hosts)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template.KeyUsage |= x509.KeyUsageCertSign
+	template.BasicConstraintsValid = true
+	template.IsCA = true
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return generate(template, priv, nil, nil)
+}
+
+// GenerateCA creates a self-signed ECDSA P-256 certificate authority, which can issue certificates with
+// GenerateSigned.
+func GenerateCA(commonName string) (tls.Certificate, error) {
+	template, err := newTemplate(commonName, nil)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	template.ExtKeyUsage = nil
+	template.BasicConstraintsValid = true
+	template.IsCA = true
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return generate(template, priv, nil, nil)
+}
+
+// GenerateSigned creates an ECDSA P-256 certificate which is valid for the given hosts and is signed by ca. It can
+// authenticate both servers and clients. The returned chain holds the leaf certificate followed by the certificate of
+// ca.
+func GenerateSigned(ca tls.Certificate, hosts ...string) (tls.Certificate, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return GenerateSignedWithKey(ca, priv, hosts...)
+}
+
+// GenerateSignedWithKey is like GenerateSigned, but the certificate is issued for priv, e.g. an RSA or Ed25519 key.
+func GenerateSignedWithKey(ca tls.Certificate, priv crypto.Signer, hosts ...string) (tls.Certificate, error) {
+	if len(ca.Certificate) == 0 {
+		return tls.Certificate{}, errors.New("certificate authority has no certificate")
+	}
+	caCert := ca.Leaf
+	if caCert == nil {
+		var err error
+		if caCert, err = x509.ParseCertificate(ca.Certificate[0]); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+	template, err := newTemplate("tls-handshake", hosts)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+	cert, err := generate(template, priv, caCert, ca.PrivateKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert.Certificate = append(cert.Certificate, ca.Certificate...)
+	return cert, nil
+}
+
+// LoadCertPool reads the PEM encoded certificates from the given files into a new pool, e.g. to be used as root
+// certificate authorities.
+func LoadCertPool(paths ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, p := range paths {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("no certificates found in " + p)
+		}
+	}
+	return pool, nil
+}
+
+func newTemplate(commonName string, hosts []string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(selfSignedValidFor),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	return template, nil
+}
+
+// generate creates a certificate for priv from template, which is signed by parent. The certificate is self-signed when
+// parent is nil.
+func generate(template *x509.Certificate, priv crypto.Signer, parent *x509.Certificate, parentKey crypto.PrivateKey) (tls.Certificate, error) {
+	if parent == nil {
+		parent, parentKey = template, priv
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, priv.Public(), parentKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	ret := tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  priv,
+		Leaf:        leaf,
+	}
+	return ret, nil
+}
@@ -0,0 +1,61 @@
+package certs
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateSigned(t *testing.T) {
+	ca, err := GenerateCA("test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := GenerateSigned(ca, "localhost", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.Certificate) != 2 {
+		t.Fatalf("GenerateSigned returned a chain of %d certificates", len(cert.Certificate))
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	for _, host := range []string{"localhost", "127.0.0.1"} {
+		if _, err := cert.Leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: host}); err != nil {
+			t.Errorf("certificate is invalid for %s: %v", host, err)
+		}
+	}
+	if _, err := cert.Leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: "127.0.0.2"}); err == nil {
+		t.Errorf("certificate is valid for a host it was not issued for")
+	}
+}
+
+func TestLoadCertPool(t *testing.T) {
+	ca, err := GenerateCA("test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]})
+	if err := ioutil.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCertPool(caFile); err != nil {
+		t.Errorf("LoadCertPool is broken: %v", err)
+	}
+
+	emptyFile := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(emptyFile, []byte("no certificates"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCertPool(emptyFile); err == nil {
+		t.Errorf("LoadCertPool accepts a file without certificates")
+	}
+	if _, err := LoadCertPool(filepath.Join(dir, "missing.pem")); err == nil {
+		t.Errorf("LoadCertPool accepts a missing file")
+	}
+}
@@ -4,12 +4,11 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"strconv"
 	"time"
 
-	"github.com/tls-handshake/internal/suite"
-	tlstypes "github.com/tls-handshake/internal/tls_types"
-	cbytes "github.com/tls-handshake/pkg/bytes"
 	limitconn "github.com/tls-handshake/pkg/limit_conn"
 	"github.com/tls-handshake/pkg/rand"
 )
@@ -18,48 +17,162 @@ const (
 	clientHandshakeLimit = time.Minute
 )
 
+// MissingServerNameErr is returned by ConnectConn when the server certificate can't be verified, because the config
+// has neither a ServerName nor InsecureSkipVerify.
+var MissingServerNameErr = errors.New("either ServerName or InsecureSkipVerify must be set in the config")
+
 type Client struct {
-	rawConn *limitconn.Wrapper
-	handshake *clientHandshake
+	Config *Config
+	// Dial opens the underlying connection in Connect. If it's nil net.Dial is used.
+	Dial     func(network, address string) (net.Conn, error)
+	rawConn  *limitconn.Wrapper
+	conn     *Conn
+	sessions ClientSessionCache // used when the config has no ClientSessionCache
 }
 
+// Connect dials the TCP address ipv4:port and performs the handshake. The server certificate must be valid for ipv4,
+// unless Config.ServerName is set. A session of an earlier connection to the same address is resumed if possible.
 func (c *Client) Connect(ipv4 string, port uint16) error {
-	addrss := fmt.Sprintf("%s:%d", ipv4, port)
-	conn, err := net.Dial("tcp", addrss)
+	return c.ConnectWithEarlyData(ipv4, port, nil)
+}
+
+// ConnectWithEarlyData is like Connect, but when a session with the server can be resumed, earlyData is sent as 0-RTT
+// data together with the ClientHello. It saves a round trip if the server accepts it, otherwise earlyData is sent
+// again once the handshake is done. Early data can be replayed by an attacker, so it must be safe to process twice.
+func (c *Client) ConnectWithEarlyData(ipv4 string, port uint16, earlyData []byte) error {
+	dial := c.Dial
+	if dial == nil {
+		dial = net.Dial
+	}
+	addrss := net.JoinHostPort(ipv4, strconv.Itoa(int(port)))
+	conn, err := dial("tcp", addrss)
 	if err != nil {
 		return err
 	}
 
 	fmt.Printf("client connection on %d\n", port)
+	return c.connect(conn, ipv4, addrss, earlyData)
+}
+
+// ConnectConn performs the handshake over an already established connection. The Client takes ownership of conn and
+// closes it on failure or on Disconnect. Since the address of the server is not known, the config must have a
+// ServerName to verify the server certificate. Sessions are cached by the remote address of conn.
+func (c *Client) ConnectConn(conn net.Conn) error {
+	return c.connect(conn, "", "", nil)
+}
+
+// connect performs the handshake over conn, serverName is used to verify the server certificate when the config has
+// no ServerName. The session stored for sessionKey is offered for resumption and new sessions are stored for it, an
+// empty sessionKey is replaced with the remote address of conn. The earlyData is sent as soon as possible.
+func (c *Client) connect(conn net.Conn, serverName, sessionKey string, earlyData []byte) error {
+	c.conn = nil
+	if name := c.Config.serverName(); name != "" {
+		serverName = name
+	}
+	if serverName == "" && !c.Config.insecureSkipVerify() {
+		conn.Close()
+		return MissingServerNameErr
+	}
+	if err := c.Config.checkNextProtos(); err != nil {
+		conn.Close()
+		return err
+	}
+
 	c.rawConn = limitconn.Wrap(conn, "client_"+rand.GenString(32))
 	c.rawConn.SetLimit(clientHandshakeLimit)
-	c.handshake = NewClientHandshake(c.rawConn)
-	if err := c.handshake.Handshake(); err != nil {
+	if sessionKey == "" && conn.RemoteAddr() != nil {
+		sessionKey = conn.RemoteAddr().String()
+	}
+	cache := c.sessionCache()
+	session := c.loadSession(cache, sessionKey, serverName)
+	handshake := NewClientHandshake(c.rawConn, c.Config, serverName, session, earlyData)
+	if err := handshake.Handshake(); err != nil {
 		c.rawConn.Close()
 		return err
 	}
 
+	c.conn = newConn(c.rawConn, handshake.records, handshake.peerCertificates, c.Config)
+	c.conn.didResume = handshake.usingPSK
+	c.conn.earlyDataAccepted = handshake.earlyDataAccepted
+	c.conn.cipherSuite = handshake.cipherSuite
+	c.conn.exporterMasterSecret = handshake.exporterMasterSecret
+	c.conn.negotiatedProtocol = handshake.alpnProtocol
+	c.conn.serverName = handshake.hostName()
+	c.conn.peerExtensions = handshake.peerExtensions
+	if cache != nil {
+		c.conn.tickets = &ticketReceiver{
+			cache:            cache,
+			sessionKey:       sessionKey,
+			serverName:       serverName,
+			cipherSuite:      handshake.cipherSuite,
+			resumptionSecret: handshake.resumptionSecret,
+			alpnProtocol:     handshake.alpnProtocol,
+			peerCertificates: handshake.peerCertificates,
+			verified:         handshake.verified,
+			now:              c.Config.now,
+		}
+	}
+	if len(earlyData) > 0 && !handshake.earlyDataAccepted {
+		if _, err := c.conn.Write(earlyData); err != nil {
+			c.conn.Close()
+			c.conn = nil
+			return err
+		}
+	}
 	return nil
 }
 
-func (s *Client) Ping() error {
-	plaintext := []byte("PING")
-	nonce := cbytes.UInt64ToBytes(s.handshake.seq)
-	ciphertext, err := suite.Encrypt(plaintext, s.handshake.clientHandshakeKey, nonce)
-	if err != nil {
-		return err
+// sessionCache returns the cache of the config or of the client, it's nil if session tickets are disabled.
+func (c *Client) sessionCache() ClientSessionCache {
+	if c.Config.sessionTicketsDisabled() {
+		return nil
 	}
-	ciphertext, err = suite.Encrypt(ciphertext, cbytes.Xor(s.handshake.clientHandshakeIv, nonce), nonce)
-	if err != nil {
-		return err
+	if c.Config != nil && c.Config.ClientSessionCache != nil {
+		return c.Config.ClientSessionCache
+	}
+	if c.sessions == nil {
+		c.sessions = NewLRUClientSessionCache(0)
+	}
+	return c.sessions
+}
+
+// loadSession returns the session stored for sessionKey, if it's still valid for serverName. A resumed handshake has
+// no certificate messages, so a session whose certificate wasn't verified isn't used by a client which verifies
+// certificates. Sessions which expired, or whose server certificate expired, are removed from the cache.
+func (c *Client) loadSession(cache ClientSessionCache, sessionKey, serverName string) *ClientSessionState {
+	if cache == nil {
+		return nil
+	}
+	session, ok := cache.Get(sessionKey)
+	if !ok || session == nil {
+		return nil
+	}
+	now := c.Config.now()
+	if session.expired(now) ||
+		(len(session.peerCertificates) > 0 && now.After(session.peerCertificates[0].NotAfter)) {
+		cache.Put(sessionKey, nil)
+		return nil
+	}
+	if session.serverName != serverName {
+		return nil
+	}
+	if !c.Config.insecureSkipVerify() && !session.verified {
+		return nil
 	}
-	_, err = s.rawConn.Write(ciphertext)
+	return session
+}
+
+// Conn returns the secure channel established by Connect.
+func (c *Client) Conn() *Conn {
+	return c.conn
+}
+
+func (s *Client) Ping() error {
+	_, err := s.conn.Write([]byte("PING"))
 	if err != nil {
 		return err
 	}
 
-	s.handshake.seq++
-
 	// Receive PONG resonse:
 	if err := s.recv(); err != nil {
 		return err
@@ -68,22 +181,9 @@ func (s *Client) Ping() error {
 	return nil
 }
 
-
 func (c *Client) recv() error {
-	var data [tlstypes.MaxSizeOfPlaintextRecord]byte
-	n, err := c.rawConn.Read(data[:])
-	if err != nil {
-		return err
-	}
-
-	ciphertext := data[:n]
-	nonce := cbytes.UInt64ToBytes(c.handshake.seq)
-	ciphertext, err = suite.Decrypt(ciphertext, cbytes.Xor(c.handshake.serverHandshakeIv, nonce), nonce)
-	if err != nil {
-		return err
-	}
-	plaintext, err := suite.Decrypt(ciphertext, c.handshake.serverHandshakeKey, nonce)
-	if err != nil {
+	plaintext := make([]byte, len("PONG"))
+	if _, err := io.ReadFull(c.conn, plaintext); err != nil {
 		return err
 	}
 
@@ -94,10 +194,16 @@ func (c *Client) recv() error {
 		return errors.New("unsupported response message")
 	}
 
-	c.handshake.seq++
 	return nil
 }
 
 func (c *Client) Disconnect() {
-	_ = c.rawConn.Close()
+	if c.rawConn == nil {
+		return
+	}
+	if c.conn == nil {
+		_ = c.rawConn.Close()
+		return
+	}
+	_ = c.conn.Close()
 }
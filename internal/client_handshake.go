@@ -1,40 +1,77 @@
 package internal
 
 import (
-	"crypto/ecdsa"
+	"bytes"
+	"crypto"
+	"crypto/hmac"
 	crand "crypto/rand"
-	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"hash"
 	"net"
+	"strings"
+	"time"
 
 	"github.com/tls-handshake/internal/common"
 	"github.com/tls-handshake/internal/ecdh"
 	"github.com/tls-handshake/internal/suite"
 	tlstypes "github.com/tls-handshake/internal/tls_types"
 	"github.com/tls-handshake/internal/tls_types/extensions"
-	"github.com/tls-handshake/pkg/streams"
+	typesizes "github.com/tls-handshake/pkg/type_sizes"
 )
 
 type clientHandshake struct {
-	rawConn     net.Conn
+	records     *recordLayer
+	config      *Config
+	serverName  string // the server certificate must be valid for it
 	clientHello *tlstypes.ClientHelloMsg
 	serverHello *tlstypes.ServerHelloMsg
-	seq         uint64
+	cipherSuite *suite.CipherSuite
+	transcript  hash.Hash // nil until the cipher suite, and so the transcript hash, is known
 
-	clientPrivateKey   *ecdsa.PrivateKey
-	serverPubKeyBytes  []byte
-	serverPubicKey     *ecdsa.PublicKey
-	clientHandshakeKey []byte
-	serverHandshakeKey []byte
-	clientHandshakeIv  []byte
-	serverHandshakeIv  []byte
+	group             ecdh.Group
+	clientPrivateKey  crypto.PrivateKey
+	serverPubKeyBytes []byte
+
+	handshakeSecret                []byte
+	masterSecret                   []byte
+	clientHandshakeTrafficSecret   []byte
+	serverHandshakeTrafficSecret   []byte
+	clientApplicationTrafficSecret []byte
+	serverApplicationTrafficSecret []byte
+	exporterMasterSecret           []byte
+
+	peerCertificates   []*x509.Certificate
+	verified           bool                            // the chain was verified, here or in the handshake of the session
+	certificateRequest *tlstypes.CertificateRequestMsg // nil if the server did not ask for a client certificate
+	certificate        *tls.Certificate                // sent in answer to the certificate request, nil if none
+	signatureScheme    tls.SignatureScheme             // used to sign the client CertificateVerify
+	alpnProtocol       string                          // selected by the server, empty if none
+	peerExtensions     []extensions.Extension          // custom extensions of EncryptedExtensions
+
+	session          *ClientSessionState // offered for resumption, nil if there is none
+	pskOffered       bool                // the last ClientHello offered the session
+	usingPSK         bool                // the server accepted the session
+	resumptionSecret []byte
+
+	earlyData         []byte // sent with the first ClientHello if the session allows it
+	earlyDataOffered  bool   // the early data was sent and the early traffic keys protect the sent records
+	earlyDataAccepted bool
 }
 
-func NewClientHandshake(conn net.Conn) *clientHandshake {
+// NewClientHandshake creates the handshake of a client, which tries to resume session if it's not nil. The earlyData
+// is sent as 0-RTT data with the ClientHello when the session allows it.
+func NewClientHandshake(conn net.Conn, config *Config, serverName string, session *ClientSessionState,
+	earlyData []byte) *clientHandshake {
+
 	ret := &clientHandshake{
-		rawConn: conn,
+		records:    newRecordLayer(conn),
+		config:     config,
+		serverName: serverName,
+		session:    session,
+		earlyData:  earlyData,
 	}
 	return ret
 }
@@ -44,34 +81,105 @@ func (c *clientHandshake) Handshake() error {
 	if err := c.genClientKey(cfg); err != nil {
 		return err
 	}
+	cfg.ServerName = c.hostName()
+	cfg.ALPNProtocols = c.config.nextProtos()
+	cfg.Extensions = c.config.extensions()
+	c.offerSession(cfg)
 	if err := c.writeClientHelloMsg(cfg); err != nil {
 		return err
 	}
+	if cfg.EarlyData {
+		if err := c.writeEarlyData(); err != nil {
+			return err
+		}
+	}
 	if err := c.readServerHelloMsg(); err != nil {
+		c.sendFatalAlert(alertFor(err, tlstypes.UnexpectedMessage))
 		return err
 	}
 
-	sharedKey, err := ecdh.GenerateSharedSecret(c.clientPrivateKey, c.serverPubicKey)
+	sharedKey, err := c.group.SharedSecret(c.clientPrivateKey, c.serverPubKeyBytes)
 	if err != nil {
+		c.sendFatalAlert(tlstypes.IllegalParameter)
 		return err
 	}
 
-	helloHash, err := c.calculateHandshakeHash()
-	if err != nil {
+	var psk []byte
+	if c.usingPSK {
+		psk = c.session.secret
+	}
+	earlySecret := c.cipherSuite.Extract(psk, nil)
+	derivedSecret := c.cipherSuite.DeriveSecret(earlySecret, suite.DerivedLabel, nil)
+	handshakeSecret := c.cipherSuite.Extract(sharedKey, derivedSecret)
+	clientHandshakeTrafficSecret := c.cipherSuite.DeriveSecret(handshakeSecret, suite.ClientHandshakeTrafficLabel, c.transcript)
+	serverHandshakeTrafficSecret := c.cipherSuite.DeriveSecret(handshakeSecret, suite.ServerHandshakeTrafficLabel, c.transcript)
+
+	// save state:
+	c.handshakeSecret = handshakeSecret
+	c.clientHandshakeTrafficSecret = clientHandshakeTrafficSecret
+	c.serverHandshakeTrafficSecret = serverHandshakeTrafficSecret
+	if !c.earlyDataOffered {
+		c.records.setWriteKey(c.cipherSuite, clientHandshakeTrafficSecret, c.config.paddingBlockSize())
+	}
+	if err := c.records.setReadKey(c.cipherSuite, serverHandshakeTrafficSecret); err != nil {
+		c.sendFatalAlert(alertFor(err, tlstypes.UnexpectedMessage))
 		return err
 	}
 
-	c.seq = 0 // start counting records received
+	if err := c.readEncryptedExtensionsMsg(); err != nil {
+		c.sendFatalAlert(alertFor(err, tlstypes.DecodeError))
+		return err
+	}
+	if c.earlyDataOffered && !c.earlyDataAccepted {
+		c.records.setWriteKey(c.cipherSuite, clientHandshakeTrafficSecret, c.config.paddingBlockSize())
+	}
+	if c.usingPSK {
+		// The server proved that it knows the PSK, its certificate was checked in the handshake of the session.
+		c.peerCertificates = c.session.peerCertificates
+		c.verified = c.session.verified
+	} else {
+		if err := c.readCertificateMsg(); err != nil {
+			c.sendFatalAlert(alertFor(err, tlstypes.BadCertificate))
+			return err
+		}
+		if err := c.readCertificateVerifyMsg(); err != nil {
+			c.sendFatalAlert(alertFor(err, tlstypes.DecryptError))
+			return err
+		}
+	}
+	if err := c.readServerFinishedMsg(); err != nil {
+		c.sendFatalAlert(alertFor(err, tlstypes.DecryptError))
+		return err
+	}
+	c.deriveApplicationSecrets()
+	if c.earlyDataAccepted {
+		// RFC 8446, Section 4.5: EndOfEarlyData is the last message protected with the early traffic keys.
+		if err := c.writeHandshakeMsg(tlstypes.MakeEndOfEarlyDataMessage().ToBinary()); err != nil {
+			return err
+		}
+		c.records.setWriteKey(c.cipherSuite, c.clientHandshakeTrafficSecret, c.config.paddingBlockSize())
+	}
+	if c.certificateRequest != nil {
+		if err := c.writeCertificateMsg(); err != nil {
+			c.sendFatalAlert(tlstypes.InternalError)
+			return err
+		}
+		if err := c.writeCertificateVerifyMsg(); err != nil {
+			c.sendFatalAlert(tlstypes.InternalError)
+			return err
+		}
+	}
+	if err := c.writeFinishedMsg(); err != nil {
+		return err
+	}
+	c.resumptionSecret = c.cipherSuite.DeriveSecret(c.masterSecret, suite.ResumptionLabel, c.transcript)
 
-	earlySecret := suite.Extract(nil, nil)
-	derivedSecret := suite.DeriveSecret(earlySecret, "derived", nil)
-	handshakeSecret := suite.Extract(sharedKey, derivedSecret)
-	clientHandshakeTrafficSecret := suite.DeriveSecret(handshakeSecret, suite.ClientHandshakeTrafficLabel, helloHash)
-	serverHandshakeTrafficSecret := suite.DeriveSecret(handshakeSecret, suite.ServerHandshakeTrafficLabel, helloHash)
-	c.clientHandshakeKey = suite.DeriveSecret(clientHandshakeTrafficSecret, suite.KeyLabel, nil)
-	c.serverHandshakeKey = suite.DeriveSecret(serverHandshakeTrafficSecret, suite.KeyLabel, nil)
-	c.clientHandshakeIv = suite.DeriveSecret(clientHandshakeTrafficSecret, suite.IVLabel, nil)
-	c.serverHandshakeIv = suite.DeriveSecret(serverHandshakeTrafficSecret, suite.IVLabel, nil)
+	// Both sides have sent Finished, switch to the application traffic keys:
+	c.records.setWriteKey(c.cipherSuite, c.clientApplicationTrafficSecret, c.config.paddingBlockSize())
+	if err := c.records.setReadKey(c.cipherSuite, c.serverApplicationTrafficSecret); err != nil {
+		c.sendFatalAlert(alertFor(err, tlstypes.UnexpectedMessage))
+		return err
+	}
 
 	return nil
 }
@@ -79,95 +187,688 @@ func (c *clientHandshake) Handshake() error {
 func (c *clientHandshake) writeClientHelloMsg(cfg *tlstypes.ClientHelloExtParams) error {
 	common.AssertImpl(cfg != nil)
 
-	clientHelloMsg := tlstypes.MakeClientHelloMessage(cfg)
-	r := tlstypes.MakeClientHelloRecord(clientHelloMsg)
-	rBytes := r.ToBinary()
-	if err := streams.WriteAllBytes(c.rawConn, rBytes); err != nil {
+	clientHelloMsg := tlstypes.MakeClientHelloMessage(c.config.cipherSuites(), cfg)
+	if c.clientHello != nil {
+		// RFC 8446, Section 4.1.2: the second ClientHello keeps the random and session id of the first one.
+		clientHelloMsg.Random = c.clientHello.Random
+		clientHelloMsg.SessionID = c.clientHello.SessionID
+	}
+	if len(cfg.PskIdentities) > 0 {
+		if err := c.setPskBinder(clientHelloMsg, cfg.PskBinderLen); err != nil {
+			return err
+		}
+	}
+	raw := clientHelloMsg.ToBinary()
+	if err := c.records.writeRecord(tlstypes.HandshakeRecord, raw); err != nil {
 		return err
 	}
+	if c.transcript != nil {
+		// The first ClientHello is added once the server selects the cipher suite.
+		if _, err := c.transcript.Write(raw); err != nil {
+			return err
+		}
+	}
 
 	// save state:
 	c.clientHello = clientHelloMsg
+	c.pskOffered = len(cfg.PskIdentities) > 0
 
 	return nil
 }
 
-func (c *clientHandshake) readServerHelloMsg() error {
-	var buf [tlstypes.MaxSizeOfPlaintextRecord]byte
-	n, err := c.rawConn.Read(buf[:])
+// offerSession adds the session to the ClientHello, if it can be used with the offered cipher suites. After a
+// HelloRetryRequest it's only offered again if the hash of the selected cipher suite matches the session. The client
+// always announces that it can resume with psk_dhe_ke, so that the server issues tickets.
+func (c *clientHandshake) offerSession(cfg *tlstypes.ClientHelloExtParams) {
+	if c.config.sessionTicketsDisabled() {
+		return
+	}
+	cfg.PskModes = []extensions.PskKeyExchangeMode{extensions.PskDheKeMode}
+	if c.session == nil || !containsCipherSuite(c.config.cipherSuites(), c.session.cipherSuite) {
+		return
+	}
+	pskSuite, err := suite.CipherSuiteByID(c.session.cipherSuite)
+	if err != nil || (c.cipherSuite != nil && c.cipherSuite.Hash != pskSuite.Hash) {
+		return
+	}
+
+	// RFC 8446, Section 4.2.11.1: the ticket age in milliseconds is obfuscated with the ticket_age_add of the ticket.
+	age := c.config.now().Sub(c.session.receivedAt)
+	cfg.PskIdentities = []extensions.PskIdentity{{
+		IdentityLen:         uint16(len(c.session.ticket)),
+		Identity:            c.session.ticket,
+		ObfuscatedTicketAge: uint32(age/time.Millisecond) + c.session.ageAdd,
+	}}
+	cfg.PskBinderLen = pskSuite.Hash.Size()
+	// RFC 8446, Section 4.2.10: early data is never sent in the ClientHello which answers a HelloRetryRequest.
+	cfg.EarlyData = c.clientHello == nil && len(c.earlyData) > 0 &&
+		uint64(len(c.earlyData)) <= uint64(c.session.maxEarlyData)
+}
+
+// writeEarlyData sends the early data right after the first ClientHello, protected with the client early traffic keys
+// of the offered session, as defined in RFC 8446, Section 7.1.
+func (c *clientHandshake) writeEarlyData() error {
+	pskSuite, err := suite.CipherSuiteByID(c.session.cipherSuite)
 	if err != nil {
 		return err
 	}
+	transcript := pskSuite.Hash.New()
+	if _, err := transcript.Write(c.clientHello.ToBinary()); err != nil {
+		return err
+	}
+	earlySecret := pskSuite.Extract(c.session.secret, nil)
+	clientEarlyTrafficSecret := pskSuite.DeriveSecret(earlySecret, suite.ClientEarlyTrafficLabel, transcript)
+	c.records.setWriteKey(pskSuite, clientEarlyTrafficSecret, c.config.paddingBlockSize())
+	if err := c.records.writeRecord(tlstypes.ApplicationRecord, c.earlyData); err != nil {
+		return err
+	}
+
+	// save state:
+	c.earlyDataOffered = true
 
-	record, err := tlstypes.ParseRecord(buf[:n])
+	return nil
+}
+
+// setPskBinder replaces the placeholder binder at the end of the ClientHello. The binder covers the transcript up to
+// the ClientHello without the binders list, as defined in RFC 8446, Section 4.2.11.2.
+func (c *clientHandshake) setPskBinder(clientHelloMsg *tlstypes.ClientHelloMsg, binderLen int) error {
+	pskSuite, err := suite.CipherSuiteByID(c.session.cipherSuite)
 	if err != nil {
 		return err
 	}
+	transcript := pskSuite.Hash.New()
+	if c.transcript != nil {
+		// After a HelloRetryRequest the transcript already holds the first ClientHello and the HelloRetryRequest:
+		transcript = c.cipherSuite.CloneHash(c.transcript)
+	}
+	raw := clientHelloMsg.ToBinary()
+	bindersLen := typesizes.Uint16Bytes + typesizes.Uint8Bytes + binderLen
+	if _, err := transcript.Write(raw[:len(raw)-bindersLen]); err != nil {
+		return err
+	}
+	binder := pskSuite.PSKBinder(c.session.secret, transcript)
+	common.AssertImpl(len(binder) == binderLen)
+	copy(clientHelloMsg.ExtensionData[len(clientHelloMsg.ExtensionData)-binderLen:], binder)
+	return nil
+}
 
-	var serverHelloMsg *tlstypes.ServerHelloMsg
-
-	switch record.RecordType {
-	case tlstypes.AlertRecord:
-		alert, err := tlstypes.ParseAlert(record.Data)
-		if err == nil {
-			return fmt.Errorf("received alert message %+v", alert)
+func (c *clientHandshake) readServerHelloMsg() error {
+	data, serverHelloMsg, err := c.readServerHello()
+	if err != nil {
+		return err
+	}
+	if serverHelloMsg.IsHelloRetryRequest() {
+		if err := c.handleHelloRetryRequest(data, serverHelloMsg); err != nil {
+			return err
 		}
-		return errors.New("failed to parse alert record")
-	case tlstypes.HandshakeRecord:
-		serverHelloMsg, err = tlstypes.ParseServerHelloMsg(record.Data)
+		data, serverHelloMsg, err = c.readServerHello()
 		if err != nil {
 			return err
 		}
-	default:
-		err = fmt.Errorf("received unsupported record type %d", record.RecordType)
+		if serverHelloMsg.IsHelloRetryRequest() {
+			return &alertError{tlstypes.UnexpectedMessage, errors.New("received a second hello retry request")}
+		}
 	}
 
-	exts, err := extensions.ParseExtensions(serverHelloMsg.ExtensionData, serverHelloMsg.ExtensionsLen)
+	exts, err := extensions.ParseExtensions(serverHelloMsg.ExtensionData, serverHelloMsg.ExtensionsLen, extensions.ServerHelloMsgContext)
 	if err != nil {
+		return extensionsAlertError(err)
+	}
+	if err := checkUnsolicitedExtensions(exts, c.config.extensions()); err != nil {
+		return err
+	}
+	if err := checkServerHelloVersion(exts); err != nil {
 		return err
 	}
 	ext := extensions.FindExtension(exts, extensions.KeyShareType)
 	kse, ok := ext.(*extensions.KeyShareExtension)
-	common.AssertImpl(ok)
+	if !ok {
+		return &alertError{tlstypes.MissingExtension, errors.New("server hello has no key share")}
+	}
+	serverShare := kse.Entries[0]
+	if serverShare.CurveID != c.group.CurveID() {
+		// RFC 8446, Section 4.2.8: the server must answer in the group of the offered key share.
+		err = fmt.Errorf("server key share group %d was not offered", serverShare.CurveID)
+		return &alertError{tlstypes.IllegalParameter, err}
+	}
+
+	usingPSK, err := c.checkSelectedPsk(exts)
+	if err != nil {
+		return err
+	}
+
+	if _, err = c.transcript.Write(data); err != nil {
+		return err
+	}
 
 	// save state:
 	c.serverHello = serverHelloMsg
-	c.serverPubKeyBytes = kse.PublicKey
-	c.serverPubicKey, err = ecdh.UnmarshalPubKey(ecdh.DefaultCurve, kse.PublicKey)
+	c.serverPubKeyBytes = serverShare.PublicKey
+	c.usingPSK = usingPSK
+
+	return nil
+}
+
+// checkSelectedPsk reports whether the server accepted the offered session. It must select the only offered identity
+// with a cipher suite of the same hash, as required by RFC 8446, Section 4.2.11.
+func (c *clientHandshake) checkSelectedPsk(exts []extensions.Extension) (bool, error) {
+	pske, ok := extensions.FindExtension(exts, extensions.PreSharedKeyType).(*extensions.PreSharedKeyExtension)
+	if !ok {
+		return false, nil
+	}
+	if !c.pskOffered || pske.SelectedIdentity != 0 {
+		return false, &alertError{tlstypes.IllegalParameter, errors.New("server selected a psk which was not offered")}
+	}
+	pskSuite, err := suite.CipherSuiteByID(c.session.cipherSuite)
+	if err != nil || pskSuite.Hash != c.cipherSuite.Hash {
+		return false, &alertError{tlstypes.IllegalParameter, errors.New("server selected a cipher suite which does not match the psk")}
+	}
+	return true, nil
+}
+
+// readServerHello reads a ServerHello, which may also be a HelloRetryRequest.
+func (c *clientHandshake) readServerHello() ([]byte, *tlstypes.ServerHelloMsg, error) {
+	data, err := c.records.readHandshakeMsg()
+	if err != nil {
+		return nil, nil, err
+	}
+	if tlstypes.HandshakeMsgType(data[0]) != tlstypes.ServerHelloMsgType {
+		err = fmt.Errorf("received handshake message %d instead of server hello", data[0])
+		return nil, nil, &alertError{tlstypes.UnexpectedMessage, err}
+	}
+	serverHelloMsg, err := tlstypes.ParseServerHelloMsg(data)
 	if err != nil {
+		return nil, nil, &alertError{tlstypes.DecodeError, err}
+	}
+	if !bytes.Equal(serverHelloMsg.SessionID, c.clientHello.SessionID) {
+		// RFC 8446, Section 4.1.3: the server must echo the legacy session id of the client.
+		return nil, nil, &alertError{tlstypes.IllegalParameter, errors.New("server hello does not echo the session id")}
+	}
+	if err := c.setCipherSuite(serverHelloMsg.CipherSuite); err != nil {
+		return nil, nil, err
+	}
+	return data, serverHelloMsg, nil
+}
+
+// checkServerHelloVersion checks that a ServerHello or HelloRetryRequest selects TLS 1.3 in supported_versions, as
+// required by RFC 8446, Section 4.2.1.
+func checkServerHelloVersion(exts []extensions.Extension) error {
+	sve, ok := extensions.FindExtension(exts, extensions.SupporteVersionsType).(*extensions.SupportedVersions)
+	if !ok {
+		return &alertError{tlstypes.ProtocolVersion, errors.New("server hello has no supported versions")}
+	}
+	if sve.TLSVersion != tls.VersionTLS13 {
+		err := fmt.Errorf("server selected version %#04x", sve.TLSVersion)
+		return &alertError{tlstypes.IllegalParameter, err}
+	}
+	return nil
+}
+
+// setCipherSuite checks the cipher suite selected by the server and starts the transcript with the first ClientHello,
+// since the transcript hash is only known now.
+func (c *clientHandshake) setCipherSuite(id tlstypes.CipherSuite) error {
+	if c.cipherSuite != nil {
+		if id != c.cipherSuite.ID {
+			// RFC 8446, Section 4.1.4: the ServerHello must select the cipher suite of the HelloRetryRequest.
+			return &alertError{tlstypes.IllegalParameter, errors.New("server changed the selected cipher suite")}
+		}
+		return nil
+	}
+	if !containsCipherSuite(c.clientHello.CipherSuite, id) {
+		err := fmt.Errorf("server selected cipher suite %#04x which was not offered", uint16(id))
+		return &alertError{tlstypes.IllegalParameter, err}
+	}
+	cipherSuite, err := suite.CipherSuiteByID(id)
+	if err != nil {
+		return &alertError{tlstypes.IllegalParameter, err}
+	}
+	transcript := cipherSuite.Hash.New()
+	if _, err := transcript.Write(c.clientHello.ToBinary()); err != nil {
 		return err
 	}
 
+	// save state:
+	c.cipherSuite = cipherSuite
+	c.transcript = transcript
+
 	return nil
 }
 
-func (c *clientHandshake) genClientKey(cfg *tlstypes.ClientHelloExtParams) error {
-	common.AssertImpl(cfg != nil)
-	priv, pub, err := ecdh.GenerateKey(ecdh.DefaultCurve, crand.Reader)
+// handleHelloRetryRequest answers a HelloRetryRequest with a second ClientHello, which has a key share for the group
+// selected by the server and echoes the cookie, as defined in RFC 8446, Section 4.1.4.
+func (c *clientHandshake) handleHelloRetryRequest(data []byte, helloRetryRequestMsg *tlstypes.ServerHelloMsg) error {
+	exts, err := extensions.ParseExtensions(helloRetryRequestMsg.ExtensionData, helloRetryRequestMsg.ExtensionsLen,
+		extensions.HelloRetryRequestMsgContext)
 	if err != nil {
+		return extensionsAlertError(err)
+	}
+	if err := checkUnsolicitedExtensions(exts, c.config.extensions()); err != nil {
+		return err
+	}
+	if err := checkServerHelloVersion(exts); err != nil {
 		return err
 	}
-	pubBytes := ecdh.MarshalPubKey(pub)
-	cfg.KeyShareExtParams = &tlstypes.KeyShareExtParams{
-		CurveID: ecdh.DefaultCurveID,
-		PubKey:  pubBytes,
+
+	cfg := &tlstypes.ClientHelloExtParams{
+		ServerName:          c.hostName(),
+		SupportedGroups:     c.config.curvePreferences(),
+		SignatureAlgorithms: suite.SupportedSignatureSchemes,
+		ALPNProtocols:       c.config.nextProtos(),
+		Extensions:          c.config.extensions(),
+	}
+	if ce, ok := extensions.FindExtension(exts, extensions.CookieType).(*extensions.CookieExtension); ok {
+		cfg.Cookie = ce.Cookie
+	}
+	kse, ok := extensions.FindExtension(exts, extensions.KeyShareType).(*extensions.KeyShareExtension)
+	if !ok && cfg.Cookie == nil {
+		// RFC 8446, Section 4.1.4: the HelloRetryRequest must change something in the ClientHello.
+		return &alertError{tlstypes.IllegalParameter, errors.New("hello retry request would not change the client hello")}
+	}
+
+	group, priv := c.group, c.clientPrivateKey
+	if ok {
+		if kse.SelectedGroup == c.group.CurveID() || !containsCurve(cfg.SupportedGroups, kse.SelectedGroup) {
+			err = fmt.Errorf("hello retry request selected invalid group %d", kse.SelectedGroup)
+			return &alertError{tlstypes.IllegalParameter, err}
+		}
+		if group, err = ecdh.GroupByID(kse.SelectedGroup); err != nil {
+			return &alertError{tlstypes.IllegalParameter, err}
+		}
+		if priv, err = group.GenerateKey(crand.Reader); err != nil {
+			return err
+		}
+	}
+	cfg.KeyShares = []tlstypes.KeyShareExtParams{{
+		CurveID: group.CurveID(),
+		PubKey:  group.MarshalPubKey(priv),
+	}}
+	c.offerSession(cfg)
+	if c.earlyDataOffered {
+		// The server rejected the early data, the second ClientHello is not protected.
+		c.records.clearWriteKey()
+		c.earlyDataOffered = false
+	}
+
+	suite.ReplaceWithMessageHash(c.transcript)
+	if _, err := c.transcript.Write(data); err != nil {
+		return err
+	}
+	if err := c.writeClientHelloMsg(cfg); err != nil {
+		return err
 	}
 
 	// save state:
+	c.group = group
 	c.clientPrivateKey = priv
 
 	return nil
 }
 
-func (c *clientHandshake) calculateHandshakeHash() (h hash.Hash, err error) {
-	h = sha256.New()
-	_, err = h.Write(c.clientHello.ToBinary())
+func (c *clientHandshake) readEncryptedExtensionsMsg() error {
+	data, err := c.records.readHandshakeMsg()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	_, err = h.Write(c.serverHello.ToBinary())
+	encryptedExtensionsMsg, err := tlstypes.ParseEncryptedExtensionsMsg(data)
 	if err != nil {
-		return nil, err
+		return &alertError{tlstypes.DecodeError, err}
+	}
+	exts, err := extensions.ParseExtensions(encryptedExtensionsMsg.ExtensionData, encryptedExtensionsMsg.ExtensionsLen,
+		extensions.EncryptedExtensionsMsgContext)
+	if err != nil {
+		return extensionsAlertError(err)
+	}
+	if err := checkUnsolicitedExtensions(exts, c.config.extensions()); err != nil {
+		return err
+	}
+	peerExtensions := registeredExtensions(exts)
+	_, nameAcknowledged := extensions.FindExtension(exts, extensions.ServerNameType).(*extensions.ServerNameExtension)
+	if nameAcknowledged && c.hostName() == "" {
+		err = errors.New("server acknowledged a server name which was not sent")
+		return &alertError{tlstypes.UnsupportedExtension, err}
+	}
+	alpnProtocol, err := c.checkALPNProtocol(exts)
+	if err != nil {
+		return err
+	}
+	_, earlyDataAccepted := extensions.FindExtension(exts, extensions.EarlyDataType).(*extensions.EarlyDataExtension)
+	if earlyDataAccepted && (!c.earlyDataOffered || !c.usingPSK) {
+		return &alertError{tlstypes.IllegalParameter, errors.New("server accepted early data which was not offered")}
 	}
-	return h, nil
+	if earlyDataAccepted && alpnProtocol != c.session.alpnProtocol {
+		// RFC 8446, Section 4.2.10: early data is sent for the application protocol of the session.
+		err = errors.New("server accepted early data for another application protocol")
+		return &alertError{tlstypes.IllegalParameter, err}
+	}
+
+	// save state:
+	c.earlyDataAccepted = earlyDataAccepted
+	c.alpnProtocol = alpnProtocol
+	c.peerExtensions = peerExtensions
+
+	_, err = c.transcript.Write(data)
+	return err
+}
+
+// checkUnsolicitedExtensions fails with unsupported_extension if the peer answered with an unknown or custom extension
+// of a type which isn't in sent, as required by RFC 8446, Section 4.2.
+func checkUnsolicitedExtensions(exts []extensions.Extension, sent []extensions.Extension) error {
+	for _, ext := range exts {
+		_, unknown := ext.(*extensions.RawExtension)
+		if (unknown || extensions.IsRegistered(ext.GetType())) && extensions.FindExtension(sent, ext.GetType()) == nil {
+			err := fmt.Errorf("peer sent unsolicited extension of type %#04x", uint16(ext.GetType()))
+			return &alertError{tlstypes.UnsupportedExtension, err}
+		}
+	}
+	return nil
+}
+
+// checkCertificateExtensions parses the extensions of every certificate entry, which may only answer the extensions in
+// sent.
+func checkCertificateExtensions(certificateMsg *tlstypes.CertificateMsg, sent []extensions.Extension) error {
+	for _, entry := range certificateMsg.CertificateList {
+		exts, err := extensions.ParseExtensions(entry.ExtensionData, entry.ExtensionsLen, extensions.CertificateMsgContext)
+		if err != nil {
+			return extensionsAlertError(err)
+		}
+		if err := checkUnsolicitedExtensions(exts, sent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hostName returns the server name sent with the server_name extension. RFC 6066, Section 3 doesn't allow IP addresses,
+// so it's empty for them.
+func (c *clientHandshake) hostName() string {
+	if net.ParseIP(c.serverName) != nil {
+		return ""
+	}
+	return strings.TrimSuffix(c.serverName, ".")
+}
+
+// checkALPNProtocol returns the application protocol selected by the server, which must be one of the offered ones.
+func (c *clientHandshake) checkALPNProtocol(exts []extensions.Extension) (string, error) {
+	alpne, ok := extensions.FindExtension(exts, extensions.ALPNType).(*extensions.ALPNExtension)
+	if !ok {
+		return "", nil
+	}
+	if len(c.config.nextProtos()) == 0 {
+		return "", &alertError{tlstypes.UnsupportedExtension, errors.New("server selected an application protocol")}
+	}
+	if !containsString(c.config.nextProtos(), alpne.Protocols[0]) {
+		err := fmt.Errorf("server selected application protocol %q which was not offered", alpne.Protocols[0])
+		return "", &alertError{tlstypes.IllegalParameter, err}
+	}
+	return alpne.Protocols[0], nil
+}
+
+func (c *clientHandshake) readCertificateMsg() error {
+	data, err := c.records.readHandshakeMsg()
+	if err != nil {
+		return err
+	}
+	if tlstypes.HandshakeMsgType(data[0]) == tlstypes.CertificateRequestMsgType {
+		// The server asks for a client certificate before sending its own:
+		if err := c.readCertificateRequestMsg(data); err != nil {
+			return err
+		}
+		if data, err = c.records.readHandshakeMsg(); err != nil {
+			return err
+		}
+	}
+	certificateMsg, err := tlstypes.ParseCertificateMsg(data)
+	if err != nil {
+		return &alertError{tlstypes.DecodeError, err}
+	}
+	if len(certificateMsg.CertificateList) == 0 {
+		return errors.New("server sent an empty certificate list")
+	}
+	if err := checkCertificateExtensions(certificateMsg, c.config.extensions()); err != nil {
+		return err
+	}
+
+	certificates := make([]*x509.Certificate, 0, len(certificateMsg.CertificateList))
+	for i := 0; i < len(certificateMsg.CertificateList); i++ {
+		cert, err := x509.ParseCertificate(certificateMsg.CertificateList[i].CertData)
+		if err != nil {
+			return err
+		}
+		certificates = append(certificates, cert)
+	}
+	if err := c.verifyServerCertificate(certificates); err != nil {
+		return err
+	}
+
+	// save state:
+	c.peerCertificates = certificates
+	c.verified = !c.config.insecureSkipVerify()
+
+	_, err = c.transcript.Write(data)
+	return err
+}
+
+// readCertificateRequestMsg handles a request for a client certificate. The first configured certificate is sent if its
+// key can sign with one of the signature schemes the server accepts, otherwise the client sends no certificate.
+func (c *clientHandshake) readCertificateRequestMsg(data []byte) error {
+	certificateRequestMsg, err := tlstypes.ParseCertificateRequestMsg(data)
+	if err != nil {
+		return &alertError{tlstypes.DecodeError, err}
+	}
+	exts, err := extensions.ParseExtensions(certificateRequestMsg.ExtensionData, certificateRequestMsg.ExtensionsLen,
+		extensions.CertificateRequestMsgContext)
+	if err != nil {
+		return extensionsAlertError(err)
+	}
+	sae, ok := extensions.FindExtension(exts, extensions.SignatureAlgorithmsType).(*extensions.SignatureAlgorithmsExtension)
+	if !ok {
+		err = errors.New("certificate request has no signature algorithms")
+		return &alertError{tlstypes.MissingExtension, err}
+	}
+
+	var (
+		certificate     *tls.Certificate
+		signatureScheme tls.SignatureScheme
+	)
+	if cert := c.config.certificate(); cert != nil {
+		if signer, ok := cert.PrivateKey.(crypto.Signer); ok {
+			scheme, err := suite.SelectSignatureScheme(signer.Public(), sae.Schemes)
+			if err == nil {
+				certificate, signatureScheme = cert, scheme
+			}
+		}
+	}
+	if _, err := c.transcript.Write(data); err != nil {
+		return err
+	}
+
+	// save state:
+	c.certificateRequest = certificateRequestMsg
+	c.certificate = certificate
+	c.signatureScheme = signatureScheme
+
+	return nil
+}
+
+// verifyServerCertificate verifies the certificate chain of the server against the root CAs and checks that the leaf
+// certificate is valid for the server name at the current time.
+func (c *clientHandshake) verifyServerCertificate(certificates []*x509.Certificate) error {
+	if c.config.insecureSkipVerify() {
+		return nil
+	}
+	opts := x509.VerifyOptions{
+		Roots:         c.config.rootCAs(),
+		Intermediates: x509.NewCertPool(),
+		DNSName:       c.serverName,
+		CurrentTime:   c.config.now(),
+	}
+	for _, cert := range certificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := certificates[0].Verify(opts); err != nil {
+		return &alertError{certificateAlert(err), err}
+	}
+	return nil
+}
+
+// certificateAlert picks the alert which reports a certificate verification error, as defined in RFC 8446,
+// Section 6.2.
+func certificateAlert(err error) tlstypes.AlertDescription {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		invalid          x509.CertificateInvalidError
+	)
+	switch {
+	case errors.As(err, &unknownAuthority):
+		return tlstypes.UnknownCa
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		return tlstypes.CertificateExpired
+	default:
+		return tlstypes.BadCertificate
+	}
+}
+
+// checkPeerSignatureScheme checks that the peer signed its CertificateVerify with a scheme which was offered to it and
+// which matches the key of its certificate, as required by RFC 8446, Section 4.4.3.
+func checkPeerSignatureScheme(scheme tls.SignatureScheme, pub crypto.PublicKey) error {
+	if !suite.IsSupportedSignatureScheme(scheme) {
+		err := fmt.Errorf("unsupported signature scheme %#04x", uint16(scheme))
+		return &alertError{tlstypes.IllegalParameter, err}
+	}
+	if _, err := suite.SelectSignatureScheme(pub, []tls.SignatureScheme{scheme}); err != nil {
+		err = fmt.Errorf("signature scheme %#04x does not match the certificate key", uint16(scheme))
+		return &alertError{tlstypes.IllegalParameter, err}
+	}
+	return nil
+}
+
+func (c *clientHandshake) readCertificateVerifyMsg() error {
+	data, err := c.records.readHandshakeMsg()
+	if err != nil {
+		return err
+	}
+	certificateVerifyMsg, err := tlstypes.ParseCertificateVerifyMsg(data)
+	if err != nil {
+		return &alertError{tlstypes.DecodeError, err}
+	}
+	scheme, pub := certificateVerifyMsg.SignatureScheme, c.peerCertificates[0].PublicKey
+	if err := checkPeerSignatureScheme(scheme, pub); err != nil {
+		return err
+	}
+
+	signed := suite.SignedMessage(suite.ServerSignatureContext, c.transcript)
+	err = suite.Verify(scheme, pub, signed, certificateVerifyMsg.Signature)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.transcript.Write(data)
+	return err
+}
+
+func (c *clientHandshake) readServerFinishedMsg() error {
+	data, err := c.records.readHandshakeMsg()
+	if err != nil {
+		return err
+	}
+	finishedMsg, err := tlstypes.ParseFinishedMsg(data)
+	if err != nil {
+		return &alertError{tlstypes.DecodeError, err}
+	}
+
+	expected := c.cipherSuite.FinishedVerifyData(c.serverHandshakeTrafficSecret, c.transcript)
+	if !hmac.Equal(expected, finishedMsg.VerifyData) {
+		return errors.New("invalid server finished verify data")
+	}
+
+	_, err = c.transcript.Write(data)
+	return err
+}
+
+// writeCertificateMsg sends the client certificate chain, which is empty if the client has no suitable certificate.
+func (c *clientHandshake) writeCertificateMsg() error {
+	var chain [][]byte
+	if c.certificate != nil {
+		chain = c.certificate.Certificate
+	}
+	certificateMsg := tlstypes.MakeCertificateMessage(chain)
+	certificateMsg.RequestContextLen = c.certificateRequest.RequestContextLen
+	certificateMsg.RequestContext = c.certificateRequest.RequestContext
+	return c.writeHandshakeMsg(certificateMsg.ToBinary())
+}
+
+// writeCertificateVerifyMsg proves the possession of the private key of the client certificate. Nothing is sent
+// without a certificate.
+func (c *clientHandshake) writeCertificateVerifyMsg() error {
+	if c.certificate == nil {
+		return nil
+	}
+	signed := suite.SignedMessage(suite.ClientSignatureContext, c.transcript)
+	signature, err := suite.Sign(c.signatureScheme, c.certificate.PrivateKey, signed)
+	if err != nil {
+		return err
+	}
+	certificateVerifyMsg := tlstypes.MakeCertificateVerifyMessage(c.signatureScheme, signature)
+	return c.writeHandshakeMsg(certificateVerifyMsg.ToBinary())
+}
+
+func (c *clientHandshake) writeFinishedMsg() error {
+	verifyData := c.cipherSuite.FinishedVerifyData(c.clientHandshakeTrafficSecret, c.transcript)
+	finishedMsg := tlstypes.MakeFinishedMessage(verifyData)
+	return c.writeHandshakeMsg(finishedMsg.ToBinary())
+}
+
+// writeHandshakeMsg sends a handshake message and adds it to the transcript.
+func (c *clientHandshake) writeHandshakeMsg(raw []byte) error {
+	if err := c.records.writeRecord(tlstypes.HandshakeRecord, raw); err != nil {
+		return err
+	}
+	_, err := c.transcript.Write(raw)
+	return err
+}
+
+// deriveApplicationSecrets computes the application traffic secrets and the exporter master secret from the transcript
+// up to the server Finished, as defined in RFC 8446, Section 7.1.
+func (c *clientHandshake) deriveApplicationSecrets() {
+	derivedSecret := c.cipherSuite.DeriveSecret(c.handshakeSecret, suite.DerivedLabel, nil)
+	masterSecret := c.cipherSuite.Extract(nil, derivedSecret)
+	clientApplicationTrafficSecret := c.cipherSuite.DeriveSecret(masterSecret, suite.ClientApplicationTrafficLabel, c.transcript)
+	serverApplicationTrafficSecret := c.cipherSuite.DeriveSecret(masterSecret, suite.ServerApplicationTrafficLabel, c.transcript)
+	exporterMasterSecret := c.cipherSuite.DeriveSecret(masterSecret, suite.ExporterLabel, c.transcript)
+
+	// save state:
+	c.masterSecret = masterSecret
+	c.clientApplicationTrafficSecret = clientApplicationTrafficSecret
+	c.serverApplicationTrafficSecret = serverApplicationTrafficSecret
+	c.exporterMasterSecret = exporterMasterSecret
+}
+
+func (c *clientHandshake) genClientKey(cfg *tlstypes.ClientHelloExtParams) error {
+	common.AssertImpl(cfg != nil)
+	// Only the most preferred group gets a key share, the others are listed in supported_groups.
+	curves := c.config.curvePreferences()
+	group, err := ecdh.GroupByID(curves[0])
+	if err != nil {
+		return err
+	}
+	priv, err := group.GenerateKey(crand.Reader)
+	if err != nil {
+		return err
+	}
+	cfg.SupportedGroups = curves
+	cfg.SignatureAlgorithms = suite.SupportedSignatureSchemes
+	cfg.KeyShares = []tlstypes.KeyShareExtParams{{
+		CurveID: group.CurveID(),
+		PubKey:  group.MarshalPubKey(priv),
+	}}
+
+	// save state:
+	c.group = group
+	c.clientPrivateKey = priv
+
+	return nil
+}
+
+func (c *clientHandshake) sendFatalAlert(desc tlstypes.AlertDescription) {
+	c.records.sendAlert(desc)
 }
@@ -0,0 +1,291 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tls-handshake/internal/ecdh"
+	"github.com/tls-handshake/internal/suite"
+	tlstypes "github.com/tls-handshake/internal/tls_types"
+	"github.com/tls-handshake/internal/tls_types/extensions"
+)
+
+// Config is used to configure a Server or a Client. A nil Config is valid and uses the defaults.
+type Config struct {
+	// Certificates holds the certificate chains and private keys the server presents to clients. The server presents
+	// the first one which is valid for the server name sent by the client, or the first one if none is. If it's empty
+	// the server generates an ephemeral self-signed certificate when it starts listening. The client presents the
+	// first one when the server asks for a client certificate.
+	Certificates []tls.Certificate
+
+	// GetCertificate returns the certificate the server presents for a ClientHello, e.g. picked by the server name
+	// from a map of virtual hosts. If it returns nil Certificates are used, and if it fails the handshake is aborted
+	// with an unrecognized_name alert.
+	GetCertificate func(hello *ClientHelloInfo) (*tls.Certificate, error)
+
+	// PaddingBlockSize hides the length of the sent records by padding the plaintext of every protected record with
+	// zeros up to a multiple of PaddingBlockSize. Zero disables padding.
+	PaddingBlockSize int
+
+	// CurvePreferences are the key exchange groups in order of preference. The client sends a key share for the first
+	// one. The server ignores key shares for groups which are not listed: it asks for a listed group which the client
+	// supports with a HelloRetryRequest, and fails the handshake if there is none. If it's empty X25519 and P-256 are
+	// used.
+	CurvePreferences []tls.CurveID
+
+	// CipherSuites are the TLS 1.3 cipher suites in order of preference, e.g. tls.TLS_CHACHA20_POLY1305_SHA256. The
+	// client offers them in this order and the server selects its most preferred suite which the client offered. If
+	// it's empty AES-GCM is preferred on CPUs with AES instructions and ChaCha20-Poly1305 otherwise.
+	CipherSuites []uint16
+
+	// RootCAs are the certificate authorities the client uses to verify the certificate chain of the server. If it's
+	// nil the system roots are used.
+	RootCAs *x509.CertPool
+
+	// ServerName is the host name or IP address the server certificate must be valid for. If it's empty the client
+	// uses the address passed to Connect.
+	ServerName string
+
+	// InsecureSkipVerify disables the verification of the server certificate chain and host name, which makes the
+	// connection open to man-in-the-middle attacks. It should only be used for testing.
+	InsecureSkipVerify bool
+
+	// ClientAuth is the policy of the server for client certificates. It uses the modes of crypto/tls: with
+	// tls.NoClientCert, the default, no certificate is requested, with tls.RequestClientCert and
+	// tls.RequireAnyClientCert the certificate is not verified, and with tls.VerifyClientCertIfGiven and
+	// tls.RequireAndVerifyClientCert it's verified against ClientCAs.
+	ClientAuth tls.ClientAuthType
+
+	// ClientCAs are the certificate authorities the server uses to verify client certificates. If it's nil the system
+	// roots are used.
+	ClientCAs *x509.CertPool
+
+	// Time returns the current time, it's used to check the validity period of certificates. If it's nil time.Now is
+	// used.
+	Time func() time.Time
+
+	// SessionTicketsDisabled stops the server from issuing session tickets and the client from resuming sessions.
+	SessionTicketsDisabled bool
+
+	// SessionTicketKey encrypts the session tickets of the server. Servers sharing the key can resume each other's
+	// sessions. If it's zero the server generates a random key when it starts, so its tickets can't be used after a
+	// restart.
+	SessionTicketKey [32]byte
+
+	// ClientSessionCache holds the sessions the client can resume, keyed by the server address. If it's nil every
+	// Client uses a cache of its own.
+	ClientSessionCache ClientSessionCache
+
+	// MaxEarlyData is the maximum number of bytes of 0-RTT early data the server accepts from clients resuming with
+	// its session tickets. Zero disables early data. Early data has no forward secrecy and can be replayed: the server
+	// accepts every ClientHello only once within a short window, but servers sharing a SessionTicketKey don't share
+	// that record. It should only be used for idempotent requests.
+	MaxEarlyData uint32
+
+	// NextProtos are the application protocols, e.g. "h2", in order of preference. The client offers them with the
+	// ALPN extension and the server selects its most preferred protocol which the client offered. When the server
+	// has protocols, but none of them is offered, the handshake fails. If it's empty no protocol is negotiated.
+	NextProtos []string
+
+	// Extensions are custom extensions, e.g. of types added with extensions.RegisterExtension. The client sends them in
+	// its ClientHello and the server sends them in EncryptedExtensions, each only if the client sent one of the same
+	// type. They must not be of types implemented by this package.
+	Extensions []extensions.Extension
+
+	// KeyUpdateRecords is the number of records sent with one traffic key, after which the sender switches to new keys
+	// with a KeyUpdate message. If it's zero the keys are updated every 2^24 records, which keeps AES-GCM within the
+	// limits of RFC 8446, Section 5.5.
+	KeyUpdateRecords uint64
+
+	// KeyUpdateBytes is the number of bytes sent with one traffic key, after which the sender switches to new keys.
+	// Zero disables the limit.
+	KeyUpdateBytes uint64
+}
+
+// ClientHelloInfo describes the ClientHello of a client, it's passed to Config.GetCertificate.
+type ClientHelloInfo struct {
+	// ServerName is the host name sent by the client with the server_name extension, in lower case and without a
+	// trailing dot. It's empty if the client sent none.
+	ServerName string
+
+	// CipherSuites are the cipher suites offered by the client.
+	CipherSuites []uint16
+
+	// SignatureSchemes are the signature schemes the client can verify.
+	SignatureSchemes []tls.SignatureScheme
+
+	// SupportedProtos are the application protocols offered by the client with ALPN.
+	SupportedProtos []string
+
+	// Extensions are all extensions of the ClientHello. Those of unknown types are extensions.RawExtension.
+	Extensions []extensions.Extension
+}
+
+func (c *Config) paddingBlockSize() int {
+	if c == nil || c.PaddingBlockSize < 0 {
+		return 0
+	}
+	return c.PaddingBlockSize
+}
+
+func (c *Config) rootCAs() *x509.CertPool {
+	if c == nil {
+		return nil
+	}
+	return c.RootCAs
+}
+
+func (c *Config) clientAuth() tls.ClientAuthType {
+	if c == nil {
+		return tls.NoClientCert
+	}
+	return c.ClientAuth
+}
+
+func (c *Config) clientCAs() *x509.CertPool {
+	if c == nil {
+		return nil
+	}
+	return c.ClientCAs
+}
+
+// certificate returns the certificate the client presents or nil.
+func (c *Config) certificate() *tls.Certificate {
+	if c == nil || len(c.Certificates) == 0 {
+		return nil
+	}
+	return &c.Certificates[0]
+}
+
+func (c *Config) serverName() string {
+	if c == nil {
+		return ""
+	}
+	return c.ServerName
+}
+
+func (c *Config) insecureSkipVerify() bool {
+	return c != nil && c.InsecureSkipVerify
+}
+
+func (c *Config) sessionTicketsDisabled() bool {
+	return c != nil && c.SessionTicketsDisabled
+}
+
+// maxEarlyData is zero when the server doesn't accept early data.
+func (c *Config) maxEarlyData() uint32 {
+	if c == nil || c.SessionTicketsDisabled {
+		return 0
+	}
+	return c.MaxEarlyData
+}
+
+func (c *Config) nextProtos() []string {
+	if c == nil {
+		return nil
+	}
+	return c.NextProtos
+}
+
+// checkNextProtos checks that the protocols fit in an ALPN extension, as required by RFC 7301, Section 3.1.
+func (c *Config) checkNextProtos() error {
+	total := 0
+	for _, p := range c.nextProtos() {
+		if len(p) == 0 || len(p) > 255 {
+			return fmt.Errorf("invalid application protocol %q", p)
+		}
+		total += 1 + len(p)
+	}
+	if total > 0xffff-2 {
+		return errors.New("too many application protocols")
+	}
+	return nil
+}
+
+func (c *Config) extensions() []extensions.Extension {
+	if c == nil {
+		return nil
+	}
+	return c.Extensions
+}
+
+// registeredExtensions returns the extensions of types added with extensions.RegisterExtension.
+func registeredExtensions(exts []extensions.Extension) []extensions.Extension {
+	var ret []extensions.Extension
+	for _, ext := range exts {
+		if extensions.IsRegistered(ext.GetType()) {
+			ret = append(ret, ext)
+		}
+	}
+	return ret
+}
+
+func (c *Config) keyUpdateRecords() uint64 {
+	const defaultKeyUpdateRecords = 1 << 24
+	if c == nil || c.KeyUpdateRecords == 0 {
+		return defaultKeyUpdateRecords
+	}
+	return c.KeyUpdateRecords
+}
+
+func (c *Config) keyUpdateBytes() uint64 {
+	if c == nil {
+		return 0
+	}
+	return c.KeyUpdateBytes
+}
+
+func (c *Config) now() time.Time {
+	if c == nil || c.Time == nil {
+		return time.Now()
+	}
+	return c.Time()
+}
+
+func (c *Config) curvePreferences() []tls.CurveID {
+	if c == nil || len(c.CurvePreferences) == 0 {
+		return ecdh.DefaultGroups
+	}
+	return c.CurvePreferences
+}
+
+func containsCurve(curves []tls.CurveID, id tls.CurveID) bool {
+	for _, c := range curves {
+		if c == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) cipherSuites() []tlstypes.CipherSuite {
+	if c == nil || len(c.CipherSuites) == 0 {
+		return suite.DefaultCipherSuites
+	}
+	ret := make([]tlstypes.CipherSuite, 0, len(c.CipherSuites))
+	for _, id := range c.CipherSuites {
+		ret = append(ret, tlstypes.CipherSuite(id))
+	}
+	return ret
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsCipherSuite(cipherSuites []tlstypes.CipherSuite, id tlstypes.CipherSuite) bool {
+	for _, cs := range cipherSuites {
+		if cs == id {
+			return true
+		}
+	}
+	return false
+}
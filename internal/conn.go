@@ -0,0 +1,356 @@
+package internal
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tls-handshake/internal/suite"
+	tlstypes "github.com/tls-handshake/internal/tls_types"
+	"github.com/tls-handshake/internal/tls_types/extensions"
+	limitconn "github.com/tls-handshake/pkg/limit_conn"
+)
+
+var ClosedConnErr = errors.New("use of closed connection")
+
+// ChannelBindingLabel is the exporter label of the tls-exporter channel binding, as defined in RFC 9266.
+const ChannelBindingLabel = "EXPORTER-Channel-Binding"
+
+const (
+	channelBindingLen = 32
+	// closeNotifyTimeout bounds the write of the close_notify alert in Close, the peer may not read it.
+	closeNotifyTimeout = 5 * time.Second
+)
+
+// Conn is the secure channel established by a successful handshake. Reads and writes go through protected application
+// data records. It implements net.Conn and is safe for concurrent use by one reader and one writer.
+type Conn struct {
+	rawConn          *limitconn.Wrapper
+	records          *recordLayer
+	peerCertificates []*x509.Certificate
+	didResume        bool
+	// earlyDataAccepted is set when the server accepted the 0-RTT early data of the client. On the server the early
+	// data is the first input of the connection.
+	earlyDataAccepted bool
+
+	cipherSuite          *suite.CipherSuite
+	exporterMasterSecret []byte
+	negotiatedProtocol   string
+	serverName           string
+	peerExtensions       []extensions.Extension
+
+	readMux sync.Mutex      // guards input, readErr, tickets and the read half of records
+	input   []byte          // received application data which is not read yet
+	readErr error           // sticky error, once reading fails every following read fails
+	tickets *ticketReceiver // stores the session tickets received by a client, nil if they are ignored
+	server  bool
+
+	// writeLock guards the write half of records, closeNotifySent and sessionTicket. It's a channel with room for one
+	// value, so that Close can give up on it when a Write is blocked on a peer which doesn't read.
+	writeLock        chan struct{}
+	closeNotifySent  bool
+	keyUpdateRecords uint64 // records sent with one key before it's updated
+	keyUpdateBytes   uint64 // bytes sent with one key before it's updated, zero if there is no limit
+	// keyUpdateRequested is set by the reader when the peer asks for a KeyUpdate. It's accessed atomically, since the
+	// reader must not wait for the writer.
+	keyUpdateRequested int32
+	// sessionTicket is a NewSessionTicket message of the server. It's sent with the first write instead of right
+	// after the handshake, so that a client which writes first doesn't block on an unbuffered connection.
+	sessionTicket []byte
+}
+
+var _ net.Conn = (*Conn)(nil) // interface compliance check
+
+func newConn(rawConn *limitconn.Wrapper, records *recordLayer, peerCertificates []*x509.Certificate,
+	config *Config) *Conn {
+
+	ret := &Conn{
+		rawConn:          rawConn,
+		records:          records,
+		writeLock:        make(chan struct{}, 1),
+		peerCertificates: peerCertificates,
+		keyUpdateRecords: config.keyUpdateRecords(),
+		keyUpdateBytes:   config.keyUpdateBytes(),
+	}
+	records.handshakeComplete = true
+	return ret
+}
+
+// PeerCertificates returns the certificate chain sent by the peer, the leaf certificate is first. On the server it's
+// empty when the client sent no certificate. Whether the chain was verified depends on Config.ClientAuth on the
+// server and Config.InsecureSkipVerify on the client.
+func (c *Conn) PeerCertificates() []*x509.Certificate {
+	return c.peerCertificates
+}
+
+// DidResume reports whether the connection resumed a session of an earlier connection with a session ticket.
+func (c *Conn) DidResume() bool {
+	return c.didResume
+}
+
+// EarlyDataAccepted reports whether the server accepted the early data the client sent with the ClientHello. Rejected
+// early data is sent again by the client after the handshake, so it's still delivered.
+func (c *Conn) EarlyDataAccepted() bool {
+	return c.earlyDataAccepted
+}
+
+// ServerName returns the host name the client sent with the server_name extension, it's empty if none was sent. A
+// server can use it to tell apart the virtual hosts it serves.
+func (c *Conn) ServerName() string {
+	return c.serverName
+}
+
+// Extensions returns the extensions of types added with extensions.RegisterExtension which the peer sent: the server
+// gets those of the ClientHello and the client those of EncryptedExtensions.
+func (c *Conn) Extensions() []extensions.Extension {
+	return c.peerExtensions
+}
+
+// NegotiatedProtocol returns the application protocol selected with ALPN, it's empty if none was negotiated.
+func (c *Conn) NegotiatedProtocol() string {
+	return c.negotiatedProtocol
+}
+
+// ExportKeyingMaterial returns length bytes of keying material for label and context, which both sides of the
+// connection derive alike, as defined in RFC 8446, Section 7.5. It has the semantics of
+// tls.ConnectionState.ExportKeyingMaterial for TLS 1.3, a nil context is the same as an empty one.
+func (c *Conn) ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error) {
+	return c.cipherSuite.ExportKeyingMaterial(c.exporterMasterSecret, label, context, length)
+}
+
+// ChannelBinding returns the tls-exporter channel binding of the connection, as defined in RFC 9266. The application
+// can bind its authentication tokens to the connection with it.
+func (c *Conn) ChannelBinding() ([]byte, error) {
+	return c.ExportKeyingMaterial(ChannelBindingLabel, nil, channelBindingLen)
+}
+
+// Read reads application data. It returns io.EOF after the peer sent a close_notify alert.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readMux.Lock()
+	defer c.readMux.Unlock()
+
+	for len(c.input) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		data, err := c.records.readApplicationData()
+		if err == nil {
+			err = c.handlePostHandshakeMsgs()
+		}
+		if err != nil {
+			c.readErr = err
+			if err != io.EOF && !c.rawConn.IsConnClosed() {
+				c.sendAlert(alertFor(err, tlstypes.UnexpectedMessage))
+			}
+			return 0, err
+		}
+		c.input = data
+	}
+
+	n := copy(b, c.input)
+	c.input = c.input[n:]
+	return n, nil
+}
+
+// Write sends b in as many application data records as needed. The keys are updated when they were used for
+// Config.KeyUpdateRecords records or Config.KeyUpdateBytes bytes.
+func (c *Conn) Write(b []byte) (int, error) {
+	c.lockWrite()
+	defer c.unlockWrite()
+
+	if c.closeNotifySent {
+		return 0, ClosedConnErr
+	}
+	if err := c.flushSessionTicketLocked(); err != nil {
+		return 0, err
+	}
+	if atomic.CompareAndSwapInt32(&c.keyUpdateRequested, 1, 0) {
+		// RFC 8446, Section 4.6.3: the requested KeyUpdate is sent before the next application data.
+		if err := c.sendKeyUpdateLocked(tlstypes.UpdateNotRequested); err != nil {
+			return 0, err
+		}
+	}
+
+	n := 0
+	for {
+		fragment := b[n:]
+		if len(fragment) > maxPlaintextFragment {
+			fragment = fragment[:maxPlaintextFragment]
+		}
+		if c.records.writeKeyExhausted(c.keyUpdateRecords, c.keyUpdateBytes) {
+			if err := c.sendKeyUpdateLocked(tlstypes.UpdateNotRequested); err != nil {
+				return n, err
+			}
+		}
+		if err := c.records.writeRecord(tlstypes.ApplicationRecord, fragment); err != nil {
+			return n, err
+		}
+		n += len(fragment)
+		if n == len(b) {
+			return n, nil
+		}
+	}
+}
+
+// UpdateKeys switches the sent records to new traffic keys and asks the peer to do the same for the records it sends,
+// as defined in RFC 8446, Section 4.6.3. The peer updates its keys before it sends more application data.
+func (c *Conn) UpdateKeys() error {
+	c.lockWrite()
+	defer c.unlockWrite()
+
+	if c.closeNotifySent {
+		return ClosedConnErr
+	}
+	if err := c.flushSessionTicketLocked(); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&c.keyUpdateRequested, 0) // the peer's request is answered by this KeyUpdate
+	return c.sendKeyUpdateLocked(tlstypes.UpdateRequested)
+}
+
+// sendKeyUpdateLocked sends a KeyUpdate message with the current keys and switches to the next ones.
+func (c *Conn) sendKeyUpdateLocked(requestUpdate tlstypes.KeyUpdateRequest) error {
+	keyUpdateMsg := tlstypes.MakeKeyUpdateMessage(requestUpdate)
+	if err := c.records.writeRecord(tlstypes.HandshakeRecord, keyUpdateMsg.ToBinary()); err != nil {
+		return err
+	}
+	c.records.updateWriteKey()
+	return nil
+}
+
+// Close sends a close_notify alert to the peer and closes the underlying connection. The alert is skipped when a Write
+// is blocked, closing the connection unblocks it.
+func (c *Conn) Close() error {
+	if c.rawConn.IsConnClosed() {
+		return ClosedConnErr
+	}
+	if c.tryLockWrite() {
+		_ = c.rawConn.SetWriteDeadline(time.Now().Add(closeNotifyTimeout))
+		c.closeNotifyLocked()
+		c.unlockWrite()
+	}
+	return c.rawConn.Close()
+}
+
+// closeNotify sends a close_notify alert once, without closing the connection. Writing fails afterwards, but the
+// peer's remaining data can still be read.
+func (c *Conn) closeNotify() {
+	c.lockWrite()
+	defer c.unlockWrite()
+	c.closeNotifyLocked()
+}
+
+func (c *Conn) closeNotifyLocked() {
+	if c.closeNotifySent {
+		return
+	}
+	c.closeNotifySent = true
+	_ = c.flushSessionTicketLocked()
+	a := &tlstypes.Alert{
+		Level:       tlstypes.WarningAlertLevel,
+		Description: tlstypes.CloseNotify,
+	}
+	_ = c.records.writeRecord(tlstypes.AlertRecord, a.ToBinary())
+}
+
+// handlePostHandshakeMsgs processes the complete handshake messages received after the handshake, as defined in
+// RFC 8446, Section 4.6.
+func (c *Conn) handlePostHandshakeMsgs() error {
+	for {
+		msg, err := c.records.hsBuf.Next()
+		if err != nil {
+			return &alertError{tlstypes.DecodeError, err}
+		}
+		if msg == nil {
+			return nil
+		}
+
+		switch msgType := tlstypes.HandshakeMsgType(msg[0]); {
+		case msgType == tlstypes.NewSessionTicketMsgType && !c.server:
+			if c.tickets == nil {
+				continue // the client doesn't resume sessions
+			}
+			if err := c.tickets.handleNewSessionTicket(msg); err != nil {
+				return err
+			}
+		case msgType == tlstypes.KeyUpdateMsgType:
+			if err := c.handleKeyUpdate(msg); err != nil {
+				return err
+			}
+		default:
+			err = fmt.Errorf("received unexpected handshake message %d after the handshake", msgType)
+			return &alertError{tlstypes.UnexpectedMessage, err}
+		}
+	}
+}
+
+// handleKeyUpdate switches the received records to the next keys of the peer. If the peer asks for it, the next write
+// starts with a KeyUpdate of this side.
+func (c *Conn) handleKeyUpdate(data []byte) error {
+	keyUpdateMsg, err := tlstypes.ParseKeyUpdateMsg(data)
+	if err != nil {
+		return &alertError{tlstypes.DecodeError, err}
+	}
+	requestUpdate := keyUpdateMsg.RequestUpdate
+	if requestUpdate != tlstypes.UpdateNotRequested && requestUpdate != tlstypes.UpdateRequested {
+		err = fmt.Errorf("received key update with invalid request %d", requestUpdate)
+		return &alertError{tlstypes.IllegalParameter, err}
+	}
+	if err := c.records.updateReadKey(); err != nil {
+		return err
+	}
+	if requestUpdate == tlstypes.UpdateRequested {
+		atomic.StoreInt32(&c.keyUpdateRequested, 1)
+	}
+	return nil
+}
+
+func (c *Conn) flushSessionTicketLocked() error {
+	if c.sessionTicket == nil {
+		return nil
+	}
+	ticket := c.sessionTicket
+	c.sessionTicket = nil
+	return c.records.writeRecord(tlstypes.HandshakeRecord, ticket)
+}
+
+func (c *Conn) sendAlert(desc tlstypes.AlertDescription) {
+	c.lockWrite()
+	defer c.unlockWrite()
+	c.records.sendAlert(desc)
+}
+
+func (c *Conn) lockWrite() {
+	c.writeLock <- struct{}{}
+}
+
+// tryLockWrite takes the write lock if it's free and reports whether it did.
+func (c *Conn) tryLockWrite() bool {
+	select {
+	case c.writeLock <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Conn) unlockWrite() {
+	<-c.writeLock
+}
+
+// Interface compliance functions, deadlines are handled by the underlying connection:
+
+func (c *Conn) LocalAddr() net.Addr { return c.rawConn.LocalAddr() }
+
+func (c *Conn) RemoteAddr() net.Addr { return c.rawConn.RemoteAddr() }
+
+func (c *Conn) SetDeadline(t time.Time) error { return c.rawConn.SetDeadline(t) }
+
+func (c *Conn) SetReadDeadline(t time.Time) error { return c.rawConn.SetReadDeadline(t) }
+
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.rawConn.SetWriteDeadline(t) }
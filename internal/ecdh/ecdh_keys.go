@@ -47,10 +47,13 @@ func UnmarshalPubKey(curve elliptic.Curve, out []byte) (*ecdsa.PublicKey, error)
 	return key, nil
 }
 
+// GenerateSharedSecret returns the x coordinate of the shared point, padded with leading zeros to the size of the curve
+// as defined in RFC 8446, Section 7.4.1.
 func GenerateSharedSecret(priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey) ([]byte, error) {
 	common.AssertImpl(priv != nil && pub != nil && priv.Curve == pub.Curve)
 	x, _ := priv.Curve.ScalarMult(pub.X, pub.Y, priv.D.Bytes())
-	return x.Bytes(), nil
+	secret := make([]byte, (priv.Curve.Params().BitSize+7)/8)
+	return x.FillBytes(secret), nil
 }
 
 // EncodePrivateKeyToPKCS encodes a private key to PKCS.
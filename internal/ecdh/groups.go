@@ -0,0 +1,119 @@
+package ecdh
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"io"
+
+	"github.com/tls-handshake/internal/common"
+	"golang.org/x/crypto/curve25519"
+)
+
+var (
+	UnsupportedGroupErr    = errors.New("unsupported key exchange group")
+	InvalidSharedSecretErr = errors.New("invalid shared secret")
+)
+
+// Group is a key exchange group which can be negotiated with the key_share extension. Public keys are in the
+// key_share encoding of the group, as defined in RFC 8446, Section 4.2.8.2.
+type Group interface {
+	CurveID() tls.CurveID
+	GenerateKey(rnd io.Reader) (crypto.PrivateKey, error)
+	MarshalPubKey(priv crypto.PrivateKey) []byte
+	SharedSecret(priv crypto.PrivateKey, peerPubKey []byte) ([]byte, error)
+}
+
+// DefaultGroups are the supported groups in order of preference.
+var DefaultGroups = []tls.CurveID{tls.X25519, tls.CurveP256}
+
+// GroupByID returns the implementation of a supported group.
+func GroupByID(id tls.CurveID) (Group, error) {
+	switch id {
+	case tls.X25519:
+		return x25519Group{}, nil
+	case tls.CurveP256:
+		return nistGroup{id: tls.CurveP256, curve: elliptic.P256()}, nil
+	default:
+		return nil, UnsupportedGroupErr
+	}
+}
+
+type x25519Group struct{}
+
+func (x25519Group) CurveID() tls.CurveID { return tls.X25519 }
+
+func (x25519Group) GenerateKey(rnd io.Reader) (crypto.PrivateKey, error) {
+	priv := make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rnd, priv); err != nil {
+		return nil, err
+	}
+	return priv, nil
+}
+
+func (x25519Group) MarshalPubKey(priv crypto.PrivateKey) []byte {
+	scalar, ok := priv.([]byte)
+	common.AssertImpl(ok)
+	pub, err := curve25519.X25519(scalar, curve25519.Basepoint)
+	common.AssertImpl(err == nil)
+	return pub
+}
+
+func (x25519Group) SharedSecret(priv crypto.PrivateKey, peerPubKey []byte) ([]byte, error) {
+	scalar, ok := priv.([]byte)
+	if !ok {
+		return nil, UnsupportedPrivateKeyErr
+	}
+	if len(peerPubKey) != curve25519.PointSize {
+		return nil, UnmarshalPubKeyFailedErr
+	}
+	// X25519 fails for low order points, which result in an all-zero secret (RFC 8446, Section 7.4.2).
+	secret, err := curve25519.X25519(scalar, peerPubKey)
+	if err != nil {
+		return nil, InvalidSharedSecretErr
+	}
+	return secret, nil
+}
+
+type nistGroup struct {
+	id    tls.CurveID
+	curve elliptic.Curve
+}
+
+func (g nistGroup) CurveID() tls.CurveID { return g.id }
+
+func (g nistGroup) GenerateKey(rnd io.Reader) (crypto.PrivateKey, error) {
+	priv, _, err := GenerateKey(g.curve, rnd)
+	if err != nil {
+		return nil, err
+	}
+	return priv, nil
+}
+
+func (g nistGroup) MarshalPubKey(priv crypto.PrivateKey) []byte {
+	key, ok := priv.(*ecdsa.PrivateKey)
+	common.AssertImpl(ok)
+	return MarshalPubKey(&key.PublicKey)
+}
+
+func (g nistGroup) SharedSecret(priv crypto.PrivateKey, peerPubKey []byte) ([]byte, error) {
+	key, ok := priv.(*ecdsa.PrivateKey)
+	if !ok || key.Curve != g.curve {
+		return nil, UnsupportedPrivateKeyErr
+	}
+	pub, err := UnmarshalPubKey(g.curve, peerPubKey)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := GenerateSharedSecret(key, pub)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(secret, make([]byte, len(secret))) == 1 {
+		return nil, InvalidSharedSecretErr
+	}
+	return secret, nil
+}
@@ -0,0 +1,91 @@
+package ecdh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"testing"
+)
+
+func TestGroupSharedSecret(t *testing.T) {
+	for _, id := range DefaultGroups {
+		group, err := GroupByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if group.CurveID() != id {
+			t.Fatalf("GroupByID returned group %d for %d", group.CurveID(), id)
+		}
+
+		priv1, err := group.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		priv2, err := group.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		secret1, err := group.SharedSecret(priv1, group.MarshalPubKey(priv2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		secret2, err := group.SharedSecret(priv2, group.MarshalPubKey(priv1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(secret1, secret2) {
+			t.Fatalf("group %d: the two shared keys: %x, %x do not match", id, secret1, secret2)
+		}
+		if len(secret1) != 32 {
+			t.Fatalf("group %d: expected a 32 byte shared secret, got %d", id, len(secret1))
+		}
+	}
+}
+
+func TestX25519Vector(t *testing.T) {
+	// RFC 7748, Section 6.1
+	alicePriv, _ := hex.DecodeString("77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a")
+	bobPub, _ := hex.DecodeString("de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f")
+	expected, _ := hex.DecodeString("4a5d9d5ba4ce2de1728e3bf480350f25e07e21c947d19e3376f09b3c1e161742")
+
+	group, err := GroupByID(tls.X25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := group.SharedSecret(alicePriv, bobPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(secret, expected) {
+		t.Fatalf("expected shared secret %x, got %x", expected, secret)
+	}
+}
+
+func TestGroupRejectsInvalidPubKeys(t *testing.T) {
+	x25519, _ := GroupByID(tls.X25519)
+	priv, err := x25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := x25519.SharedSecret(priv, make([]byte, 32)); err == nil {
+		t.Fatal("expected the low order point to be rejected")
+	}
+	if _, err := x25519.SharedSecret(priv, make([]byte, 31)); err == nil {
+		t.Fatal("expected the short public key to be rejected")
+	}
+
+	p256, _ := GroupByID(tls.CurveP256)
+	priv, err = p256.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p256.SharedSecret(priv, make([]byte, 65)); err == nil {
+		t.Fatal("expected the point not on the curve to be rejected")
+	}
+
+	if _, err := GroupByID(tls.CurveP521); err != UnsupportedGroupErr {
+		t.Fatalf("expected UnsupportedGroupErr, got %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"errors"
+
+	tlstypes "github.com/tls-handshake/internal/tls_types"
+	"github.com/tls-handshake/internal/tls_types/extensions"
+)
+
+// alertError is an error which should be reported to the peer with a specific fatal alert.
+type alertError struct {
+	desc tlstypes.AlertDescription
+	err  error
+}
+
+func (e *alertError) Error() string { return e.err.Error() }
+
+func (e *alertError) Unwrap() error { return e.err }
+
+// alertFor returns the alert description carried by err or fallback when err does not carry one.
+func alertFor(err error, fallback tlstypes.AlertDescription) tlstypes.AlertDescription {
+	var ae *alertError
+	if errors.As(err, &ae) {
+		return ae.desc
+	}
+	return fallback
+}
+
+// extensionsAlertError carries the alert for an error of extensions.ParseExtensions. A known extension in a message it's
+// not allowed in is an illegal_parameter, as required by RFC 8446, Section 4.2, other errors are a decode_error.
+func extensionsAlertError(err error) error {
+	if errors.Is(err, extensions.IllegalExtensionErr) {
+		return &alertError{tlstypes.IllegalParameter, err}
+	}
+	return &alertError{tlstypes.DecodeError, err}
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	tlstypes "github.com/tls-handshake/internal/tls_types"
+)
+
+// Handler serves an authenticated connection. The server calls ServeConn in its own goroutine once the handshake
+// succeeds and closes the connection when ServeConn returns. Returning io.EOF is not reported as an error.
+type Handler interface {
+	ServeConn(conn *Conn) error
+}
+
+// HandlerFunc adapts an ordinary function to the Handler interface.
+type HandlerFunc func(conn *Conn) error
+
+func (f HandlerFunc) ServeConn(conn *Conn) error {
+	return f(conn)
+}
+
+// PacketHandlerFunc handles a single decrypted packet and returns the response to send back. A nil response sends
+// nothing.
+type PacketHandlerFunc func(data []byte) ([]byte, error)
+
+// PacketHandler returns a Handler which calls handlePacket for every application data record it receives, until the
+// client closes the connection or handlePacket fails.
+func PacketHandler(handlePacket PacketHandlerFunc) Handler {
+	return HandlerFunc(func(conn *Conn) error {
+		buf := make([]byte, tlstypes.MaxSizeOfPlaintextRecord)
+		for {
+			// A single Read never returns more than one record.
+			n, err := conn.Read(buf)
+			if err != nil {
+				return err
+			}
+			resp, err := handlePacket(buf[:n])
+			if err != nil {
+				return err
+			}
+			if resp == nil {
+				continue
+			}
+			if _, err := conn.Write(resp); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// pingPongHandler is the default handler. It answers every PING with a PONG.
+var pingPongHandler = HandlerFunc(func(conn *Conn) error {
+	ping := make([]byte, len("PING"))
+	for {
+		if _, err := io.ReadFull(conn, ping); err != nil {
+			return err
+		}
+		if !bytes.Equal(ping, []byte("PING")) {
+			return errors.New("unsupported response message")
+		}
+		fmt.Println(string(ping))
+
+		if _, err := conn.Write([]byte("PONG")); err != nil {
+			return err
+		}
+	}
+})
@@ -0,0 +1,324 @@
+package internal
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/tls-handshake/internal/common"
+	"github.com/tls-handshake/internal/suite"
+	tlstypes "github.com/tls-handshake/internal/tls_types"
+	typesizes "github.com/tls-handshake/pkg/type_sizes"
+)
+
+// maxPlaintextFragment is the largest content of a protected record, leaving space for the inner content type.
+const maxPlaintextFragment = tlstypes.MaxSizeOfPlaintextRecord - 1
+
+// halfConn holds the record protection state for one direction of the connection.
+type halfConn struct {
+	cipherSuite      *suite.CipherSuite
+	trafficSecret    []byte
+	aead             cipher.AEAD
+	iv               []byte
+	seq              uint64 // sequence number of the next record, reset on every key change
+	bytes            uint64 // content bytes sealed with the current keys
+	paddingBlockSize int    // when set the inner plaintext is padded to a multiple of it
+}
+
+func newHalfConn(cipherSuite *suite.CipherSuite, trafficSecret []byte) *halfConn {
+	key, iv := cipherSuite.TrafficKey(trafficSecret)
+	aead, err := cipherSuite.AEAD(key)
+	common.AssertImpl(err == nil)
+	return &halfConn{cipherSuite: cipherSuite, trafficSecret: trafficSecret, aead: aead, iv: iv}
+}
+
+// next returns the protection state of the next traffic secret, which is used after a KeyUpdate.
+func (hc *halfConn) next() *halfConn {
+	ret := newHalfConn(hc.cipherSuite, hc.cipherSuite.NextTrafficSecret(hc.trafficSecret))
+	ret.paddingBlockSize = hc.paddingBlockSize
+	return ret
+}
+
+// nonce is the sequence number padded to the iv length and xored with the iv, as defined in RFC 8446, Section 5.3.
+func (hc *halfConn) nonce() []byte {
+	var seq [typesizes.Uint64Bytes]byte
+	binary.BigEndian.PutUint64(seq[:], hc.seq)
+	nonce := make([]byte, len(hc.iv))
+	copy(nonce, hc.iv)
+	offset := len(nonce) - len(seq)
+	for i := 0; i < len(seq); i++ {
+		nonce[offset+i] ^= seq[i]
+	}
+	return nonce
+}
+
+// seal wraps data of the given record type into a protected application data record. The record header is the
+// additional data of the AEAD, as defined in RFC 8446, Section 5.2.
+func (hc *halfConn) seal(recordType tlstypes.RecordType, data []byte) (*tlstypes.Record, error) {
+	inner := &tlstypes.InnerPlaintext{
+		Content:     data,
+		ContentType: recordType,
+	}
+	if hc.paddingBlockSize > 0 {
+		innerLen := len(data) + 1
+		paddedLen := (innerLen + hc.paddingBlockSize - 1) / hc.paddingBlockSize * hc.paddingBlockSize
+		if paddedLen > tlstypes.MaxSizeOfPlaintextRecord {
+			paddedLen = tlstypes.MaxSizeOfPlaintextRecord
+		}
+		inner.ZerosLen = paddedLen - innerLen
+	}
+	plaintext := inner.ToBinary()
+
+	record := tlstypes.MakeAppliactionRecord(nil)
+	record.Length = uint16(len(plaintext) + hc.aead.Overhead())
+	ciphertext, err := suite.Encrypt(hc.aead, plaintext, hc.nonce(), record.HeaderToBinary())
+	if err != nil {
+		return nil, err
+	}
+	record.Data = ciphertext
+	hc.seq++
+	hc.bytes += uint64(len(data))
+	return record, nil
+}
+
+// open decrypts a protected record and returns the real content type and the content.
+func (hc *halfConn) open(record *tlstypes.Record) (tlstypes.RecordType, []byte, error) {
+	if record.RecordType != tlstypes.ApplicationRecord {
+		err := fmt.Errorf("received unprotected record of type %d", record.RecordType)
+		return 0, nil, &alertError{tlstypes.UnexpectedMessage, err}
+	}
+	plaintext, err := suite.Decrypt(hc.aead, record.Data, hc.nonce(), record.HeaderToBinary())
+	if err != nil {
+		return 0, nil, &alertError{tlstypes.BadRecordMac, err}
+	}
+	hc.seq++
+
+	inner, err := tlstypes.ParseInnerPlaintext(plaintext)
+	if err != nil {
+		return 0, nil, &alertError{tlstypes.UnexpectedMessage, err}
+	}
+	return inner.ContentType, inner.Content, nil
+}
+
+// recordLayer reads and writes the records of a single connection. Records are protected once the keys for the
+// given direction are set.
+type recordLayer struct {
+	conn   io.ReadWriter
+	reader *tlstypes.RecordReader
+	hsBuf  tlstypes.HandshakeBuffer
+	in     *halfConn // protects received records, nil before the peer's handshake traffic keys are known
+	out    *halfConn // protects sent records, nil before the handshake traffic keys are known
+	// skipEarlyData is the number of bytes of rejected early data which may still be skipped. The budget ends with the
+	// first record which is read successfully.
+	skipEarlyData int
+	// handshakeComplete is set once the connection is handed to the application, dummy change_cipher_spec records are
+	// no longer allowed then.
+	handshakeComplete bool
+}
+
+func newRecordLayer(conn io.ReadWriter) *recordLayer {
+	ret := &recordLayer{
+		conn:   conn,
+		reader: tlstypes.NewRecordReader(conn),
+	}
+	return ret
+}
+
+// setReadKey starts protecting the received records with keys derived from trafficSecret.
+func (rl *recordLayer) setReadKey(cipherSuite *suite.CipherSuite, trafficSecret []byte) error {
+	if rl.hsBuf.Len() > 0 {
+		// RFC 8446, Section 5.1: handshake messages must not span key changes.
+		return &alertError{tlstypes.UnexpectedMessage, errors.New("handshake message spans a key change")}
+	}
+	rl.in = newHalfConn(cipherSuite, trafficSecret)
+	return nil
+}
+
+// updateReadKey switches the received records to the next traffic keys, after the peer sent a KeyUpdate.
+func (rl *recordLayer) updateReadKey() error {
+	common.AssertImpl(rl.in != nil)
+	if rl.hsBuf.Len() > 0 {
+		// RFC 8446, Section 5.1: handshake messages must not span key changes.
+		return &alertError{tlstypes.UnexpectedMessage, errors.New("handshake message spans a key change")}
+	}
+	rl.in = rl.in.next()
+	return nil
+}
+
+// updateWriteKey switches the sent records to the next traffic keys, after a KeyUpdate was sent.
+func (rl *recordLayer) updateWriteKey() {
+	common.AssertImpl(rl.out != nil)
+	rl.out = rl.out.next()
+}
+
+// writeKeyExhausted reports whether maxRecords records or, if it's not zero, maxBytes bytes were sent with the current
+// write keys.
+func (rl *recordLayer) writeKeyExhausted(maxRecords, maxBytes uint64) bool {
+	common.AssertImpl(rl.out != nil)
+	return rl.out.seq >= maxRecords || (maxBytes > 0 && rl.out.bytes >= maxBytes)
+}
+
+// clearWriteKey stops protecting the sent records, since the early data of the client was rejected by a
+// HelloRetryRequest.
+func (rl *recordLayer) clearWriteKey() {
+	rl.out = nil
+}
+
+// setWriteKey starts protecting the sent records with keys derived from trafficSecret.
+func (rl *recordLayer) setWriteKey(cipherSuite *suite.CipherSuite, trafficSecret []byte, paddingBlockSize int) {
+	rl.out = newHalfConn(cipherSuite, trafficSecret)
+	rl.out.paddingBlockSize = paddingBlockSize
+}
+
+// readRecord reads the next record and returns its real content type and content. While the skipEarlyData budget
+// lasts, protected records which can't be read are skipped: the server ignores early data it rejected, as required by
+// RFC 8446, Section 4.2.10. The change_cipher_spec records which peers send for middlebox compatibility during the
+// handshake are dropped, as defined in RFC 8446, Section 5.
+func (rl *recordLayer) readRecord() (tlstypes.RecordType, []byte, error) {
+	for {
+		record, err := rl.reader.ReadRecord()
+		if err != nil {
+			return 0, nil, err
+		}
+		if record.RecordType == tlstypes.ChangeCipherSpecRecord {
+			if rl.handshakeComplete || len(record.Data) != 1 || record.Data[0] != 0x01 {
+				err = errors.New("received unexpected change cipher spec record")
+				return 0, nil, &alertError{tlstypes.UnexpectedMessage, err}
+			}
+			continue
+		}
+		if rl.in == nil {
+			if record.RecordType == tlstypes.ApplicationRecord && rl.skipRecord(record) {
+				continue // early data sent before a HelloRetryRequest
+			}
+			return record.RecordType, record.Data, nil
+		}
+
+		recordType, data, err := rl.in.open(record)
+		if err != nil && alertFor(err, tlstypes.InternalError) == tlstypes.BadRecordMac && rl.skipRecord(record) {
+			continue // early data protected with the early traffic keys
+		}
+		if err == nil {
+			rl.skipEarlyData = 0
+		}
+		return recordType, data, err
+	}
+}
+
+func (rl *recordLayer) skipRecord(record *tlstypes.Record) bool {
+	if int(record.Length) > rl.skipEarlyData {
+		return false
+	}
+	rl.skipEarlyData -= int(record.Length)
+	return true
+}
+
+// readHandshakeMsg returns the next handshake message, including the handshake header.
+func (rl *recordLayer) readHandshakeMsg() ([]byte, error) {
+	for {
+		msg, err := rl.hsBuf.Next()
+		if err != nil {
+			return nil, &alertError{tlstypes.DecodeError, err}
+		}
+		if msg != nil {
+			return msg, nil
+		}
+
+		recordType, data, err := rl.readRecord()
+		if err != nil {
+			return nil, err
+		}
+
+		switch recordType {
+		case tlstypes.AlertRecord:
+			return nil, alertRecordErr(data)
+		case tlstypes.HandshakeRecord:
+			if len(data) == 0 {
+				return nil, &alertError{tlstypes.UnexpectedMessage, errors.New("received empty handshake record")}
+			}
+			rl.hsBuf.Write(data)
+		default:
+			err = fmt.Errorf("received unsupported record type %d", recordType)
+			return nil, &alertError{tlstypes.UnexpectedMessage, err}
+		}
+	}
+}
+
+// readApplicationData reads the content of the next application data record. A close_notify alert is reported as
+// io.EOF. Post-handshake messages are buffered for the caller, which is then handed no data.
+func (rl *recordLayer) readApplicationData() ([]byte, error) {
+	recordType, data, err := rl.readRecord()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case rl.in == nil:
+		return nil, &alertError{tlstypes.UnexpectedMessage, errors.New("received application data before handshake")}
+	case recordType == tlstypes.AlertRecord:
+		return nil, alertRecordErr(data)
+	case recordType == tlstypes.ApplicationRecord:
+		return data, nil
+	case recordType == tlstypes.HandshakeRecord:
+		if len(data) == 0 {
+			return nil, &alertError{tlstypes.UnexpectedMessage, errors.New("received empty handshake record")}
+		}
+		rl.hsBuf.Write(data)
+		return nil, nil
+	default:
+		err = fmt.Errorf("received unsupported record type %d", recordType)
+		return nil, &alertError{tlstypes.UnexpectedMessage, err}
+	}
+}
+
+// writeRecord sends data in as many records of recordType as needed.
+func (rl *recordLayer) writeRecord(recordType tlstypes.RecordType, data []byte) error {
+	for {
+		n := len(data)
+		if n > maxPlaintextFragment {
+			n = maxPlaintextFragment
+		}
+
+		var record *tlstypes.Record
+		if rl.out != nil {
+			var err error
+			record, err = rl.out.seal(recordType, data[:n])
+			if err != nil {
+				return err
+			}
+		} else {
+			record = tlstypes.MakePlaintextRecord(recordType, data[:n])
+		}
+		if _, err := record.WriteTo(rl.conn); err != nil {
+			return err
+		}
+
+		data = data[n:]
+		if len(data) == 0 {
+			return nil
+		}
+	}
+}
+
+// sendAlert writes a fatal alert, it is protected if the write keys are set.
+func (rl *recordLayer) sendAlert(desc tlstypes.AlertDescription) {
+	a := &tlstypes.Alert{
+		Level:       tlstypes.FatalAlertLevel,
+		Description: desc,
+	}
+	_ = rl.writeRecord(tlstypes.AlertRecord, a.ToBinary())
+}
+
+// alertRecordErr converts a received alert to an error. A close_notify alert is reported as io.EOF.
+func alertRecordErr(data []byte) error {
+	alert, err := tlstypes.ParseAlert(data)
+	if err != nil {
+		return errors.New("failed to parse alert record")
+	}
+	if alert.Description == tlstypes.CloseNotify {
+		return io.EOF
+	}
+	return fmt.Errorf("received alert message %+v", alert)
+}
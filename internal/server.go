@@ -1,16 +1,19 @@
 package internal
 
 import (
-	"bytes"
+	"context"
+	crand "crypto/rand"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"runtime/debug"
+	"strconv"
+	"sync"
 	"time"
 
-	"github.com/tls-handshake/internal/suite"
-	tlstypes "github.com/tls-handshake/internal/tls_types"
-	cbytes "github.com/tls-handshake/pkg/bytes"
+	"github.com/tls-handshake/internal/certs"
 	limitconn "github.com/tls-handshake/pkg/limit_conn"
 	"github.com/tls-handshake/pkg/rand"
 )
@@ -20,24 +23,74 @@ var (
 	postHandshakeConnLimit = time.Minute
 )
 
+// ServerClosedErr is returned by Listen after a call to Close or Shutdown.
+var ServerClosedErr = errors.New("server closed")
+
 type Server struct {
-	connections   []connState
+	Config *Config
+	// Handler serves the connections after a successful handshake. If it's nil every PING is answered with a PONG.
+	Handler     Handler
+	certificate *tls.Certificate
+	ticketKey   []byte
+	replays     *replayFilter
+
+	mux      sync.Mutex
+	listener net.Listener
+	conns    map[*limitconn.Wrapper]*Conn // live connections, the value is nil until the handshake succeeds
+	handlers sync.WaitGroup               // running handleConnection calls
+	closed   bool
 }
 
+// Listen listens on the TCP address ipv4:port and serves the incoming connections. It always returns a non-nil
+// error, after Close or Shutdown the error is ServerClosedErr.
 func (s *Server) Listen(ipv4 string, port uint16) error {
-	s.connections = make([]connState, 0)
-	address := fmt.Sprintf("%s:%d", ipv4, port)
+	if err := s.loadCertificate(ipv4); err != nil {
+		return err
+	}
+	address := net.JoinHostPort(ipv4, strconv.Itoa(int(port)))
 	listen, err := net.Listen("tcp", address)
 	if err != nil {
 		return err
 	}
-	defer listen.Close()
 
 	fmt.Printf("server listening on %d\n", port)
+	return s.Serve(listen)
+}
+
+// Serve accepts connections on l and serves each of them in a new goroutine. Serve takes ownership of l and closes it
+// before returning. It always returns a non-nil error, after Close or Shutdown the error is ServerClosedErr.
+func (s *Server) Serve(l net.Listener) error {
+	if err := s.trackListener(l); err != nil {
+		_ = l.Close()
+		return err
+	}
+	defer l.Close()
+
+	if s.certificate == nil {
+		host, _, err := net.SplitHostPort(l.Addr().String())
+		if err != nil {
+			host = "" // not a host:port address, e.g. a unix socket
+		}
+		if err := s.loadCertificate(host); err != nil {
+			return err
+		}
+	}
+	if s.ticketKey == nil {
+		if err := s.loadTicketKey(); err != nil {
+			return err
+		}
+	}
+	if s.replays == nil {
+		s.replays = newReplayFilter()
+	}
+
 	// s.startSentinel()
 	for {
-		conn, err := listen.Accept()
+		conn, err := l.Accept()
 		if err != nil {
+			if s.isClosed() {
+				return ServerClosedErr
+			}
 			fmt.Println(err)
 			if conn != nil {
 				_ = conn.Close()
@@ -45,95 +98,198 @@ func (s *Server) Listen(ipv4 string, port uint16) error {
 			continue
 		}
 
-		go s.handleConnection(conn)
+		rawConn := limitconn.Wrap(conn, "server_"+rand.GenString(32))
+		if !s.trackConn(rawConn, nil) {
+			_ = rawConn.Close()
+			continue
+		}
+		go s.handleConnection(rawConn)
 	}
 }
 
-func (s *Server) handleConnection(conn net.Conn) {
-	var err error
-	rawConn := limitconn.Wrap(conn, "server_"+rand.GenString(32))
-	rawConn.SetLimit(preHandshakeConnLimit)
-	handshake := NewServerHandshake(rawConn)
-	if err = handshake.Handshake(); err != nil {
-		fmt.Println(err)
-		rawConn.Close()
-		return
+// Close immediately closes the listener and all connections, without waiting to send a close_notify alert to a peer
+// which may not read it. Listen returns ServerClosedErr after Close.
+func (s *Server) Close() error {
+	s.mux.Lock()
+	err := s.closeListenerLocked()
+	rawConns, _ := s.connsLocked()
+	s.mux.Unlock()
+
+	for _, rawConn := range rawConns {
+		_ = rawConn.Close()
 	}
+	return err
+}
 
-	rawConn.SetLimit(postHandshakeConnLimit)
-	state := connState{rawConn, handshake}
+// Shutdown gracefully shuts down the server. It stops accepting connections, sends a close_notify alert to every
+// connection and waits for the handlers to return. If ctx expires first, the remaining connections are closed and
+// the context's error is returned. Listen returns ServerClosedErr after Shutdown.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mux.Lock()
+	err := s.closeListenerLocked()
+	_, conns := s.connsLocked()
+	s.mux.Unlock()
 
-	for {
-		err = state.recv()
-		if err == io.EOF {
-			err = nil // EOF is expected when communication is done.
-			break
-		}
-		if err != nil {
-			break
-		}
-		if err = state.Pong(); err != nil {
-			break
-		}
+	for _, conn := range conns {
+		// A handler blocked in Write holds the write lock of its Conn, the alert must not hold up the shutdown:
+		go conn.closeNotify()
 	}
 
-	if err != nil {
-		fmt.Println(err)
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		_ = s.Close()
+		return ctx.Err()
+	}
+}
+
+func (s *Server) closeListenerLocked() error {
+	s.closed = true
+	if s.listener == nil {
+		return nil
 	}
+	return s.listener.Close()
+}
 
-	rawConn.Close()
+// connsLocked returns the raw connections and the Conns of the connections which finished the handshake, so that
+// they can be closed without holding s.mux.
+func (s *Server) connsLocked() ([]*limitconn.Wrapper, []*Conn) {
+	rawConns := make([]*limitconn.Wrapper, 0, len(s.conns))
+	conns := make([]*Conn, 0, len(s.conns))
+	for rawConn, conn := range s.conns {
+		rawConns = append(rawConns, rawConn)
+		if conn != nil {
+			conns = append(conns, conn)
+		}
+	}
+	return rawConns, conns
 }
 
-type connState struct {
-	rawConn   *limitconn.Wrapper
-	handshake *serverHandshake
+func (s *Server) isClosed() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.closed
 }
 
-func (s *connState) Pong() error {
-	plaintext := []byte("PONG")
-	nonce := cbytes.UInt64ToBytes(s.handshake.seq)
-	ciphertext, err := suite.Encrypt(plaintext, s.handshake.serverHandshakeKey, nonce)
-	if err != nil {
-		return err
+func (s *Server) trackListener(l net.Listener) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.closed {
+		return ServerClosedErr
 	}
-	ciphertext, err = suite.Encrypt(ciphertext, cbytes.Xor(s.handshake.serverHandshakeIv, nonce), nonce)
-	if err != nil {
-		return err
+	s.listener = l
+	return nil
+}
+
+// trackConn registers a new connection and returns false if the server is closed. Handshaken connections are
+// registered again with their Conn, if the server is shutting down by then the Conn is sent a close_notify alert.
+func (s *Server) trackConn(rawConn *limitconn.Wrapper, conn *Conn) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[*limitconn.Wrapper]*Conn)
 	}
-	_, err = s.rawConn.Write(ciphertext)
-	if err != nil {
-		return err
+
+	if conn == nil {
+		if s.closed {
+			return false
+		}
+		s.handlers.Add(1)
+	} else if s.closed {
+		go conn.closeNotify()
 	}
+	s.conns[rawConn] = conn
+	return true
+}
 
-	s.handshake.seq++
-	return nil
+func (s *Server) untrackConn(rawConn *limitconn.Wrapper) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.conns, rawConn)
+	s.handlers.Done()
 }
 
-func (c *connState) recv() error {
-	var data [tlstypes.MaxSizeOfPlaintextRecord]byte
-	n, err := c.rawConn.Read(data[:])
+// loadCertificate picks the configured certificate or generates a self-signed one for host, if host is not empty.
+func (s *Server) loadCertificate(host string) error {
+	if s.Config != nil && len(s.Config.Certificates) > 0 {
+		s.certificate = &s.Config.Certificates[0]
+		return nil
+	}
+	var hosts []string
+	if host != "" {
+		hosts = append(hosts, host)
+	}
+	cert, err := certs.GenerateSelfSigned(hosts...)
 	if err != nil {
 		return err
 	}
+	s.certificate = &cert
+	return nil
+}
 
-	ciphertext := data[:n]
-	nonce := cbytes.UInt64ToBytes(c.handshake.seq)
-	ciphertext, err = suite.Decrypt(ciphertext, cbytes.Xor(c.handshake.clientHandshakeIv, nonce), nonce)
-	if err != nil {
+// loadTicketKey picks the configured session ticket key or generates a random one.
+func (s *Server) loadTicketKey() error {
+	key := make([]byte, ticketKeyLen)
+	if s.Config != nil && s.Config.SessionTicketKey != [ticketKeyLen]byte{} {
+		copy(key, s.Config.SessionTicketKey[:])
+	} else if _, err := crand.Read(key); err != nil {
 		return err
 	}
-	plaintext, err := suite.Decrypt(ciphertext, c.handshake.clientHandshakeKey, nonce)
-	if err != nil {
-		return err
+	s.ticketKey = key
+	return nil
+}
+
+func (s *Server) handleConnection(rawConn *limitconn.Wrapper) {
+	defer s.untrackConn(rawConn)
+	defer func() {
+		// A panic, of the handshake on a hostile message or of the Handler, only closes this connection:
+		if r := recover(); r != nil {
+			fmt.Printf("panic serving connection: %v\n%s", r, debug.Stack())
+			rawConn.Close()
+		}
+	}()
+
+	var err error
+	rawConn.SetLimit(preHandshakeConnLimit)
+	handshake := NewServerHandshake(rawConn, s.Config, s.certificate, s.ticketKey, s.replays)
+	if err = handshake.Handshake(); err != nil {
+		fmt.Println(err)
+		rawConn.Close()
+		return
 	}
 
-	switch {
-	case bytes.Equal(plaintext, []byte("PING")):
-		fmt.Println(string(plaintext))
-	default:
-		return errors.New("unsupported response message")
+	rawConn.SetLimit(postHandshakeConnLimit)
+	tlsConn := newConn(rawConn, handshake.records, handshake.peerCertificates, s.Config)
+	tlsConn.server = true
+	tlsConn.didResume = handshake.psk != nil
+	tlsConn.sessionTicket = handshake.sessionTicket
+	tlsConn.earlyDataAccepted = handshake.earlyDataAccepted
+	tlsConn.cipherSuite = handshake.cipherSuite
+	tlsConn.exporterMasterSecret = handshake.exporterMasterSecret
+	tlsConn.negotiatedProtocol = handshake.alpnProtocol
+	tlsConn.serverName = handshake.serverName
+	tlsConn.peerExtensions = handshake.peerExtensions
+	tlsConn.input = handshake.earlyData
+	s.trackConn(rawConn, tlsConn)
+
+	handler := s.Handler
+	if handler == nil {
+		handler = pingPongHandler
+	}
+	err = handler.ServeConn(tlsConn)
+	if err == io.EOF {
+		err = nil // EOF is expected when communication is done.
+	}
+	if err != nil {
+		fmt.Println(err)
 	}
 
-	c.handshake.seq++
-	return nil
+	tlsConn.Close()
 }
@@ -1,12 +1,17 @@
 package internal
 
 import (
-	"crypto/ecdsa"
+	"crypto"
+	"crypto/hmac"
 	crand "crypto/rand"
-	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash"
+	"strings"
+	"time"
 
 	"github.com/tls-handshake/internal/common"
 	"github.com/tls-handshake/internal/ecdh"
@@ -14,118 +19,592 @@ import (
 	tlstypes "github.com/tls-handshake/internal/tls_types"
 	"github.com/tls-handshake/internal/tls_types/extensions"
 	limitconn "github.com/tls-handshake/pkg/limit_conn"
-	"github.com/tls-handshake/pkg/streams"
 )
 
+const helloRetryCookieLen = 32
+
 type serverHandshake struct {
-	rawConn     *limitconn.Wrapper
+	records     *recordLayer
+	config      *Config
+	certificate *tls.Certificate
 	clientHello *tlstypes.ClientHelloMsg
 	serverHello *tlstypes.ServerHelloMsg
-	seq         uint64
+	cipherSuite *suite.CipherSuite
+	transcript  hash.Hash // nil until the cipher suite, and so the transcript hash, is known
 
-	serverPrivateKey  *ecdsa.PrivateKey
+	group             ecdh.Group
+	serverPrivateKey  crypto.PrivateKey
 	clientPubKeyBytes []byte
-	clientPubicKey    *ecdsa.PublicKey
-	clientHandshakeKey []byte
-	serverHandshakeKey []byte
-	clientHandshakeIv []byte
-	serverHandshakeIv []byte
+	cookie            []byte // sent in the HelloRetryRequest, nil if none was sent
+
+	handshakeSecret                []byte
+	masterSecret                   []byte
+	clientHandshakeTrafficSecret   []byte
+	serverHandshakeTrafficSecret   []byte
+	clientApplicationTrafficSecret []byte
+	serverApplicationTrafficSecret []byte
+	exporterMasterSecret           []byte
+
+	signatureScheme  tls.SignatureScheme // used to sign the server CertificateVerify
+	peerCertificates []*x509.Certificate
+	alpnProtocol     string                 // selected from the protocols offered by the client, empty if none
+	serverName       string                 // sent by the client with the server_name extension, empty if none
+	peerExtensions   []extensions.Extension // custom extensions of the ClientHello
+	replyExtensions  []extensions.Extension // the extensions of Config.Extensions of types the client sent
+
+	ticketKey           []byte
+	psk                 []byte // resumption PSK of the accepted ticket, nil in a full handshake
+	selectedPskIdentity uint16
+	issueTicket         bool   // the client can resume with psk_dhe_ke, so it gets a session ticket
+	sessionTicket       []byte // NewSessionTicket message, sent once the handshake is done
+
+	replays                  *replayFilter
+	earlyDataAccepted        bool
+	maxEarlyData             uint32 // the client must not send more early data than its ticket allows
+	clientEarlyTrafficSecret []byte
+	earlyData                []byte // received before the client Finished, it's read first from the connection
 }
 
-func NewServerHandshake(conn *limitconn.Wrapper) *serverHandshake {
+// NewServerHandshake creates the handshake of a server. The certificate is presented unless another one is selected for
+// the server name sent by the client, see Config.GetCertificate. Session tickets are sealed with ticketKey and the
+// early data of resumed sessions is checked against replays, which is shared by the connections of the server.
+func NewServerHandshake(conn *limitconn.Wrapper, config *Config, certificate *tls.Certificate, ticketKey []byte,
+	replays *replayFilter) *serverHandshake {
+
+	common.AssertImpl(certificate != nil && replays != nil)
 	ret := &serverHandshake{
-		rawConn: conn,
+		records:     newRecordLayer(conn),
+		config:      config,
+		certificate: certificate,
+		ticketKey:   ticketKey,
+		replays:     replays,
 	}
 	return ret
 }
 
 func (c *serverHandshake) Handshake() error {
 	if err := c.readClientHelloMsg(); err != nil {
-		c.sendFatalAlert()
+		c.sendFatalAlert(alertFor(err, tlstypes.HandshakeFailure))
 		return err
 	}
+	if c.clientPubKeyBytes == nil {
+		// The client has no key share for the selected group, ask it for one:
+		if err := c.writeHelloRetryRequestMsg(); err != nil {
+			c.sendFatalAlert(tlstypes.InternalError)
+			return err
+		}
+		if err := c.readClientHelloMsg(); err != nil {
+			c.sendFatalAlert(alertFor(err, tlstypes.HandshakeFailure))
+			return err
+		}
+	}
 	cfg := &tlstypes.ServerHelloExtParams{}
+	if c.psk != nil {
+		cfg.SelectedPskIdentity = &c.selectedPskIdentity
+	}
 	if err := c.genServerKey(cfg); err != nil {
-		c.sendFatalAlert()
+		c.sendFatalAlert(tlstypes.HandshakeFailure)
 		return err
 	}
 	if err := c.writeServerHelloMsg(cfg); err != nil {
-		c.sendFatalAlert()
+		c.sendFatalAlert(tlstypes.HandshakeFailure)
 		return err
 	}
 
-	sharedKey, err := ecdh.GenerateSharedSecret(c.serverPrivateKey, c.clientPubicKey)
+	sharedKey, err := c.group.SharedSecret(c.serverPrivateKey, c.clientPubKeyBytes)
 	if err != nil {
-		c.sendFatalAlert()
+		c.sendFatalAlert(tlstypes.IllegalParameter)
 		return err
 	}
 
-	helloHash, err := c.calculateHandshakeHash()
-	if err != nil {
-		c.sendFatalAlert()
+	earlySecret := c.cipherSuite.Extract(c.psk, nil)
+	derivedSecret := c.cipherSuite.DeriveSecret(earlySecret, suite.DerivedLabel, nil)
+	handshakeSecret := c.cipherSuite.Extract(sharedKey, derivedSecret)
+	clientHandshakeTrafficSecret := c.cipherSuite.DeriveSecret(handshakeSecret, suite.ClientHandshakeTrafficLabel, c.transcript)
+	serverHandshakeTrafficSecret := c.cipherSuite.DeriveSecret(handshakeSecret, suite.ServerHandshakeTrafficLabel, c.transcript)
+
+	// save state:
+	c.handshakeSecret = handshakeSecret
+	c.clientHandshakeTrafficSecret = clientHandshakeTrafficSecret
+	c.serverHandshakeTrafficSecret = serverHandshakeTrafficSecret
+	c.records.setWriteKey(c.cipherSuite, serverHandshakeTrafficSecret, c.config.paddingBlockSize())
+	readSecret := clientHandshakeTrafficSecret
+	if c.earlyDataAccepted {
+		// The early data follows the ClientHello, the client switches to the handshake keys after EndOfEarlyData.
+		readSecret = c.clientEarlyTrafficSecret
+	}
+	if err := c.records.setReadKey(c.cipherSuite, readSecret); err != nil {
+		c.sendFatalAlert(alertFor(err, tlstypes.UnexpectedMessage))
 		return err
 	}
 
-	c.seq = 0 // start counting records received
+	if err := c.writeEncryptedExtensionsMsg(); err != nil {
+		c.sendFatalAlert(tlstypes.InternalError)
+		return err
+	}
+	if c.psk == nil {
+		// RFC 8446, Section 4.3.2: a resumed session is authenticated by the PSK, there are no certificates.
+		if err := c.writeCertificateFlight(); err != nil {
+			c.sendFatalAlert(tlstypes.InternalError)
+			return err
+		}
+	}
+	if err := c.writeFinishedMsg(); err != nil {
+		c.sendFatalAlert(tlstypes.InternalError)
+		return err
+	}
+	c.deriveApplicationSecrets()
+	// The server flight is done. Everything the server sends from now on, including alerts about the client flight, is
+	// protected with the application traffic keys, which the client reads with once it sent its Finished.
+	c.records.setWriteKey(c.cipherSuite, c.serverApplicationTrafficSecret, c.config.paddingBlockSize())
+	if c.earlyDataAccepted {
+		if err := c.readEarlyData(); err != nil {
+			c.sendFatalAlert(alertFor(err, tlstypes.UnexpectedMessage))
+			return err
+		}
+		if err := c.records.setReadKey(c.cipherSuite, c.clientHandshakeTrafficSecret); err != nil {
+			c.sendFatalAlert(alertFor(err, tlstypes.UnexpectedMessage))
+			return err
+		}
+	}
+	if c.psk == nil && c.config.clientAuth() != tls.NoClientCert {
+		if err := c.readClientCertificateMsg(); err != nil {
+			c.sendFatalAlert(alertFor(err, tlstypes.BadCertificate))
+			return err
+		}
+		if err := c.readClientCertificateVerifyMsg(); err != nil {
+			c.sendFatalAlert(alertFor(err, tlstypes.DecryptError))
+			return err
+		}
+	}
+	if err := c.readClientFinishedMsg(); err != nil {
+		c.sendFatalAlert(alertFor(err, tlstypes.DecryptError))
+		return err
+	}
 
-	earlySecret := suite.Extract(nil, nil)
-	derivedSecret := suite.DeriveSecret(earlySecret, "derived", nil)
-	handshakeSecret := suite.Extract(sharedKey, derivedSecret)
-	clientHandshakeTrafficSecret := suite.DeriveSecret(handshakeSecret, suite.ClientHandshakeTrafficLabel, helloHash)
-	serverHandshakeTrafficSecret := suite.DeriveSecret(handshakeSecret, suite.ServerHandshakeTrafficLabel, helloHash)
-	c.clientHandshakeKey = suite.DeriveSecret(clientHandshakeTrafficSecret, suite.KeyLabel, nil)
-	c.serverHandshakeKey = suite.DeriveSecret(serverHandshakeTrafficSecret, suite.KeyLabel, nil)
-	c.clientHandshakeIv = suite.DeriveSecret(clientHandshakeTrafficSecret, suite.IVLabel, nil)
-	c.serverHandshakeIv = suite.DeriveSecret(serverHandshakeTrafficSecret, suite.IVLabel, nil)
+	// Both sides have sent Finished, switch to the application traffic keys:
+	if err := c.records.setReadKey(c.cipherSuite, c.clientApplicationTrafficSecret); err != nil {
+		c.sendFatalAlert(alertFor(err, tlstypes.UnexpectedMessage))
+		return err
+	}
+	if c.issueTicket {
+		if err := c.makeNewSessionTicketMsg(); err != nil {
+			c.sendFatalAlert(tlstypes.InternalError)
+			return err
+		}
+	}
 
 	fmt.Println("hadshake success")
 	return nil
 }
 
 func (c *serverHandshake) readClientHelloMsg() error {
-	var buf [tlstypes.MaxSizeOfPlaintextRecord]byte
-	n, err := c.rawConn.Read(buf[:])
+	data, err := c.records.readHandshakeMsg()
 	if err != nil {
 		return err
 	}
 
-	record, err := tlstypes.ParseRecord(buf[:n])
+	if tlstypes.HandshakeMsgType(data[0]) != tlstypes.ClientHelloMsgType {
+		err = fmt.Errorf("received handshake message %d instead of client hello", data[0])
+		return &alertError{tlstypes.UnexpectedMessage, err}
+	}
+	clientHelloMsg, err := tlstypes.ParseClientHelloMsg(data)
 	if err != nil {
+		return &alertError{tlstypes.DecodeError, err}
+	}
+
+	exts, err := extensions.ParseExtensions(clientHelloMsg.ExtensionData, clientHelloMsg.ExtensionsLen, extensions.ClientHelloMsgContext)
+	if err != nil {
+		return extensionsAlertError(err)
+	}
+	if err := checkClientHelloVersion(clientHelloMsg, exts); err != nil {
 		return err
 	}
-	if record.RecordType != tlstypes.HandshakeRecord {
-		return errors.New("not a handshake record")
+	cipherSuite, transcript := c.cipherSuite, c.transcript
+	if c.cookie != nil {
+		// This is the second ClientHello, it must answer the HelloRetryRequest:
+		if err := c.checkRetriedClientHello(clientHelloMsg, exts); err != nil {
+			return err
+		}
+	} else {
+		if cipherSuite, err = c.selectCipherSuite(clientHelloMsg); err != nil {
+			return err
+		}
+		transcript = cipherSuite.Hash.New()
 	}
-
-	clientHelloMsg, err := tlstypes.ParseClientHelloMsg(record.Data)
+	group, clientShare, err := c.selectKeyShare(exts)
 	if err != nil {
 		return err
 	}
-
-	exts, err := extensions.ParseExtensions(clientHelloMsg.ExtensionData, clientHelloMsg.ExtensionsLen)
+	serverName := ""
+	if sne, ok := extensions.FindExtension(exts, extensions.ServerNameType).(*extensions.ServerNameExtension); ok {
+		serverName = strings.TrimSuffix(strings.ToLower(sne.HostName()), ".")
+	}
+	certificate, err := c.selectCertificate(clientHelloMsg, exts, serverName)
+	if err != nil {
+		return err
+	}
+	signatureScheme, err := c.selectSignatureScheme(exts, certificate)
+	if err != nil {
+		return err
+	}
+	alpnProtocol, err := c.selectALPNProtocol(exts)
 	if err != nil {
 		return err
 	}
-	ext := extensions.FindExtension(exts, extensions.KeyShareType)
-	kse, ok := ext.(*extensions.KeyShareExtension)
-	common.AssertImpl(ok)
+	session, pskIdentity, err := c.selectPreSharedKey(data, exts, cipherSuite, transcript, serverName)
+	if err != nil {
+		return err
+	}
+	var peerCertificates []*x509.Certificate
+	if session != nil {
+		if peerCertificates, err = parseCertificates(session.certificates); err != nil {
+			return err
+		}
+	}
+	pkme, _ := extensions.FindExtension(exts, extensions.PskKeyExchangeModesType).(*extensions.PskKeyExchangeModesExtension)
+	earlyDataAccepted := false
+	if _, ok := extensions.FindExtension(exts, extensions.EarlyDataType).(*extensions.EarlyDataExtension); ok {
+		// RFC 8446, Section 4.2.10: early data is only accepted in the first ClientHello, with the first PSK.
+		earlyDataAccepted = c.cookie == nil && clientShare != nil && session != nil && pskIdentity == 0 &&
+			session.alpnProtocol == alpnProtocol && c.acceptEarlyData(session, cipherSuite, exts)
+		if !earlyDataAccepted {
+			c.records.skipEarlyData = int(c.config.maxEarlyData()) + tlstypes.MaxSizeOfCiphertextRecord
+		}
+	}
+
+	if _, err = transcript.Write(data); err != nil {
+		return err
+	}
+	var clientEarlyTrafficSecret []byte
+	if earlyDataAccepted {
+		earlySecret := cipherSuite.Extract(session.secret, nil)
+		clientEarlyTrafficSecret = cipherSuite.DeriveSecret(earlySecret, suite.ClientEarlyTrafficLabel, transcript)
+	}
 
 	// save state
-	c.clientPubKeyBytes = kse.PublicKey
+	c.cipherSuite = cipherSuite
+	c.transcript = transcript
+	c.group = group
+	c.signatureScheme = signatureScheme
+	c.alpnProtocol = alpnProtocol
+	c.serverName = serverName
+	c.certificate = certificate
+	c.peerExtensions = registeredExtensions(exts)
+	c.replyExtensions = nil
+	for _, ext := range c.config.extensions() {
+		if extensions.FindExtension(exts, ext.GetType()) != nil {
+			c.replyExtensions = append(c.replyExtensions, ext)
+		}
+	}
+	c.psk = nil
+	if session != nil {
+		c.psk = session.secret
+		c.selectedPskIdentity = pskIdentity
+		c.peerCertificates = peerCertificates
+	}
+	c.issueTicket = !c.config.sessionTicketsDisabled() && pkme != nil && pkme.Contains(extensions.PskDheKeMode)
+	c.earlyDataAccepted = earlyDataAccepted
+	if earlyDataAccepted {
+		c.maxEarlyData = session.maxEarlyData
+		c.clientEarlyTrafficSecret = clientEarlyTrafficSecret
+	}
+	c.clientPubKeyBytes = nil
+	if clientShare != nil {
+		c.clientPubKeyBytes = clientShare.PublicKey
+	}
 	c.clientHello = clientHelloMsg
-	c.clientPubicKey, err = ecdh.UnmarshalPubKey(ecdh.DefaultCurve, kse.PublicKey)
+
+	return nil
+}
+
+// checkClientHelloVersion checks that the client offers TLS 1.3 in supported_versions and only the null compression
+// method, as required by RFC 8446, Section 4.1.2. The legacy version of the message is ignored.
+func checkClientHelloVersion(clientHelloMsg *tlstypes.ClientHelloMsg, exts []extensions.Extension) error {
+	sve, ok := extensions.FindExtension(exts, extensions.SupporteVersionsType).(*extensions.SupportedVersions)
+	if !ok || !sve.Supports(tls.VersionTLS13) {
+		return &alertError{tlstypes.ProtocolVersion, errors.New("client does not support tls 1.3")}
+	}
+	if len(clientHelloMsg.CompressionMethods) != 1 || clientHelloMsg.CompressionMethods[0] != 0 {
+		return &alertError{tlstypes.IllegalParameter, errors.New("client hello has a compression method")}
+	}
+	return nil
+}
+
+// checkRetriedClientHello checks that the second ClientHello still offers the selected cipher suite, has a single key
+// share for the group selected in the HelloRetryRequest and echoes the cookie, as required by RFC 8446, Section 4.1.2.
+func (c *serverHandshake) checkRetriedClientHello(clientHelloMsg *tlstypes.ClientHelloMsg, exts []extensions.Extension) error {
+	common.AssertImpl(c.group != nil && c.cipherSuite != nil)
+	if !containsCipherSuite(clientHelloMsg.CipherSuite, c.cipherSuite.ID) {
+		err := errors.New("second client hello does not offer the selected cipher suite")
+		return &alertError{tlstypes.IllegalParameter, err}
+	}
+	kse, ok := extensions.FindExtension(exts, extensions.KeyShareType).(*extensions.KeyShareExtension)
+	if !ok || len(kse.Entries) != 1 || kse.Entries[0].CurveID != c.group.CurveID() {
+		err := errors.New("second client hello has no key share for the selected group")
+		return &alertError{tlstypes.IllegalParameter, err}
+	}
+	ce, ok := extensions.FindExtension(exts, extensions.CookieType).(*extensions.CookieExtension)
+	if !ok || !hmac.Equal(ce.Cookie, c.cookie) {
+		return &alertError{tlstypes.IllegalParameter, errors.New("second client hello has an invalid cookie")}
+	}
+	return nil
+}
+
+// selectCipherSuite picks the most preferred cipher suite of the server which the client offered.
+func (c *serverHandshake) selectCipherSuite(clientHelloMsg *tlstypes.ClientHelloMsg) (*suite.CipherSuite, error) {
+	for _, pref := range c.config.cipherSuites() {
+		if !containsCipherSuite(clientHelloMsg.CipherSuite, pref) {
+			continue
+		}
+		return suite.CipherSuiteByID(pref)
+	}
+	return nil, &alertError{tlstypes.HandshakeFailure, errors.New("client offers no cipher suite of the server")}
+}
+
+// selectPreSharedKey picks the first offered PSK which is a valid ticket of the server for a cipher suite with the hash
+// of the selected one and checks its binder, as defined in RFC 8446, Section 4.2.11. If there is no such PSK the result
+// is nil and a full handshake is done. The transcript must hold the messages before data.
+func (c *serverHandshake) selectPreSharedKey(data []byte, exts []extensions.Extension, cipherSuite *suite.CipherSuite,
+	transcript hash.Hash, serverName string) (*serverSessionState, uint16, error) {
+
+	pske, ok := extensions.FindExtension(exts, extensions.PreSharedKeyType).(*extensions.PreSharedKeyExtension)
+	if !ok {
+		return nil, 0, nil
+	}
+	if exts[len(exts)-1] != extensions.Extension(pske) {
+		return nil, 0, &alertError{tlstypes.IllegalParameter, errors.New("pre shared key is not the last extension")}
+	}
+	pkme, ok := extensions.FindExtension(exts, extensions.PskKeyExchangeModesType).(*extensions.PskKeyExchangeModesExtension)
+	if !ok {
+		return nil, 0, &alertError{tlstypes.MissingExtension, errors.New("client hello has no psk key exchange modes")}
+	}
+	if c.config.sessionTicketsDisabled() || !pkme.Contains(extensions.PskDheKeMode) {
+		return nil, 0, nil
+	}
+
+	clientAuth := c.config.clientAuth()
+	for i, identity := range pske.Identities {
+		session, err := openTicket(c.ticketKey, identity.Identity)
+		if err != nil {
+			continue // not a ticket of this server
+		}
+		createdAt := time.Unix(int64(session.createdAt), 0)
+		if c.config.now().Sub(createdAt) > sessionTicketLifetime {
+			continue
+		}
+		pskSuite, err := suite.CipherSuiteByID(session.cipherSuite)
+		if err != nil || pskSuite.Hash != cipherSuite.Hash {
+			continue
+		}
+		if session.serverName != serverName {
+			continue // RFC 8446, Section 4.6.1: the session is tied to the server name of the full handshake
+		}
+		if len(session.certificates) == 0 &&
+			(clientAuth == tls.RequireAnyClientCert || clientAuth == tls.RequireAndVerifyClientCert) {
+			continue
+		}
+		// A resumed handshake has no Certificate message, so the client certificate of the session is checked again:
+		if clientAuth != tls.NoClientCert && len(session.certificates) > 0 {
+			leaf, err := x509.ParseCertificate(session.certificates[0])
+			if err != nil || c.config.now().After(leaf.NotAfter) {
+				continue
+			}
+		}
+
+		binderTranscript := cipherSuite.CloneHash(transcript)
+		if _, err := binderTranscript.Write(data[:len(data)-pske.BindersFullLen()]); err != nil {
+			return nil, 0, err
+		}
+		binder := cipherSuite.PSKBinder(session.secret, binderTranscript)
+		if !hmac.Equal(binder, pske.Binders[i].Binder) {
+			return nil, 0, &alertError{tlstypes.DecryptError, errors.New("invalid psk binder")}
+		}
+		return session, uint16(i), nil
+	}
+	return nil, 0, nil
+}
+
+// acceptEarlyData reports whether the early data sent with the first PSK of the ClientHello can be accepted. The ticket
+// must allow early data for the selected cipher suite and the ClientHello must be fresh and seen for the first time, as
+// described in RFC 8446, Section 8.
+func (c *serverHandshake) acceptEarlyData(session *serverSessionState, cipherSuite *suite.CipherSuite,
+	exts []extensions.Extension) bool {
+
+	if c.config.maxEarlyData() == 0 || session.maxEarlyData == 0 || session.cipherSuite != cipherSuite.ID {
+		return false
+	}
+	pske := extensions.FindExtension(exts, extensions.PreSharedKeyType).(*extensions.PreSharedKeyExtension)
+	now := c.config.now()
+	if !isFreshTicketAge(session, pske.Identities[0].ObfuscatedTicketAge, now) {
+		return false
+	}
+	return c.replays.firstUse(pske.Binders[0].Binder, now)
+}
+
+// selectALPNProtocol picks the most preferred application protocol of the server which the client offered. If the
+// server has protocols, but the client offers none of them, the handshake fails as required by RFC 7301, Section 3.2.
+func (c *serverHandshake) selectALPNProtocol(exts []extensions.Extension) (string, error) {
+	alpne, ok := extensions.FindExtension(exts, extensions.ALPNType).(*extensions.ALPNExtension)
+	if !ok || len(c.config.nextProtos()) == 0 {
+		return "", nil
+	}
+	for _, pref := range c.config.nextProtos() {
+		if containsString(alpne.Protocols, pref) {
+			return pref, nil
+		}
+	}
+	return "", &alertError{tlstypes.NoApplicationProtocol, errors.New("client offers no application protocol of the server")}
+}
+
+// selectCertificate picks the certificate for the server name sent by the client. Config.GetCertificate is asked first,
+// then the first of Config.Certificates which is valid for the name is taken. Otherwise the default certificate of the
+// server is used.
+func (c *serverHandshake) selectCertificate(clientHelloMsg *tlstypes.ClientHelloMsg, exts []extensions.Extension,
+	serverName string) (*tls.Certificate, error) {
+
+	if c.config != nil && c.config.GetCertificate != nil {
+		certificate, err := c.config.GetCertificate(newClientHelloInfo(clientHelloMsg, exts, serverName))
+		if err != nil {
+			return nil, &alertError{tlstypes.UnrecognizedName, err}
+		}
+		if certificate != nil {
+			return certificate, nil
+		}
+	}
+	if serverName != "" && c.config != nil && len(c.config.Certificates) > 1 {
+		for i := range c.config.Certificates {
+			certificate := &c.config.Certificates[i]
+			leaf := certificate.Leaf
+			if leaf == nil && len(certificate.Certificate) > 0 {
+				leaf, _ = x509.ParseCertificate(certificate.Certificate[0])
+			}
+			if leaf != nil && leaf.VerifyHostname(serverName) == nil {
+				return certificate, nil
+			}
+		}
+	}
+	return c.certificate, nil
+}
+
+func newClientHelloInfo(clientHelloMsg *tlstypes.ClientHelloMsg, exts []extensions.Extension,
+	serverName string) *ClientHelloInfo {
+
+	info := &ClientHelloInfo{ServerName: serverName, Extensions: exts}
+	for _, cs := range clientHelloMsg.CipherSuite {
+		info.CipherSuites = append(info.CipherSuites, uint16(cs))
+	}
+	sae, ok := extensions.FindExtension(exts, extensions.SignatureAlgorithmsType).(*extensions.SignatureAlgorithmsExtension)
+	if ok {
+		info.SignatureSchemes = sae.Schemes
+	}
+	if alpne, ok := extensions.FindExtension(exts, extensions.ALPNType).(*extensions.ALPNExtension); ok {
+		info.SupportedProtos = alpne.Protocols
+	}
+	return info
+}
+
+// selectSignatureScheme picks the most preferred signature scheme of the client which the key of the server certificate
+// can sign with.
+func (c *serverHandshake) selectSignatureScheme(exts []extensions.Extension,
+	certificate *tls.Certificate) (tls.SignatureScheme, error) {
+
+	sae, ok := extensions.FindExtension(exts, extensions.SignatureAlgorithmsType).(*extensions.SignatureAlgorithmsExtension)
+	if !ok {
+		return 0, &alertError{tlstypes.MissingExtension, errors.New("client hello has no signature algorithms")}
+	}
+	signer, ok := certificate.PrivateKey.(crypto.Signer)
+	if !ok {
+		return 0, suite.UnsupportedSignerErr
+	}
+	scheme, err := suite.SelectSignatureScheme(signer.Public(), sae.Schemes)
 	if err != nil {
+		return 0, &alertError{tlstypes.HandshakeFailure, err}
+	}
+	return scheme, nil
+}
+
+// selectKeyShare picks the most preferred group of the server for which the client sent a key share. If there is no
+// such share, but the client supports one of the server's groups, the group is returned without a share and the client
+// has to be asked for it with a HelloRetryRequest.
+func (c *serverHandshake) selectKeyShare(exts []extensions.Extension) (ecdh.Group, *extensions.KeyShareEntry, error) {
+	kse, ok := extensions.FindExtension(exts, extensions.KeyShareType).(*extensions.KeyShareExtension)
+	if !ok {
+		return nil, nil, &alertError{tlstypes.MissingExtension, errors.New("client hello has no key share")}
+	}
+	sge, ok := extensions.FindExtension(exts, extensions.SupportedGroupsType).(*extensions.SupportedGroupsExtension)
+	if !ok {
+		return nil, nil, &alertError{tlstypes.MissingExtension, errors.New("client hello has no supported groups")}
+	}
+
+	// RFC 8446, Section 4.2.8: every key share must be for a distinct group listed in supported_groups.
+	for i, entry := range kse.Entries {
+		if kse.FindEntry(entry.CurveID) != &kse.Entries[i] {
+			return nil, nil, &alertError{tlstypes.IllegalParameter, errors.New("client sent duplicate key shares")}
+		}
+		if !containsCurve(sge.Groups, entry.CurveID) {
+			err := fmt.Errorf("client key share group %d is not in supported groups", entry.CurveID)
+			return nil, nil, &alertError{tlstypes.IllegalParameter, err}
+		}
+	}
+
+	for _, pref := range c.config.curvePreferences() {
+		entry := kse.FindEntry(pref)
+		if entry == nil {
+			continue
+		}
+		group, err := ecdh.GroupByID(pref)
+		if err != nil {
+			return nil, nil, err
+		}
+		return group, entry, nil
+	}
+	for _, pref := range c.config.curvePreferences() {
+		if !containsCurve(sge.Groups, pref) {
+			continue
+		}
+		group, err := ecdh.GroupByID(pref)
+		if err != nil {
+			return nil, nil, err
+		}
+		return group, nil, nil
+	}
+	return nil, nil, errors.New("client supports no key exchange group of the server")
+}
+
+// writeHelloRetryRequestMsg asks the client for a key share of the selected group.
+func (c *serverHandshake) writeHelloRetryRequestMsg() error {
+	cookie := make([]byte, helloRetryCookieLen)
+	if _, err := crand.Read(cookie); err != nil {
+		return err
+	}
+	helloRetryRequestMsg := tlstypes.MakeHelloRetryRequestMessage(c.cipherSuite.ID, c.clientHello.SessionID,
+		&tlstypes.HelloRetryRequestExtParams{
+			SelectedGroup: c.group.CurveID(),
+			Cookie:        cookie,
+		})
+	raw := helloRetryRequestMsg.ToBinary()
+	if err := c.records.writeRecord(tlstypes.HandshakeRecord, raw); err != nil {
+		return err
+	}
+	suite.ReplaceWithMessageHash(c.transcript)
+	if _, err := c.transcript.Write(raw); err != nil {
 		return err
 	}
 
+	// save state
+	c.cookie = cookie
+
 	return nil
 }
 
 func (c *serverHandshake) writeServerHelloMsg(cfg *tlstypes.ServerHelloExtParams) error {
-	serverHelloMsg := tlstypes.MakeServerHelloMessage(cfg)
-	r := tlstypes.MakeServerHelloRecord(serverHelloMsg)
-	rBytes := r.ToBinary()
-	if err := streams.WriteAllBytes(c.rawConn, rBytes); err != nil {
+	serverHelloMsg := tlstypes.MakeServerHelloMessage(c.cipherSuite.ID, c.clientHello.SessionID, cfg)
+	raw := serverHelloMsg.ToBinary()
+	if err := c.records.writeRecord(tlstypes.HandshakeRecord, raw); err != nil {
+		return err
+	}
+	if _, err := c.transcript.Write(raw); err != nil {
 		return err
 	}
 
@@ -135,42 +614,285 @@ func (c *serverHandshake) writeServerHelloMsg(cfg *tlstypes.ServerHelloExtParams
 	return nil
 }
 
-func (c *serverHandshake) genServerKey(cfg *tlstypes.ServerHelloExtParams) error {
-	common.AssertImpl(cfg != nil)
-	priv, pub, err := ecdh.GenerateKey(ecdh.DefaultCurve, crand.Reader)
+func (c *serverHandshake) writeEncryptedExtensionsMsg() error {
+	cfg := &tlstypes.EncryptedExtensionsExtParams{
+		ServerName:   c.serverName != "",
+		EarlyData:    c.earlyDataAccepted,
+		ALPNProtocol: c.alpnProtocol,
+		Extensions:   c.replyExtensions,
+	}
+	encryptedExtensionsMsg := tlstypes.MakeEncryptedExtensionsMessage(cfg)
+	return c.writeHandshakeMsg(encryptedExtensionsMsg.ToBinary())
+}
+
+// writeCertificateFlight authenticates the server with its certificate and asks for a client certificate if
+// Config.ClientAuth requires it.
+func (c *serverHandshake) writeCertificateFlight() error {
+	if c.config.clientAuth() != tls.NoClientCert {
+		if err := c.writeCertificateRequestMsg(); err != nil {
+			return err
+		}
+	}
+	if err := c.writeCertificateMsg(); err != nil {
+		return err
+	}
+	return c.writeCertificateVerifyMsg()
+}
+
+// writeCertificateRequestMsg asks the client for a certificate. The request context is empty, since it's only used for
+// post-handshake authentication.
+func (c *serverHandshake) writeCertificateRequestMsg() error {
+	certificateRequestMsg := tlstypes.MakeCertificateRequestMessage([]byte{}, suite.SupportedSignatureSchemes)
+	return c.writeHandshakeMsg(certificateRequestMsg.ToBinary())
+}
+
+func (c *serverHandshake) writeCertificateMsg() error {
+	certificateMsg := tlstypes.MakeCertificateMessage(c.certificate.Certificate)
+	return c.writeHandshakeMsg(certificateMsg.ToBinary())
+}
+
+func (c *serverHandshake) writeCertificateVerifyMsg() error {
+	signed := suite.SignedMessage(suite.ServerSignatureContext, c.transcript)
+	signature, err := suite.Sign(c.signatureScheme, c.certificate.PrivateKey, signed)
 	if err != nil {
 		return err
 	}
-	pubBytes := ecdh.MarshalPubKey(pub)
-	cfg.KeyShareExtParams = &tlstypes.KeyShareExtParams{
-		CurveID: ecdh.DefaultCurveID,
-		PubKey:  pubBytes,
+	certificateVerifyMsg := tlstypes.MakeCertificateVerifyMessage(c.signatureScheme, signature)
+	return c.writeHandshakeMsg(certificateVerifyMsg.ToBinary())
+}
+
+func (c *serverHandshake) writeFinishedMsg() error {
+	verifyData := c.cipherSuite.FinishedVerifyData(c.serverHandshakeTrafficSecret, c.transcript)
+	finishedMsg := tlstypes.MakeFinishedMessage(verifyData)
+	return c.writeHandshakeMsg(finishedMsg.ToBinary())
+}
+
+// readClientCertificateMsg reads the certificate chain of the client and checks it as required by Config.ClientAuth.
+func (c *serverHandshake) readClientCertificateMsg() error {
+	data, err := c.records.readHandshakeMsg()
+	if err != nil {
+		return err
+	}
+	certificateMsg, err := tlstypes.ParseCertificateMsg(data)
+	if err != nil {
+		return &alertError{tlstypes.DecodeError, err}
+	}
+	if certificateMsg.RequestContextLen != 0 {
+		return &alertError{tlstypes.IllegalParameter, errors.New("client certificate has invalid request context")}
+	}
+	if err := checkCertificateExtensions(certificateMsg, nil); err != nil {
+		return err
+	}
+
+	certificates := make([]*x509.Certificate, 0, len(certificateMsg.CertificateList))
+	for i := 0; i < len(certificateMsg.CertificateList); i++ {
+		cert, err := x509.ParseCertificate(certificateMsg.CertificateList[i].CertData)
+		if err != nil {
+			return err
+		}
+		certificates = append(certificates, cert)
+	}
+	if err := c.verifyClientCertificate(certificates); err != nil {
+		return err
 	}
 
 	// save state:
-	c.serverPrivateKey = priv
+	c.peerCertificates = certificates
+
+	_, err = c.transcript.Write(data)
+	return err
+}
+
+// verifyClientCertificate applies the client authentication mode to the certificate chain of the client.
+func (c *serverHandshake) verifyClientCertificate(certificates []*x509.Certificate) error {
+	clientAuth := c.config.clientAuth()
+	if len(certificates) == 0 {
+		if clientAuth == tls.RequireAnyClientCert || clientAuth == tls.RequireAndVerifyClientCert {
+			// RFC 8446, Section 4.4.2.4: a missing client certificate is reported with certificate_required.
+			return &alertError{tlstypes.CertificateRequired, errors.New("client sent no certificate")}
+		}
+		return nil
+	}
+	if clientAuth != tls.VerifyClientCertIfGiven && clientAuth != tls.RequireAndVerifyClientCert {
+		return nil
+	}
 
+	opts := x509.VerifyOptions{
+		Roots:         c.config.clientCAs(),
+		Intermediates: x509.NewCertPool(),
+		CurrentTime:   c.config.now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, cert := range certificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := certificates[0].Verify(opts); err != nil {
+		return &alertError{certificateAlert(err), err}
+	}
 	return nil
 }
 
-func (c *serverHandshake) calculateHandshakeHash() (h hash.Hash, err error) {
-	h = sha256.New()
-	_, err = h.Write(c.clientHello.ToBinary())
+// readClientCertificateVerifyMsg checks that the client has the private key of its certificate. There is no
+// CertificateVerify message when the client sent no certificate.
+func (c *serverHandshake) readClientCertificateVerifyMsg() error {
+	if len(c.peerCertificates) == 0 {
+		return nil
+	}
+	data, err := c.records.readHandshakeMsg()
+	if err != nil {
+		return err
+	}
+	certificateVerifyMsg, err := tlstypes.ParseCertificateVerifyMsg(data)
 	if err != nil {
-		return nil, err
+		return &alertError{tlstypes.DecodeError, err}
+	}
+	scheme, pub := certificateVerifyMsg.SignatureScheme, c.peerCertificates[0].PublicKey
+	if err := checkPeerSignatureScheme(scheme, pub); err != nil {
+		return err
 	}
-	_, err = h.Write(c.serverHello.ToBinary())
+
+	signed := suite.SignedMessage(suite.ClientSignatureContext, c.transcript)
+	err = suite.Verify(scheme, pub, signed, certificateVerifyMsg.Signature)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return h, nil
+
+	_, err = c.transcript.Write(data)
+	return err
 }
 
-func (c *serverHandshake) sendFatalAlert() {
-	a := &tlstypes.Alert{
-		Level:       tlstypes.FatalAlertLevel,
-		Description: tlstypes.HandshakeFailure,
+// readEarlyData reads the application data the client sent with its first flight, up to the EndOfEarlyData message
+// which ends it.
+func (c *serverHandshake) readEarlyData() error {
+	var earlyData []byte
+	for c.records.hsBuf.Len() == 0 {
+		data, err := c.records.readApplicationData()
+		if err != nil {
+			return err
+		}
+		if uint64(len(earlyData))+uint64(len(data)) > uint64(c.maxEarlyData) {
+			return &alertError{tlstypes.UnexpectedMessage, errors.New("client sent too much early data")}
+		}
+		earlyData = append(earlyData, data...)
 	}
-	r := tlstypes.MakeAlertRecord(a)
-	_, _ = r.WriteTo(c.rawConn)
+
+	data, err := c.records.readHandshakeMsg()
+	if err != nil {
+		return err
+	}
+	if tlstypes.HandshakeMsgType(data[0]) != tlstypes.EndOfEarlyDataMsgType {
+		err = fmt.Errorf("received handshake message %d instead of end of early data", data[0])
+		return &alertError{tlstypes.UnexpectedMessage, err}
+	}
+	if _, err := tlstypes.ParseEndOfEarlyDataMsg(data); err != nil {
+		return &alertError{tlstypes.DecodeError, err}
+	}
+
+	// save state:
+	c.earlyData = earlyData
+
+	_, err = c.transcript.Write(data)
+	return err
+}
+
+func (c *serverHandshake) readClientFinishedMsg() error {
+	data, err := c.records.readHandshakeMsg()
+	if err != nil {
+		return err
+	}
+	finishedMsg, err := tlstypes.ParseFinishedMsg(data)
+	if err != nil {
+		return &alertError{tlstypes.DecodeError, err}
+	}
+
+	expected := c.cipherSuite.FinishedVerifyData(c.clientHandshakeTrafficSecret, c.transcript)
+	if !hmac.Equal(expected, finishedMsg.VerifyData) {
+		return errors.New("invalid client finished verify data")
+	}
+
+	_, err = c.transcript.Write(data)
+	return err
+}
+
+// makeNewSessionTicketMsg creates a ticket for resuming the session from the resumption master secret, which covers the
+// transcript up to the client Finished. The server sends a single ticket per connection, so the ticket nonce is
+// constant.
+func (c *serverHandshake) makeNewSessionTicketMsg() error {
+	resumptionSecret := c.cipherSuite.DeriveSecret(c.masterSecret, suite.ResumptionLabel, c.transcript)
+	nonce := []byte{0}
+	var ageAdd [4]byte
+	if _, err := crand.Read(ageAdd[:]); err != nil {
+		return err
+	}
+	session := &serverSessionState{
+		cipherSuite:  c.cipherSuite.ID,
+		createdAt:    uint64(c.config.now().Unix()),
+		ageAdd:       binary.BigEndian.Uint32(ageAdd[:]),
+		secret:       c.cipherSuite.ResumptionPSK(resumptionSecret, nonce),
+		maxEarlyData: c.config.maxEarlyData(),
+		alpnProtocol: c.alpnProtocol,
+		serverName:   c.serverName,
+	}
+	for _, cert := range c.peerCertificates {
+		session.certificates = append(session.certificates, cert.Raw)
+	}
+	ticket, err := sealTicket(c.ticketKey, session)
+	if err != nil {
+		return err
+	}
+	lifetime := uint32(sessionTicketLifetime / time.Second)
+	newSessionTicketMsg := tlstypes.MakeNewSessionTicketMessage(lifetime, session.ageAdd, nonce, ticket,
+		session.maxEarlyData)
+
+	// save state:
+	c.sessionTicket = newSessionTicketMsg.ToBinary()
+
+	return nil
+}
+
+// writeHandshakeMsg sends a handshake message and adds it to the transcript.
+func (c *serverHandshake) writeHandshakeMsg(raw []byte) error {
+	if err := c.records.writeRecord(tlstypes.HandshakeRecord, raw); err != nil {
+		return err
+	}
+	_, err := c.transcript.Write(raw)
+	return err
+}
+
+// deriveApplicationSecrets computes the application traffic secrets and the exporter master secret from the transcript
+// up to the server Finished, as defined in RFC 8446, Section 7.1.
+func (c *serverHandshake) deriveApplicationSecrets() {
+	derivedSecret := c.cipherSuite.DeriveSecret(c.handshakeSecret, suite.DerivedLabel, nil)
+	masterSecret := c.cipherSuite.Extract(nil, derivedSecret)
+	clientApplicationTrafficSecret := c.cipherSuite.DeriveSecret(masterSecret, suite.ClientApplicationTrafficLabel, c.transcript)
+	serverApplicationTrafficSecret := c.cipherSuite.DeriveSecret(masterSecret, suite.ServerApplicationTrafficLabel, c.transcript)
+	exporterMasterSecret := c.cipherSuite.DeriveSecret(masterSecret, suite.ExporterLabel, c.transcript)
+
+	// save state:
+	c.masterSecret = masterSecret
+	c.clientApplicationTrafficSecret = clientApplicationTrafficSecret
+	c.serverApplicationTrafficSecret = serverApplicationTrafficSecret
+	c.exporterMasterSecret = exporterMasterSecret
+}
+
+func (c *serverHandshake) genServerKey(cfg *tlstypes.ServerHelloExtParams) error {
+	common.AssertImpl(cfg != nil)
+	common.AssertImpl(c.group != nil)
+	priv, err := c.group.GenerateKey(crand.Reader)
+	if err != nil {
+		return err
+	}
+	cfg.KeyShareExtParams = &tlstypes.KeyShareExtParams{
+		CurveID: c.group.CurveID(),
+		PubKey:  c.group.MarshalPubKey(priv),
+	}
+
+	// save state:
+	c.serverPrivateKey = priv
+
+	return nil
+}
+
+func (c *serverHandshake) sendFatalAlert(desc tlstypes.AlertDescription) {
+	c.records.sendAlert(desc)
 }
@@ -0,0 +1,346 @@
+package internal
+
+import (
+	"container/list"
+	"crypto/aes"
+	"crypto/cipher"
+	crand "crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/tls-handshake/internal/suite"
+	tlstypes "github.com/tls-handshake/internal/tls_types"
+	"github.com/tls-handshake/internal/tls_types/extensions"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+const (
+	// sessionTicketLifetime is the maximum lifetime of a ticket allowed by RFC 8446, Section 4.6.1.
+	sessionTicketLifetime = time.Hour * 24 * 7
+	ticketKeyLen          = 32
+	ticketNonceLen        = 12
+	// earlyDataReplayWindow is how much the ticket age reported by a client may differ from the real age of the ticket
+	// for its early data to be accepted, as described in RFC 8446, Section 8.3.
+	earlyDataReplayWindow = 10 * time.Second
+)
+
+var invalidTicketErr = errors.New("invalid session ticket")
+
+// ClientSessionState holds what a client needs to resume a session: the ticket received from the server and the PSK
+// derived for it.
+type ClientSessionState struct {
+	ticket           []byte
+	ageAdd           uint32
+	secret           []byte // resumption PSK
+	cipherSuite      tlstypes.CipherSuite
+	serverName       string
+	receivedAt       time.Time
+	lifetime         time.Duration
+	maxEarlyData     uint32              // zero if the server doesn't accept early data with the ticket
+	alpnProtocol     string              // the early data must be sent for the application protocol of the session
+	peerCertificates []*x509.Certificate // sent in the full handshake which issued the ticket
+	verified         bool                // the peerCertificates were verified, not accepted with InsecureSkipVerify
+}
+
+func (cs *ClientSessionState) expired(now time.Time) bool {
+	return now.Sub(cs.receivedAt) >= cs.lifetime
+}
+
+// ticketReceiver turns the session tickets a client receives after the handshake into resumable sessions.
+type ticketReceiver struct {
+	cache            ClientSessionCache
+	sessionKey       string
+	serverName       string
+	cipherSuite      *suite.CipherSuite
+	resumptionSecret []byte
+	alpnProtocol     string
+	peerCertificates []*x509.Certificate
+	verified         bool
+	now              func() time.Time
+}
+
+func (r *ticketReceiver) handleNewSessionTicket(data []byte) error {
+	newSessionTicketMsg, err := tlstypes.ParseNewSessionTicketMsg(data)
+	if err != nil {
+		return &alertError{tlstypes.DecodeError, err}
+	}
+	lifetime := time.Duration(newSessionTicketMsg.TicketLifetime) * time.Second
+	if lifetime > sessionTicketLifetime {
+		return &alertError{tlstypes.IllegalParameter, errors.New("session ticket lifetime exceeds seven days")}
+	}
+	if lifetime == 0 {
+		return nil // RFC 8446, Section 4.6.1: the ticket must be discarded immediately
+	}
+	exts, err := extensions.ParseExtensions(newSessionTicketMsg.ExtensionData, newSessionTicketMsg.ExtensionsLen,
+		extensions.NewSessionTicketMsgContext)
+	if err != nil {
+		return extensionsAlertError(err)
+	}
+	var maxEarlyData uint32
+	if ede, ok := extensions.FindExtension(exts, extensions.EarlyDataType).(*extensions.EarlyDataExtension); ok {
+		maxEarlyData = ede.MaxEarlyDataSize
+	}
+
+	r.cache.Put(r.sessionKey, &ClientSessionState{
+		ticket:           newSessionTicketMsg.Ticket,
+		ageAdd:           newSessionTicketMsg.TicketAgeAdd,
+		secret:           r.cipherSuite.ResumptionPSK(r.resumptionSecret, newSessionTicketMsg.TicketNonce),
+		cipherSuite:      r.cipherSuite.ID,
+		serverName:       r.serverName,
+		receivedAt:       r.now(),
+		lifetime:         lifetime,
+		maxEarlyData:     maxEarlyData,
+		alpnProtocol:     r.alpnProtocol,
+		peerCertificates: r.peerCertificates,
+		verified:         r.verified,
+	})
+	return nil
+}
+
+// ClientSessionCache stores sessions for resumption, the client uses the server address as key. Implementations must
+// be safe for concurrent use.
+type ClientSessionCache interface {
+	// Get returns the session stored for sessionKey.
+	Get(sessionKey string) (session *ClientSessionState, ok bool)
+	// Put stores session for sessionKey, a nil session removes the entry.
+	Put(sessionKey string, session *ClientSessionState)
+}
+
+// NewLRUClientSessionCache returns a ClientSessionCache which holds at most capacity sessions and evicts the least
+// recently used one when it's full. A capacity < 1 uses a default of 64.
+func NewLRUClientSessionCache(capacity int) ClientSessionCache {
+	const defaultCapacity = 64
+	if capacity < 1 {
+		capacity = defaultCapacity
+	}
+	return &lruSessionCache{
+		m:        make(map[string]*list.Element),
+		q:        list.New(),
+		capacity: capacity,
+	}
+}
+
+type lruSessionCacheEntry struct {
+	sessionKey string
+	session    *ClientSessionState
+}
+
+type lruSessionCache struct {
+	mux      sync.Mutex
+	m        map[string]*list.Element
+	q        *list.List // front is the most recently used entry
+	capacity int
+}
+
+func (c *lruSessionCache) Get(sessionKey string) (*ClientSessionState, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if elem, ok := c.m[sessionKey]; ok {
+		c.q.MoveToFront(elem)
+		return elem.Value.(*lruSessionCacheEntry).session, true
+	}
+	return nil, false
+}
+
+func (c *lruSessionCache) Put(sessionKey string, session *ClientSessionState) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if elem, ok := c.m[sessionKey]; ok {
+		if session == nil {
+			c.q.Remove(elem)
+			delete(c.m, sessionKey)
+		} else {
+			elem.Value.(*lruSessionCacheEntry).session = session
+			c.q.MoveToFront(elem)
+		}
+		return
+	}
+	if session == nil {
+		return
+	}
+	if c.q.Len() >= c.capacity {
+		oldest := c.q.Back()
+		c.q.Remove(oldest)
+		delete(c.m, oldest.Value.(*lruSessionCacheEntry).sessionKey)
+	}
+	c.m[sessionKey] = c.q.PushFront(&lruSessionCacheEntry{sessionKey, session})
+}
+
+// serverSessionState is the content of a session ticket. The server keeps no state, the ticket is encrypted and
+// authenticated with the ticket key instead.
+type serverSessionState struct {
+	cipherSuite  tlstypes.CipherSuite
+	createdAt    uint64 // unix time in seconds
+	ageAdd       uint32
+	secret       []byte // resumption PSK
+	maxEarlyData uint32
+	alpnProtocol string
+	serverName   string // the ticket is only accepted for the server name of the full handshake
+	certificates [][]byte
+}
+
+func (s *serverSessionState) marshal() []byte {
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(s.cipherSuite))
+	var createdAt [8]byte
+	binary.BigEndian.PutUint64(createdAt[:], s.createdAt)
+	b.AddBytes(createdAt[:])
+	b.AddUint32(s.ageAdd)
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(s.secret)
+	})
+	b.AddUint32(s.maxEarlyData)
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes([]byte(s.alpnProtocol))
+	})
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes([]byte(s.serverName))
+	})
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, cert := range s.certificates {
+			b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddBytes(cert)
+			})
+		}
+	})
+	return b.BytesOrPanic()
+}
+
+func unmarshalServerSessionState(data []byte) (*serverSessionState, error) {
+	var (
+		s            = &serverSessionState{}
+		cipherSuite  uint16
+		createdAt    []byte
+		alpnProtocol []byte
+		serverName   []byte
+		certificates cryptobyte.String
+	)
+	str := cryptobyte.String(data)
+	if !str.ReadUint16(&cipherSuite) ||
+		!str.ReadBytes(&createdAt, 8) ||
+		!str.ReadUint32(&s.ageAdd) ||
+		!str.ReadUint8LengthPrefixed((*cryptobyte.String)(&s.secret)) ||
+		!str.ReadUint32(&s.maxEarlyData) ||
+		!str.ReadUint8LengthPrefixed((*cryptobyte.String)(&alpnProtocol)) ||
+		!str.ReadUint16LengthPrefixed((*cryptobyte.String)(&serverName)) ||
+		!str.ReadUint24LengthPrefixed(&certificates) ||
+		!str.Empty() {
+		return nil, invalidTicketErr
+	}
+	s.cipherSuite = tlstypes.CipherSuite(cipherSuite)
+	s.createdAt = binary.BigEndian.Uint64(createdAt)
+	s.alpnProtocol = string(alpnProtocol)
+	s.serverName = string(serverName)
+	for !certificates.Empty() {
+		var cert []byte
+		if !certificates.ReadUint24LengthPrefixed((*cryptobyte.String)(&cert)) {
+			return nil, invalidTicketErr
+		}
+		s.certificates = append(s.certificates, cert)
+	}
+	return s, nil
+}
+
+// isFreshTicketAge reports whether the ticket age a client reports, obfuscated with the ticket_age_add of the ticket,
+// is within earlyDataReplayWindow of the real age of the ticket. Early data in an older ClientHello is rejected, so a
+// replay filter only needs to remember recent ClientHellos.
+func isFreshTicketAge(session *serverSessionState, obfuscatedAge uint32, now time.Time) bool {
+	clientAge := time.Duration(obfuscatedAge-session.ageAdd) * time.Millisecond
+	serverAge := now.Sub(time.Unix(int64(session.createdAt), 0))
+	diff := clientAge - serverAge
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= earlyDataReplayWindow
+}
+
+// replayFilter records the ClientHellos whose early data a server accepted, so that the early data of a replayed
+// ClientHello is rejected, as described in RFC 8446, Section 8.2. A ClientHello is identified by its PSK binder. It's
+// safe for concurrent use.
+type replayFilter struct {
+	mux   sync.Mutex
+	seen  map[string]struct{}
+	queue []replayEntry // the binders of seen, oldest first
+}
+
+// replayEntry is a binder of a replayFilter and the time when it can be forgotten.
+type replayEntry struct {
+	binder   string
+	forgetAt time.Time
+}
+
+func newReplayFilter() *replayFilter {
+	return &replayFilter{seen: make(map[string]struct{})}
+}
+
+// firstUse records binder and reports whether it was not recorded before. A replayed ClientHello passes the ticket
+// age check for up to two replay windows after the original one, so it's remembered for that long. The binders are
+// forgotten from the front of the queue, so a call only visits the binders it forgets.
+func (f *replayFilter) firstUse(binder []byte, now time.Time) bool {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	for len(f.queue) > 0 && !now.Before(f.queue[0].forgetAt) {
+		delete(f.seen, f.queue[0].binder)
+		f.queue[0] = replayEntry{}
+		f.queue = f.queue[1:]
+	}
+	if _, ok := f.seen[string(binder)]; ok {
+		return false
+	}
+	f.seen[string(binder)] = struct{}{}
+	f.queue = append(f.queue, replayEntry{string(binder), now.Add(2 * earlyDataReplayWindow)})
+	return true
+}
+
+// sealTicket encrypts the session state with AES-256-GCM, the ticket is the random nonce followed by the ciphertext.
+func sealTicket(key []byte, state *serverSessionState) ([]byte, error) {
+	aead, err := newTicketAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, ticketNonceLen)
+	if _, err := crand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, state.marshal(), nil), nil
+}
+
+// openTicket decrypts a ticket sealed with the same key, it fails for tickets of other servers and tampered tickets.
+func openTicket(key, ticket []byte) (*serverSessionState, error) {
+	aead, err := newTicketAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ticket) < ticketNonceLen+aead.Overhead() {
+		return nil, invalidTicketErr
+	}
+	plaintext, err := aead.Open(nil, ticket[:ticketNonceLen], ticket[ticketNonceLen:], nil)
+	if err != nil {
+		return nil, invalidTicketErr
+	}
+	return unmarshalServerSessionState(plaintext)
+}
+
+func newTicketAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// parseCertificates parses the DER encoded certificates of a session.
+func parseCertificates(certificates [][]byte) ([]*x509.Certificate, error) {
+	ret := make([]*x509.Certificate, 0, len(certificates))
+	for _, der := range certificates {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, cert)
+	}
+	return ret, nil
+}
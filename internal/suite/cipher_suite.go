@@ -0,0 +1,82 @@
+package suite
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	_ "crypto/sha256" // registers crypto.SHA256
+	_ "crypto/sha512" // registers crypto.SHA384
+	"errors"
+
+	tlstypes "github.com/tls-handshake/internal/tls_types"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/sys/cpu"
+)
+
+const IVLen = 12 // all TLS 1.3 AEADs use 96 bit nonces
+
+var UnsupportedCipherSuiteErr = errors.New("unsupported cipher suite")
+
+// CipherSuite holds the AEAD and the hash of a TLS 1.3 cipher suite, as defined in RFC 8446, Appendix B.4. The hash
+// is used for the transcript and in HKDF.
+type CipherSuite struct {
+	ID     tlstypes.CipherSuite
+	KeyLen int
+	Hash   crypto.Hash
+	aead   func(key []byte) (cipher.AEAD, error)
+}
+
+var cipherSuites = []*CipherSuite{
+	{ID: tlstypes.TLS_AES_128_GCM_SHA256, KeyLen: 16, Hash: crypto.SHA256, aead: newAESGCM},
+	{ID: tlstypes.TLS_AES_256_GCM_SHA384, KeyLen: 32, Hash: crypto.SHA384, aead: newAESGCM},
+	{ID: tlstypes.TLS_CHACHA20_POLY1305_SHA256, KeyLen: chacha20poly1305.KeySize, Hash: crypto.SHA256, aead: chacha20poly1305.New},
+}
+
+// DefaultCipherSuites are the supported cipher suites in order of preference. AES-GCM is preferred only when the CPU
+// has AES instructions, otherwise ChaCha20-Poly1305 is both faster and safe from timing side channels.
+var DefaultCipherSuites = defaultCipherSuites(hasAESGCMHardwareSupport)
+
+var hasAESGCMHardwareSupport = (cpu.X86.HasAES && cpu.X86.HasPCLMULQDQ) ||
+	(cpu.ARM64.HasAES && cpu.ARM64.HasPMULL) ||
+	(cpu.S390X.HasAES && cpu.S390X.HasAESGCM)
+
+func defaultCipherSuites(preferAES bool) []tlstypes.CipherSuite {
+	if preferAES {
+		return []tlstypes.CipherSuite{
+			tlstypes.TLS_AES_128_GCM_SHA256,
+			tlstypes.TLS_AES_256_GCM_SHA384,
+			tlstypes.TLS_CHACHA20_POLY1305_SHA256,
+		}
+	}
+	return []tlstypes.CipherSuite{
+		tlstypes.TLS_CHACHA20_POLY1305_SHA256,
+		tlstypes.TLS_AES_128_GCM_SHA256,
+		tlstypes.TLS_AES_256_GCM_SHA384,
+	}
+}
+
+// CipherSuiteByID returns the implementation of a supported cipher suite.
+func CipherSuiteByID(id tlstypes.CipherSuite) (*CipherSuite, error) {
+	for _, cs := range cipherSuites {
+		if cs.ID == id {
+			return cs, nil
+		}
+	}
+	return nil, UnsupportedCipherSuiteErr
+}
+
+// AEAD creates the record protection cipher for a traffic key.
+func (cs *CipherSuite) AEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != cs.KeyLen {
+		return nil, errors.New("invalid traffic key length")
+	}
+	return cs.aead(key)
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	a, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(a)
+}
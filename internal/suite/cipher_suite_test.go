@@ -0,0 +1,96 @@
+package suite
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	tlstypes "github.com/tls-handshake/internal/tls_types"
+)
+
+func TestKeySchedule(t *testing.T) {
+	// Values from RFC 8448, Section 3: the early secret without a PSK and the derived secret.
+	cs, err := CipherSuiteByID(tlstypes.TLS_AES_128_GCM_SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	earlySecret := cs.Extract(nil, nil)
+	if hex.EncodeToString(earlySecret) != "33ad0a1c607ec03b09e6cd9893680ce210adf300aa1f2660e1b22e10f170f92a" {
+		t.Fatalf("Extract is broken: %x", earlySecret)
+	}
+	derivedSecret := cs.DeriveSecret(earlySecret, DerivedLabel, nil)
+	if hex.EncodeToString(derivedSecret) != "6f2615a108c702c5678f54fc9dbab69716c076189c48250cebeac3576c3611ba" {
+		t.Fatalf("DeriveSecret is broken: %x", derivedSecret)
+	}
+
+	cs, err = CipherSuiteByID(tlstypes.TLS_AES_256_GCM_SHA384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs.Extract(nil, nil)) != 48 || len(cs.DeriveSecret(earlySecret, DerivedLabel, nil)) != 48 {
+		t.Fatalf("SHA-384 secrets have invalid length")
+	}
+
+	if _, err := CipherSuiteByID(0x1304); err != UnsupportedCipherSuiteErr {
+		t.Fatalf("CipherSuiteByID accepts an unsupported cipher suite")
+	}
+}
+
+func TestAEAD(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x42}, 48)
+	for _, id := range defaultCipherSuites(true) {
+		cs, err := CipherSuiteByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, iv := cs.TrafficKey(secret[:cs.Hash.Size()])
+		if len(key) != cs.KeyLen || len(iv) != IVLen {
+			t.Fatalf("TrafficKey is broken for %#04x", id)
+		}
+		aead, err := cs.AEAD(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ciphertext, err := Encrypt(aead, []byte("PING"), iv, []byte{0x17, 0x03, 0x03})
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, err := Decrypt(aead, ciphertext, iv, []byte{0x17, 0x03, 0x03})
+		if err != nil || string(plain) != "PING" {
+			t.Fatalf("Decrypt is broken for %#04x", id)
+		}
+		if _, err := Decrypt(aead, ciphertext, iv, []byte{0x17, 0x03, 0x01}); err == nil {
+			t.Fatalf("Decrypt ignores the additional data for %#04x", id)
+		}
+	}
+
+	if cs, _ := CipherSuiteByID(tlstypes.TLS_AES_256_GCM_SHA384); cs != nil {
+		if _, err := cs.AEAD(make([]byte, 16)); err == nil {
+			t.Fatalf("AEAD accepts a key of invalid length")
+		}
+	}
+}
+
+func TestExportKeyingMaterial(t *testing.T) {
+	cs, err := CipherSuiteByID(tlstypes.TLS_AES_128_GCM_SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := bytes.Repeat([]byte{0x42}, cs.Hash.Size())
+	ekm, err := cs.ExportKeyingMaterial(secret, "EXPORTER-test", nil, 40)
+	if err != nil || len(ekm) != 40 {
+		t.Fatalf("ExportKeyingMaterial is broken: %v", err)
+	}
+	if same, _ := cs.ExportKeyingMaterial(secret, "EXPORTER-test", []byte{}, 40); !bytes.Equal(ekm, same) {
+		t.Fatalf("ExportKeyingMaterial distinguishes a nil and an empty context")
+	}
+	if other, _ := cs.ExportKeyingMaterial(secret, "EXPORTER-test", []byte("context"), 40); bytes.Equal(ekm, other) {
+		t.Fatalf("ExportKeyingMaterial ignores the context")
+	}
+	if other, _ := cs.ExportKeyingMaterial(secret, "EXPORTER-other", nil, 40); bytes.Equal(ekm, other) {
+		t.Fatalf("ExportKeyingMaterial ignores the label")
+	}
+	if _, err := cs.ExportKeyingMaterial(secret, "EXPORTER-test", nil, 255*32+1); err == nil {
+		t.Fatalf("ExportKeyingMaterial accepts a length beyond the HKDF limit")
+	}
+}
@@ -1,22 +1,13 @@
 package suite
 
 import (
-	"crypto/aes"
 	"crypto/cipher"
 
 	"github.com/tls-handshake/pkg/bytes"
 )
 
-func Encrypt(data, key, nonce []byte) (ciphertext []byte, err error) {
-	a, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
-	}
-	aesgcm, err := cipher.NewGCM(a) // 128 bit AES
-	if err != nil {
-		return nil, err
-	}
-	nonce = bytes.PadSlice(nonce, 0x0, aesgcm.NonceSize())
-	ciphertext = aesgcm.Seal(nil, nonce, data, nil)
+func Encrypt(aead cipher.AEAD, data, nonce, additionalData []byte) (ciphertext []byte, err error) {
+	nonce = bytes.PadSlice(nonce, 0x0, aead.NonceSize())
+	ciphertext = aead.Seal(nil, nonce, data, additionalData)
 	return ciphertext, nil
-}
\ No newline at end of file
+}
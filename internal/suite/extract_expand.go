@@ -1,9 +1,13 @@
 package suite
 
 import (
-	"crypto/sha256"
+	"crypto/hmac"
+	"encoding"
+	"errors"
 	"hash"
 
+	"github.com/tls-handshake/internal/common"
+	tlstypes "github.com/tls-handshake/internal/tls_types"
 	"golang.org/x/crypto/cryptobyte"
 	"golang.org/x/crypto/hkdf"
 )
@@ -11,19 +15,25 @@ import (
 // NOTE: taken from the golang core tls library
 
 const (
-	// ResumptionBinderLabel         = "res binder"
+	ResumptionBinderLabel         = "res binder"
+	ClientEarlyTrafficLabel       = "c e traffic"
 	ClientHandshakeTrafficLabel   = "c hs traffic"
 	ServerHandshakeTrafficLabel   = "s hs traffic"
 	ClientApplicationTrafficLabel = "c ap traffic"
 	ServerApplicationTrafficLabel = "s ap traffic"
+	ExporterLabel                 = "exp master"
+	ExporterKeyLabel              = "exporter"
 	KeyLabel                      = "key"
 	IVLabel                       = "iv"
-	// ResumptionLabel               = "res master"
-	// TrafficUpdateLabel            = "traffic upd"
+	FinishedLabel                 = "finished"
+	DerivedLabel                  = "derived"
+	ResumptionLabel               = "res master"
+	ResumptionPSKLabel            = "resumption"
+	TrafficUpdateLabel            = "traffic upd"
 )
 
 // ExpandLabel implements HKDF-Expand-Label from RFC 8446, Section 7.1.
-func ExpandLabel(secret []byte, label string, context []byte, length int) []byte {
+func (cs *CipherSuite) ExpandLabel(secret []byte, label string, context []byte, length int) []byte {
 	var hkdfLabel cryptobyte.Builder
 	hkdfLabel.AddUint16(uint16(length))
 	hkdfLabel.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
@@ -34,7 +44,7 @@ func ExpandLabel(secret []byte, label string, context []byte, length int) []byte
 		b.AddBytes(context)
 	})
 	out := make([]byte, length)
-	n, err := hkdf.Expand(sha256.New, secret, hkdfLabel.BytesOrPanic()).Read(out)
+	n, err := hkdf.Expand(cs.Hash.New, secret, hkdfLabel.BytesOrPanic()).Read(out)
 	if err != nil || n != length {
 		panic("tls: HKDF-Expand-Label invocation failed unexpectedly")
 	}
@@ -42,17 +52,91 @@ func ExpandLabel(secret []byte, label string, context []byte, length int) []byte
 }
 
 // DeriveSecret implements Derive-Secret from RFC 8446, Section 7.1.
-func DeriveSecret(secret []byte, label string, transcript hash.Hash) []byte {
+func (cs *CipherSuite) DeriveSecret(secret []byte, label string, transcript hash.Hash) []byte {
 	if transcript == nil {
-		transcript = sha256.New()
+		transcript = cs.Hash.New()
 	}
-	return ExpandLabel(secret, label, transcript.Sum(nil), sha256.New().Size())
+	return cs.ExpandLabel(secret, label, transcript.Sum(nil), cs.Hash.Size())
 }
 
 // Extract implements HKDF-Extract with the cipher suite hash.
-func Extract(newSecret, currentSecret []byte) []byte {
+func (cs *CipherSuite) Extract(newSecret, currentSecret []byte) []byte {
 	if newSecret == nil {
-		newSecret = make([]byte, sha256.New().Size())
+		newSecret = make([]byte, cs.Hash.Size())
 	}
-	return hkdf.Extract(sha256.New, newSecret, currentSecret)
-}
\ No newline at end of file
+	return hkdf.Extract(cs.Hash.New, newSecret, currentSecret)
+}
+
+// TrafficKey derives the record protection key and iv from a traffic secret as defined in RFC 8446, Section 7.3.
+func (cs *CipherSuite) TrafficKey(trafficSecret []byte) (key, iv []byte) {
+	key = cs.ExpandLabel(trafficSecret, KeyLabel, nil, cs.KeyLen)
+	iv = cs.ExpandLabel(trafficSecret, IVLabel, nil, IVLen)
+	return key, iv
+}
+
+// FinishedVerifyData computes the verify_data of a Finished message as defined in RFC 8446, Section 4.4.4.
+func (cs *CipherSuite) FinishedVerifyData(baseKey []byte, transcript hash.Hash) []byte {
+	finishedKey := cs.ExpandLabel(baseKey, FinishedLabel, nil, cs.Hash.Size())
+	verifyData := hmac.New(cs.Hash.New, finishedKey)
+	verifyData.Write(transcript.Sum(nil))
+	return verifyData.Sum(nil)
+}
+
+// PSKBinder computes the binder of a resumption PSK, the transcript must end with the ClientHello truncated before the
+// binders list, as defined in RFC 8446, Section 4.2.11.2.
+func (cs *CipherSuite) PSKBinder(psk []byte, transcript hash.Hash) []byte {
+	earlySecret := cs.Extract(psk, nil)
+	binderKey := cs.DeriveSecret(earlySecret, ResumptionBinderLabel, nil)
+	return cs.FinishedVerifyData(binderKey, transcript)
+}
+
+// ResumptionPSK derives the PSK of a session ticket from the resumption master secret and the ticket nonce, as defined
+// in RFC 8446, Section 4.6.1.
+func (cs *CipherSuite) ResumptionPSK(resumptionSecret, ticketNonce []byte) []byte {
+	return cs.ExpandLabel(resumptionSecret, ResumptionPSKLabel, ticketNonce, cs.Hash.Size())
+}
+
+// NextTrafficSecret derives the traffic secret which replaces trafficSecret after a KeyUpdate, as defined in RFC 8446,
+// Section 7.2.
+func (cs *CipherSuite) NextTrafficSecret(trafficSecret []byte) []byte {
+	return cs.ExpandLabel(trafficSecret, TrafficUpdateLabel, nil, cs.Hash.Size())
+}
+
+// ExportKeyingMaterial derives length bytes of keying material for label and context from the exporter master secret,
+// as defined in RFC 8446, Section 7.5. A nil context is the same as an empty one.
+func (cs *CipherSuite) ExportKeyingMaterial(exporterSecret []byte, label string, context []byte,
+	length int) ([]byte, error) {
+
+	if length < 0 || length > 255*cs.Hash.Size() {
+		return nil, errors.New("invalid keying material length")
+	}
+	if len("tls13 "+label) > 255 {
+		return nil, errors.New("keying material label is too long")
+	}
+	secret := cs.DeriveSecret(exporterSecret, label, nil)
+	contextHash := cs.Hash.New()
+	contextHash.Write(context)
+	return cs.ExpandLabel(secret, ExporterKeyLabel, contextHash.Sum(nil), length), nil
+}
+
+// CloneHash returns a copy of the running transcript hash h, so that more data can be hashed without changing h.
+func (cs *CipherSuite) CloneHash(h hash.Hash) hash.Hash {
+	marshaler, ok := h.(encoding.BinaryMarshaler)
+	common.AssertImpl(ok)
+	state, err := marshaler.MarshalBinary()
+	common.AssertImpl(err == nil)
+	clone := cs.Hash.New()
+	err = clone.(encoding.BinaryUnmarshaler).UnmarshalBinary(state)
+	common.AssertImpl(err == nil)
+	return clone
+}
+
+// ReplaceWithMessageHash replaces the first ClientHello in the transcript with a synthetic message_hash message which
+// holds its hash. It must be called when a HelloRetryRequest is sent or received, before it is added to the
+// transcript, as defined in RFC 8446, Section 4.4.1.
+func ReplaceWithMessageHash(transcript hash.Hash) {
+	clientHelloHash := transcript.Sum(nil)
+	transcript.Reset()
+	transcript.Write([]byte{byte(tlstypes.MessageHashMsgType), 0, 0, byte(len(clientHelloHash))})
+	transcript.Write(clientHelloHash)
+}
@@ -0,0 +1,138 @@
+package suite
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"errors"
+	"hash"
+)
+
+const (
+	ServerSignatureContext = "TLS 1.3, server CertificateVerify\x00"
+	ClientSignatureContext = "TLS 1.3, client CertificateVerify\x00"
+)
+
+// SupportedSignatureSchemes are the signature schemes of Sign and Verify, in order of preference.
+var SupportedSignatureSchemes = []tls.SignatureScheme{
+	tls.ECDSAWithP256AndSHA256,
+	tls.Ed25519,
+	tls.ECDSAWithP384AndSHA384,
+	tls.PSSWithSHA256,
+}
+
+var (
+	UnsupportedSignerErr          = errors.New("unsupported private key for signing")
+	UnsupportedVerifierErr        = errors.New("unsupported public key for signature verification")
+	UnsupportedSignatureSchemeErr = errors.New("no supported signature scheme for the key")
+	InvalidSignatureErr           = errors.New("invalid signature")
+	signatureContentPadding       = bytes.Repeat([]byte{0x20}, 64)
+)
+
+// SignedMessage builds the content covered by the CertificateVerify signature as defined in RFC 8446, Section 4.4.3.
+func SignedMessage(context string, transcript hash.Hash) []byte {
+	ret := make([]byte, 0, len(signatureContentPadding)+len(context)+transcript.Size())
+	ret = append(ret, signatureContentPadding...)
+	ret = append(ret, context...)
+	ret = append(ret, transcript.Sum(nil)...)
+	return ret
+}
+
+// SelectSignatureScheme picks the first of the peer's signature schemes, which are in the peer's order of preference,
+// that is supported and can be used with the key.
+func SelectSignatureScheme(pub crypto.PublicKey, peerSchemes []tls.SignatureScheme) (tls.SignatureScheme, error) {
+	for _, scheme := range peerSchemes {
+		if IsSupportedSignatureScheme(scheme) && schemeMatchesKey(scheme, pub) {
+			return scheme, nil
+		}
+	}
+	return 0, UnsupportedSignatureSchemeErr
+}
+
+func IsSupportedSignatureScheme(scheme tls.SignatureScheme) bool {
+	for _, s := range SupportedSignatureSchemes {
+		if s == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+// schemeMatchesKey reports whether scheme can be used with the key. The ECDSA schemes are bound to a curve in TLS 1.3
+// and rsa_pss_rsae schemes use keys of the rsaEncryption type, as defined in RFC 8446, Section 4.2.3.
+func schemeMatchesKey(scheme tls.SignatureScheme, pub crypto.PublicKey) bool {
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		return (scheme == tls.ECDSAWithP256AndSHA256 && key.Curve == elliptic.P256()) ||
+			(scheme == tls.ECDSAWithP384AndSHA384 && key.Curve == elliptic.P384())
+	case ed25519.PublicKey:
+		return scheme == tls.Ed25519
+	case *rsa.PublicKey:
+		return scheme == tls.PSSWithSHA256
+	default:
+		return false
+	}
+}
+
+// signatureHash returns the hash which is applied to the message before signing. Ed25519 signs the message itself.
+func signatureHash(scheme tls.SignatureScheme) crypto.Hash {
+	switch scheme {
+	case tls.ECDSAWithP256AndSHA256, tls.PSSWithSHA256:
+		return crypto.SHA256
+	case tls.ECDSAWithP384AndSHA384:
+		return crypto.SHA384
+	default:
+		return 0
+	}
+}
+
+func digest(scheme tls.SignatureScheme, msg []byte) []byte {
+	h := signatureHash(scheme)
+	if h == 0 {
+		return msg
+	}
+	hh := h.New()
+	hh.Write(msg)
+	return hh.Sum(nil)
+}
+
+// Sign creates a signature of msg with the given scheme.
+func Sign(scheme tls.SignatureScheme, priv crypto.PrivateKey, msg []byte) ([]byte, error) {
+	signer, ok := priv.(crypto.Signer)
+	if !ok || !IsSupportedSignatureScheme(scheme) || !schemeMatchesKey(scheme, signer.Public()) {
+		return nil, UnsupportedSignerErr
+	}
+	var opts crypto.SignerOpts = signatureHash(scheme)
+	if scheme == tls.PSSWithSHA256 {
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+	}
+	return signer.Sign(rand.Reader, digest(scheme, msg), opts)
+}
+
+// Verify checks a signature of msg with the given scheme.
+func Verify(scheme tls.SignatureScheme, pub crypto.PublicKey, msg, sig []byte) error {
+	if !IsSupportedSignatureScheme(scheme) || !schemeMatchesKey(scheme, pub) {
+		return UnsupportedVerifierErr
+	}
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest(scheme, msg), sig) {
+			return InvalidSignatureErr
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, msg, sig) {
+			return InvalidSignatureErr
+		}
+	case *rsa.PublicKey:
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+		if err := rsa.VerifyPSS(key, crypto.SHA256, digest(scheme, msg), sig, opts); err != nil {
+			return InvalidSignatureErr
+		}
+	}
+	return nil
+}
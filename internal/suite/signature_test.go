@@ -0,0 +1,65 @@
+package suite
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, ed, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transcript := sha256.New()
+	transcript.Write([]byte("handshake messages"))
+	msg := SignedMessage(ServerSignatureContext, transcript)
+	if len(msg) != 64+len(ServerSignatureContext)+sha256.Size {
+		t.Fatalf("SignedMessage has invalid length %d", len(msg))
+	}
+
+	for _, tc := range []struct {
+		scheme tls.SignatureScheme
+		priv   crypto.Signer
+	}{
+		{tls.ECDSAWithP256AndSHA256, p256},
+		{tls.ECDSAWithP384AndSHA384, p384},
+		{tls.Ed25519, ed},
+		{tls.PSSWithSHA256, rsaKey},
+	} {
+		scheme, err := SelectSignatureScheme(tc.priv.Public(), SupportedSignatureSchemes)
+		if err != nil || scheme != tc.scheme {
+			t.Fatalf("SelectSignatureScheme picked %#04x instead of %#04x: %v", uint16(scheme), uint16(tc.scheme), err)
+		}
+		sig, err := Sign(tc.scheme, tc.priv, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Verify(tc.scheme, tc.priv.Public(), msg, sig); err != nil {
+			t.Fatalf("Verify rejects a valid %#04x signature: %v", uint16(tc.scheme), err)
+		}
+		if err := Verify(tc.scheme, tc.priv.Public(), msg[1:], sig); err != InvalidSignatureErr {
+			t.Fatalf("Verify accepts an invalid %#04x signature", uint16(tc.scheme))
+		}
+	}
+
+	if _, err := Sign(tls.ECDSAWithP384AndSHA384, p256, msg); err != UnsupportedSignerErr {
+		t.Fatalf("Sign accepts a scheme which does not match the key")
+	}
+	if err := Verify(tls.PKCS1WithSHA256, rsaKey.Public(), msg, nil); err != UnsupportedVerifierErr {
+		t.Fatalf("Verify accepts an unsupported scheme")
+	}
+	if _, err := SelectSignatureScheme(rsaKey.Public(), []tls.SignatureScheme{tls.PKCS1WithSHA256}); err != UnsupportedSignatureSchemeErr {
+		t.Fatalf("SelectSignatureScheme picks an unsupported scheme")
+	}
+}
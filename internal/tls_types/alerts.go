@@ -42,7 +42,11 @@ const (
 	InternalError             AlertDescription = 80
 	UserCanceled              AlertDescription = 90
 	NoRenegotiation           AlertDescription = 100
+	MissingExtension          AlertDescription = 109 // RFC 8446
 	UnsupportedExtension      AlertDescription = 110
+	UnrecognizedName          AlertDescription = 112 // RFC 6066
+	CertificateRequired       AlertDescription = 116 // RFC 8446
+	NoApplicationProtocol     AlertDescription = 120 // RFC 7301
 )
 
 type Alert struct {
@@ -114,8 +118,16 @@ func ParseAlert(raw []byte) (*Alert, error) {
 		a.Description = UserCanceled
 	case NoRenegotiation:
 		a.Description = NoRenegotiation
+	case MissingExtension:
+		a.Description = MissingExtension
 	case UnsupportedExtension:
 		a.Description = UnsupportedExtension
+	case UnrecognizedName:
+		a.Description = UnrecognizedName
+	case CertificateRequired:
+		a.Description = CertificateRequired
+	case NoApplicationProtocol:
+		a.Description = NoApplicationProtocol
 	default:
 		return nil, errors.New("unsupported alert description")
 	}
@@ -0,0 +1,135 @@
+package tlstypes
+
+import (
+	"errors"
+
+	"github.com/tls-handshake/internal/common"
+	typesizes "github.com/tls-handshake/pkg/type_sizes"
+)
+
+type CertificateEntry struct {
+	CertDataLen   uint32 // 3 bytes on the wire
+	CertData      []byte // DER encoded X.509 certificate
+	ExtensionsLen uint16
+	ExtensionData []byte
+}
+
+type CertificateMsg struct {
+	Type               HandshakeMsgType
+	Length             uint
+	RequestContextLen  uint8
+	RequestContext     []byte
+	CertificateListLen uint32 // 3 bytes on the wire
+	CertificateList    []CertificateEntry
+}
+
+func ParseCertificateMsg(buf []byte) (hm *CertificateMsg, err error) {
+	if len(buf) < int(HandshakeHeaderByteSize) {
+		// must be able to, at least, read the HandshakeHeader
+		return nil, errors.New("unsupported handshake message size")
+	}
+
+	wi := 0 // write index
+	hm = &CertificateMsg{}
+
+	// Handshake Header:
+	hm.Type = HandshakeMsgType(buf[wi])
+	if hm.Type != CertificateMsgType {
+		return nil, errors.New("not a certificate handshake message")
+	}
+	hm.Length = uint(buf[wi+1])<<16 + uint(buf[wi+2])<<8 + uint(buf[wi+3])
+	wi += int(HandshakeHeaderByteSize)
+	if hm.Length > uint(len(buf[wi:])) {
+		return nil, errors.New("certificate message has invalid length")
+	}
+
+	// Request Context:
+	if len(buf[wi:]) < typesizes.Uint8Bytes {
+		return nil, errors.New("certificate message has invalid format")
+	}
+	hm.RequestContextLen = uint8(buf[wi])
+	wi += typesizes.Uint8Bytes
+	if len(buf[wi:]) < int(hm.RequestContextLen) {
+		return nil, errors.New("certificate message has invalid request context length")
+	}
+	hm.RequestContext = make([]byte, hm.RequestContextLen)
+	wi += copy(hm.RequestContext[:], buf[wi:])
+
+	// Certificate List:
+	if len(buf[wi:]) < int(Uint24ByteSize) {
+		return nil, errors.New("certificate message has invalid format")
+	}
+	hm.CertificateListLen = readUint24(buf[wi:])
+	wi += int(Uint24ByteSize)
+	if len(buf[wi:]) < int(hm.CertificateListLen) {
+		return nil, errors.New("certificate message has invalid certificate list length")
+	}
+	hm.CertificateList, err = parseCertificateEntries(buf[wi : wi+int(hm.CertificateListLen)])
+	if err != nil {
+		return nil, err
+	}
+	wi += int(hm.CertificateListLen)
+
+	// Final sanity check:
+	if wi-int(HandshakeHeaderByteSize) != int(hm.Length) {
+		return nil, errors.New("certificate message has invalid length")
+	}
+
+	return hm, nil
+}
+
+func parseCertificateEntries(buf []byte) ([]CertificateEntry, error) {
+	ret := make([]CertificateEntry, 0, 1)
+
+	for wi := 0; wi < len(buf); {
+		e := CertificateEntry{}
+
+		if len(buf[wi:]) < int(Uint24ByteSize) {
+			return nil, errors.New("certificate entry has invalid format")
+		}
+		e.CertDataLen = readUint24(buf[wi:])
+		wi += int(Uint24ByteSize)
+		if e.CertDataLen == 0 || len(buf[wi:]) < int(e.CertDataLen) {
+			return nil, errors.New("certificate entry has invalid certificate length")
+		}
+		e.CertData = make([]byte, e.CertDataLen)
+		wi += copy(e.CertData[:], buf[wi:])
+
+		if len(buf[wi:]) < int(ExtensionsLengthByteSize) {
+			return nil, errors.New("certificate entry has invalid format")
+		}
+		e.ExtensionsLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+		wi += int(ExtensionsLengthByteSize)
+		if len(buf[wi:]) < int(e.ExtensionsLen) {
+			return nil, errors.New("certificate entry has invalid extensions length")
+		}
+		e.ExtensionData = make([]byte, e.ExtensionsLen)
+		wi += copy(e.ExtensionData[:], buf[wi:])
+
+		ret = append(ret, e)
+	}
+
+	return ret, nil
+}
+
+func (hm *CertificateMsg) ToBinary() []byte {
+	common.AssertImpl(hm != nil)
+	// Pre-allocate if length is known, else cap is HandshakeHeaderByteSize
+	raw := make([]byte, 0, hm.Length+uint(HandshakeHeaderByteSize))
+
+	raw = append(raw, byte(hm.Type))
+	raw = append(raw, byte(hm.Length>>16), byte(hm.Length>>8), byte(hm.Length))
+	raw = append(raw, hm.RequestContextLen)
+	raw = append(raw, hm.RequestContext[:]...)
+	raw = appendUint24(raw, hm.CertificateListLen)
+	for i := 0; i < len(hm.CertificateList); i++ {
+		e := &hm.CertificateList[i]
+		raw = appendUint24(raw, e.CertDataLen)
+		raw = append(raw, e.CertData[:]...)
+		raw = append(raw, byte(e.ExtensionsLen>>8), byte(e.ExtensionsLen))
+		raw = append(raw, e.ExtensionData[:]...)
+	}
+
+	setHandshakeLength(raw, &hm.Length)
+	return raw
+}
@@ -0,0 +1,90 @@
+package tlstypes
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestParseCertificateMsg(t *testing.T) {
+	var buf []byte = []byte{
+		0x0b, 0x00, 0x00, 0x0d, 0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x04, 0x01, 0x02, 0x03, 0x04, 0x00, 0x00,
+	}
+
+	hm, err := ParseCertificateMsg(buf)
+	if err != nil {
+		t.Fatalf("ParseCertificateMsg is broken")
+	}
+	if len(hm.CertificateList) != 1 || string(hm.CertificateList[0].CertData) != string(buf[11:15]) {
+		t.Fatalf("ParseCertificateMsg is broken when parsing certificate entries")
+	}
+
+	binHm := hm.ToBinary()
+	v := string(binHm) == string(buf)
+	if !v {
+		t.Fatalf("ParseCertificateMsg.ToBinary is broken")
+	}
+
+	hm = MakeCertificateMessage([][]byte{buf[11:15]})
+	binHm = hm.ToBinary()
+	v = string(binHm) == string(buf)
+	if !v {
+		t.Fatalf("MakeCertificateMessage is broken")
+	}
+
+	if _, err = ParseCertificateMsg(buf[:len(buf)-1]); err == nil {
+		t.Fatalf("ParseCertificateMsg accepts a truncated message")
+	}
+}
+
+func TestParseCertificateVerifyMsg(t *testing.T) {
+	var buf []byte = []byte{0x0f, 0x00, 0x00, 0x08, 0x04, 0x03, 0x00, 0x04, 0xaa, 0xbb, 0xcc, 0xdd}
+
+	hm, err := ParseCertificateVerifyMsg(buf)
+	if err != nil {
+		t.Fatalf("ParseCertificateVerifyMsg is broken")
+	}
+
+	binHm := hm.ToBinary()
+	v := string(binHm) == string(buf)
+	if !v {
+		t.Fatalf("ParseCertificateVerifyMsg.ToBinary is broken")
+	}
+
+	hm.Length = 0
+	binHm = hm.ToBinary()
+	v = string(binHm) == string(buf)
+	if !v {
+		t.Fatalf("ParseCertificateVerifyMsg.ToBinary is broken when Length is 0")
+	}
+}
+
+func TestParseCertificateRequestMsg(t *testing.T) {
+	var buf []byte = []byte{
+		0x0d, 0x00, 0x00, 0x0b, // handshake header
+		0x00,       // request context
+		0x00, 0x08, // extensions length
+		0x00, 0x0d, 0x00, 0x04, 0x00, 0x02, 0x04, 0x03, // signature_algorithms: ecdsa_secp256r1_sha256
+	}
+
+	hm, err := ParseCertificateRequestMsg(buf)
+	if err != nil {
+		t.Fatalf("ParseCertificateRequestMsg is broken")
+	}
+
+	binHm := hm.ToBinary()
+	v := string(binHm) == string(buf)
+	if !v {
+		t.Fatalf("ParseCertificateRequestMsg.ToBinary is broken")
+	}
+
+	hm = MakeCertificateRequestMessage([]byte{}, []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256})
+	binHm = hm.ToBinary()
+	v = string(binHm) == string(buf)
+	if !v {
+		t.Fatalf("MakeCertificateRequestMessage is broken")
+	}
+
+	if _, err := ParseCertificateRequestMsg(buf[:len(buf)-1]); err == nil {
+		t.Fatalf("ParseCertificateRequestMsg accepts a truncated message")
+	}
+}
@@ -0,0 +1,86 @@
+package tlstypes
+
+import (
+	"errors"
+
+	"github.com/tls-handshake/internal/common"
+	typesizes "github.com/tls-handshake/pkg/type_sizes"
+)
+
+// CertificateRequestMsg asks the client to authenticate with a certificate, as defined in RFC 8446, Section 4.3.2.
+type CertificateRequestMsg struct {
+	Type              HandshakeMsgType
+	Length            uint
+	RequestContextLen uint8
+	RequestContext    []byte // echoed in the Certificate message of the client
+	ExtensionsLen     uint16
+	ExtensionData     []byte
+}
+
+func ParseCertificateRequestMsg(buf []byte) (hm *CertificateRequestMsg, err error) {
+	if len(buf) < int(HandshakeHeaderByteSize) {
+		// must be able to, at least, read the HandshakeHeader
+		return nil, errors.New("unsupported handshake message size")
+	}
+
+	wi := 0 // write index
+	hm = &CertificateRequestMsg{}
+
+	// Handshake Header:
+	hm.Type = HandshakeMsgType(buf[wi])
+	if hm.Type != CertificateRequestMsgType {
+		return nil, errors.New("not a certificate request handshake message")
+	}
+	hm.Length = uint(buf[wi+1])<<16 + uint(buf[wi+2])<<8 + uint(buf[wi+3])
+	wi += int(HandshakeHeaderByteSize)
+	if hm.Length > uint(len(buf[wi:])) {
+		return nil, errors.New("certificate request message has invalid length")
+	}
+
+	// Request Context:
+	if len(buf[wi:]) < typesizes.Uint8Bytes {
+		return nil, errors.New("certificate request message has invalid format")
+	}
+	hm.RequestContextLen = uint8(buf[wi])
+	wi += typesizes.Uint8Bytes
+	if len(buf[wi:]) < int(hm.RequestContextLen) {
+		return nil, errors.New("certificate request message has invalid request context length")
+	}
+	hm.RequestContext = make([]byte, hm.RequestContextLen)
+	wi += copy(hm.RequestContext[:], buf[wi:])
+
+	// Extensions:
+	if len(buf[wi:]) < int(ExtensionsLengthByteSize) {
+		return nil, errors.New("certificate request message has invalid format")
+	}
+	hm.ExtensionsLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+	wi += int(ExtensionsLengthByteSize)
+	if len(buf[wi:]) < int(hm.ExtensionsLen) {
+		return nil, errors.New("certificate request message has invalid extensions length")
+	}
+	hm.ExtensionData = make([]byte, hm.ExtensionsLen)
+	wi += copy(hm.ExtensionData[:], buf[wi:])
+
+	// Final sanity check:
+	if wi-int(HandshakeHeaderByteSize) != int(hm.Length) {
+		return nil, errors.New("certificate request message has invalid length")
+	}
+
+	return hm, nil
+}
+
+func (hm *CertificateRequestMsg) ToBinary() []byte {
+	common.AssertImpl(hm != nil)
+	// Pre-allocate if length is known, else cap is HandshakeHeaderByteSize
+	raw := make([]byte, 0, hm.Length+uint(HandshakeHeaderByteSize))
+
+	raw = append(raw, byte(hm.Type))
+	raw = append(raw, byte(hm.Length>>16), byte(hm.Length>>8), byte(hm.Length))
+	raw = append(raw, hm.RequestContextLen)
+	raw = append(raw, hm.RequestContext[:]...)
+	raw = append(raw, byte(hm.ExtensionsLen>>8), byte(hm.ExtensionsLen))
+	raw = append(raw, hm.ExtensionData[:]...)
+
+	setHandshakeLength(raw, &hm.Length)
+	return raw
+}
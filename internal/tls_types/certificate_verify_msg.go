@@ -0,0 +1,79 @@
+package tlstypes
+
+import (
+	"crypto/tls"
+	"errors"
+
+	"github.com/tls-handshake/internal/common"
+	typesizes "github.com/tls-handshake/pkg/type_sizes"
+)
+
+type CertificateVerifyMsg struct {
+	Type            HandshakeMsgType
+	Length          uint
+	SignatureScheme tls.SignatureScheme
+	SignatureLen    uint16
+	Signature       []byte
+}
+
+func ParseCertificateVerifyMsg(buf []byte) (hm *CertificateVerifyMsg, err error) {
+	if len(buf) < int(HandshakeHeaderByteSize) {
+		// must be able to, at least, read the HandshakeHeader
+		return nil, errors.New("unsupported handshake message size")
+	}
+
+	wi := 0 // write index
+	hm = &CertificateVerifyMsg{}
+
+	// Handshake Header:
+	hm.Type = HandshakeMsgType(buf[wi])
+	if hm.Type != CertificateVerifyMsgType {
+		return nil, errors.New("not a certificate verify handshake message")
+	}
+	hm.Length = uint(buf[wi+1])<<16 + uint(buf[wi+2])<<8 + uint(buf[wi+3])
+	wi += int(HandshakeHeaderByteSize)
+	if hm.Length > uint(len(buf[wi:])) {
+		return nil, errors.New("certificate verify message has invalid length")
+	}
+
+	// SignatureScheme:
+	if len(buf[wi:]) < int(SignatureSchemeByteSize) {
+		return nil, errors.New("certificate verify message has invalid format")
+	}
+	hm.SignatureScheme = (tls.SignatureScheme(buf[wi]) << 8) + tls.SignatureScheme(buf[wi+1])
+	wi += int(SignatureSchemeByteSize)
+
+	// Signature:
+	if len(buf[wi:]) < typesizes.Uint16Bytes {
+		return nil, errors.New("certificate verify message has invalid format")
+	}
+	hm.SignatureLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+	wi += typesizes.Uint16Bytes
+	if len(buf[wi:]) < int(hm.SignatureLen) {
+		return nil, errors.New("certificate verify message has invalid signature length")
+	}
+	hm.Signature = make([]byte, hm.SignatureLen)
+	wi += copy(hm.Signature[:], buf[wi:])
+
+	// Final sanity check:
+	if wi-int(HandshakeHeaderByteSize) != int(hm.Length) {
+		return nil, errors.New("certificate verify message has invalid length")
+	}
+
+	return hm, nil
+}
+
+func (hm *CertificateVerifyMsg) ToBinary() []byte {
+	common.AssertImpl(hm != nil)
+	// Pre-allocate if length is known, else cap is HandshakeHeaderByteSize
+	raw := make([]byte, 0, hm.Length+uint(HandshakeHeaderByteSize))
+
+	raw = append(raw, byte(hm.Type))
+	raw = append(raw, byte(hm.Length>>16), byte(hm.Length>>8), byte(hm.Length))
+	raw = append(raw, byte(hm.SignatureScheme>>8), byte(hm.SignatureScheme))
+	raw = append(raw, byte(hm.SignatureLen>>8), byte(hm.SignatureLen))
+	raw = append(raw, hm.Signature[:]...)
+
+	setHandshakeLength(raw, &hm.Length)
+	return raw
+}
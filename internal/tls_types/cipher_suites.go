@@ -15,21 +15,17 @@ const (
 	TLS_CHACHA20_POLY1305_SHA256 = CipherSuite(tls.TLS_CHACHA20_POLY1305_SHA256)
 )
 
+// ParseCipherSuites parses the cipher suites offered by a client. Clients offer TLS 1.2 suites and GREASE values too,
+// so suites which aren't implemented are kept in the list as they are and it's up to the server to skip them.
 func ParseCipherSuites(raw []byte) ([]CipherSuite, error) {
-	ret := make([]CipherSuite, 0, 3)
+	if len(raw) == 0 || len(raw)%2 != 0 {
+		return nil, errors.New("invalid cipher suites length")
+	}
+	ret := make([]CipherSuite, 0, len(raw)/2)
 
 	for i := 0; i < len(raw); i += 2 {
 		c := (uint16(raw[i]) << 8) + uint16(raw[i+1])
-		switch c {
-		case tls.TLS_AES_128_GCM_SHA256:
-			ret = append(ret, TLS_AES_128_GCM_SHA256)
-		case tls.TLS_AES_256_GCM_SHA384:
-			ret = append(ret, TLS_AES_256_GCM_SHA384)
-		case tls.TLS_CHACHA20_POLY1305_SHA256:
-			ret = append(ret, TLS_CHACHA20_POLY1305_SHA256)
-		default:
-			return ret, errors.New("unsupported cipher suite")
-		}
+		ret = append(ret, CipherSuite(c))
 	}
 
 	return ret, nil
@@ -8,17 +8,18 @@ import (
 )
 
 type ClientHelloMsg struct {
-	Type               HandshakeMsgType
-	Length             uint
-	TLSVersion         [VersionByteSize]byte // this one is hardcoded to tls 1.2, ignore it
-	Random             [RandomByteSize]byte
-	SessionIDLen       uint8
-	SessionID          []byte
-	CipherSuiteLen     uint16
-	CipherSuite        []CipherSuite
-	CompressionMethods [2]byte
-	ExtensionsLen      uint16
-	ExtensionData      []byte
+	Type                  HandshakeMsgType
+	Length                uint
+	TLSVersion            [VersionByteSize]byte // this one is hardcoded to tls 1.2, ignore it
+	Random                [RandomByteSize]byte
+	SessionIDLen          uint8
+	SessionID             []byte
+	CipherSuiteLen        uint16
+	CipherSuite           []CipherSuite // may hold suites which aren't implemented, they are never selected
+	CompressionMethodsLen uint8
+	CompressionMethods    []byte // TLS 1.3 allows only the null method, older clients send more
+	ExtensionsLen         uint16
+	ExtensionData         []byte
 }
 
 func ParseClientHelloMsg(buf []byte) (hm *ClientHelloMsg, err error) {
@@ -81,9 +82,15 @@ func ParseClientHelloMsg(buf []byte) (hm *ClientHelloMsg, err error) {
 	wi += int(hm.CipherSuiteLen)
 
 	// CompressionMethods:
-	if len(buf[wi:]) < len(hm.CompressionMethods[:]) {
+	if len(buf[wi:]) < typesizes.Uint8Bytes {
 		return nil, errors.New("client hello message has invalid format")
 	}
+	hm.CompressionMethodsLen = uint8(buf[wi])
+	wi += typesizes.Uint8Bytes
+	if hm.CompressionMethodsLen == 0 || len(buf[wi:]) < int(hm.CompressionMethodsLen) {
+		return nil, errors.New("client hello message has invalid compression methods length")
+	}
+	hm.CompressionMethods = make([]byte, hm.CompressionMethodsLen)
 	wi += copy(hm.CompressionMethods[:], buf[wi:])
 
 	// Extensions:
@@ -99,7 +106,9 @@ func ParseClientHelloMsg(buf []byte) (hm *ClientHelloMsg, err error) {
 	wi += copy(hm.ExtensionData[:], buf[wi:])
 
 	// Final sanity check:
-	common.AssertImpl(wi-int(HandshakeHeaderByteSize) == int(hm.Length))
+	if wi-int(HandshakeHeaderByteSize) != int(hm.Length) {
+		return nil, errors.New("client hello message has invalid length")
+	}
 
 	return hm, nil
 }
@@ -120,6 +129,7 @@ func (hm *ClientHelloMsg) ToBinary() []byte {
 		cs := hm.CipherSuite[i]
 		raw = append(raw, byte(cs>>8), byte(cs))
 	}
+	raw = append(raw, hm.CompressionMethodsLen)
 	raw = append(raw, hm.CompressionMethods[:]...)
 	raw = append(raw, byte(hm.ExtensionsLen>>8), byte(hm.ExtensionsLen))
 	raw = append(raw, hm.ExtensionData[:]...)
@@ -1,7 +1,11 @@
 package tlstypes
 
 import (
+	"crypto/tls"
+	"net"
 	"testing"
+
+	"github.com/tls-handshake/internal/tls_types/extensions"
 )
 
 func TestParseClientHelloMsg(t *testing.T) {
@@ -48,4 +52,101 @@ func TestParseClientHelloMsg(t *testing.T) {
 	if !v {
 		t.Fatalf("ParseClientHelloMsg.ToBinary is broken when Length is 0")
 	}
-}
\ No newline at end of file
+}
+func TestParseStandardClientHello(t *testing.T) {
+	// Capture the ClientHello of crypto/tls, which sends extensions this package doesn't implement and offers TLS 1.2
+	// cipher suites and versions too.
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		cfg := &tls.Config{ServerName: "example.com", NextProtos: []string{"h2"}}
+		_ = tls.Client(client, cfg).Handshake()
+		client.Close()
+	}()
+
+	record, err := NewRecordReader(server).ReadRecord()
+	if err != nil {
+		t.Fatalf("ReadRecord fails on a crypto/tls client hello: %v", err)
+	}
+	hm, err := ParseClientHelloMsg(record.Data)
+	if err != nil {
+		t.Fatalf("ParseClientHelloMsg fails on a crypto/tls client hello: %v", err)
+	}
+	if len(hm.CipherSuite) != int(hm.CipherSuiteLen)/2 || !containsSuite(hm.CipherSuite, TLS_AES_128_GCM_SHA256) {
+		t.Fatalf("ParseClientHelloMsg misses the cipher suites of a crypto/tls client hello")
+	}
+	if string(hm.CompressionMethods) != "\x00" {
+		t.Fatalf("ParseClientHelloMsg got compression methods %v", hm.CompressionMethods)
+	}
+	exts, err := extensions.ParseExtensions(hm.ExtensionData, hm.ExtensionsLen, extensions.ClientHelloMsgContext)
+	if err != nil {
+		t.Fatalf("ParseExtensions fails on a crypto/tls client hello: %v", err)
+	}
+	sne, ok := extensions.FindExtension(exts, extensions.ServerNameType).(*extensions.ServerNameExtension)
+	if !ok || sne.HostName() != "example.com" {
+		t.Fatalf("ParseExtensions misses the server name of a crypto/tls client hello")
+	}
+	sve, ok := extensions.FindExtension(exts, extensions.SupporteVersionsType).(*extensions.SupportedVersions)
+	if !ok || !sve.Supports(tls.VersionTLS13) || !sve.Supports(tls.VersionTLS12) {
+		t.Fatalf("ParseExtensions misses the supported versions of a crypto/tls client hello")
+	}
+	var data []byte
+	for _, ext := range exts {
+		data = append(data, ext.ToBinary()...)
+	}
+	if string(data) != string(hm.ExtensionData) {
+		t.Fatalf("ToBinary doesn't round-trip the extensions of a crypto/tls client hello")
+	}
+	if string(hm.ToBinary()) != string(record.Data) {
+		t.Fatalf("ToBinary doesn't round-trip a crypto/tls client hello")
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	// TLS 1.2 suites and GREASE values are kept, the server skips them:
+	suites, err := ParseCipherSuites([]byte{0x0a, 0x0a, 0xc0, 0x2b, 0x13, 0x01})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suites) != 3 || suites[0] != 0x0a0a || suites[1] != 0xc02b || suites[2] != TLS_AES_128_GCM_SHA256 {
+		t.Fatalf("ParseCipherSuites got %v", suites)
+	}
+	if _, err := ParseCipherSuites([]byte{0x13, 0x01, 0x13}); err == nil {
+		t.Fatalf("ParseCipherSuites accepts an odd length")
+	}
+}
+
+func containsSuite(suites []CipherSuite, id CipherSuite) bool {
+	for _, s := range suites {
+		if s == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestParseMalformedClientHelloMsg(t *testing.T) {
+	cfg := &ClientHelloExtParams{ServerName: "example.com"}
+	raw := MakeClientHelloMessage([]CipherSuite{TLS_AES_128_GCM_SHA256}, cfg).ToBinary()
+
+	// Every truncation fails, whether or not the length in the header is fixed up:
+	for n := 0; n < len(raw); n++ {
+		if _, err := ParseClientHelloMsg(raw[:n]); err == nil {
+			t.Fatalf("ParseClientHelloMsg accepts a client hello truncated to %d bytes", n)
+		}
+		truncated := append([]byte(nil), raw[:n]...)
+		if n >= int(HandshakeHeaderByteSize) {
+			setHandshakeLength(truncated, new(uint))
+		}
+		if _, err := ParseClientHelloMsg(truncated); err == nil {
+			t.Fatalf("ParseClientHelloMsg accepts a client hello truncated to %d bytes", n)
+		}
+	}
+
+	// A trailing byte counted in the length of the message:
+	trailing := append(append([]byte(nil), raw...), 0x00)
+	setHandshakeLength(trailing, new(uint))
+	if _, err := ParseClientHelloMsg(trailing); err == nil {
+		t.Fatalf("ParseClientHelloMsg accepts a trailing byte")
+	}
+}
@@ -0,0 +1,68 @@
+package tlstypes
+
+import (
+	"errors"
+
+	"github.com/tls-handshake/internal/common"
+)
+
+type EncryptedExtensionsMsg struct {
+	Type          HandshakeMsgType
+	Length        uint
+	ExtensionsLen uint16
+	ExtensionData []byte
+}
+
+func ParseEncryptedExtensionsMsg(buf []byte) (hm *EncryptedExtensionsMsg, err error) {
+	if len(buf) < int(HandshakeHeaderByteSize) {
+		// must be able to, at least, read the HandshakeHeader
+		return nil, errors.New("unsupported handshake message size")
+	}
+
+	wi := 0 // write index
+	hm = &EncryptedExtensionsMsg{}
+
+	// Handshake Header:
+	hm.Type = HandshakeMsgType(buf[wi])
+	if hm.Type != EncryptedExtensionsMsgType {
+		return nil, errors.New("not an encrypted extensions handshake message")
+	}
+	hm.Length = uint(buf[wi+1])<<16 + uint(buf[wi+2])<<8 + uint(buf[wi+3])
+	wi += int(HandshakeHeaderByteSize)
+	if hm.Length > uint(len(buf[wi:])) {
+		return nil, errors.New("encrypted extensions message has invalid length")
+	}
+
+	// Extensions:
+	if len(buf[wi:]) < int(ExtensionsLengthByteSize) {
+		return nil, errors.New("encrypted extensions message has invalid format")
+	}
+	hm.ExtensionsLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+	wi += int(ExtensionsLengthByteSize)
+	if len(buf[wi:]) < int(hm.ExtensionsLen) {
+		return nil, errors.New("encrypted extensions message has invalid extensions length")
+	}
+	hm.ExtensionData = make([]byte, hm.ExtensionsLen)
+	wi += copy(hm.ExtensionData[:], buf[wi:])
+
+	// Final sanity check:
+	if wi-int(HandshakeHeaderByteSize) != int(hm.Length) {
+		return nil, errors.New("encrypted extensions message has invalid length")
+	}
+
+	return hm, nil
+}
+
+func (hm *EncryptedExtensionsMsg) ToBinary() []byte {
+	common.AssertImpl(hm != nil)
+	// Pre-allocate if length is known, else cap is HandshakeHeaderByteSize
+	raw := make([]byte, 0, hm.Length+uint(HandshakeHeaderByteSize))
+
+	raw = append(raw, byte(hm.Type))
+	raw = append(raw, byte(hm.Length>>16), byte(hm.Length>>8), byte(hm.Length))
+	raw = append(raw, byte(hm.ExtensionsLen>>8), byte(hm.ExtensionsLen))
+	raw = append(raw, hm.ExtensionData[:]...)
+
+	setHandshakeLength(raw, &hm.Length)
+	return raw
+}
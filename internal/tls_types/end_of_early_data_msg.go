@@ -0,0 +1,50 @@
+package tlstypes
+
+import (
+	"errors"
+
+	"github.com/tls-handshake/internal/common"
+)
+
+// EndOfEarlyDataMsg is sent by the client after the early data, when the server accepted it. It's protected with the
+// client early traffic secret and has no content, as defined in RFC 8446, Section 4.5.
+type EndOfEarlyDataMsg struct {
+	Type   HandshakeMsgType
+	Length uint
+}
+
+func ParseEndOfEarlyDataMsg(buf []byte) (hm *EndOfEarlyDataMsg, err error) {
+	if len(buf) < int(HandshakeHeaderByteSize) {
+		// must be able to, at least, read the HandshakeHeader
+		return nil, errors.New("unsupported handshake message size")
+	}
+
+	wi := 0 // write index
+	hm = &EndOfEarlyDataMsg{}
+
+	// Handshake Header:
+	hm.Type = HandshakeMsgType(buf[wi])
+	if hm.Type != EndOfEarlyDataMsgType {
+		return nil, errors.New("not an end of early data handshake message")
+	}
+	hm.Length = uint(buf[wi+1])<<16 + uint(buf[wi+2])<<8 + uint(buf[wi+3])
+	wi += int(HandshakeHeaderByteSize)
+
+	// Final sanity check:
+	if hm.Length != 0 || wi != len(buf) {
+		return nil, errors.New("end of early data message has invalid length")
+	}
+
+	return hm, nil
+}
+
+func (hm *EndOfEarlyDataMsg) ToBinary() []byte {
+	common.AssertImpl(hm != nil)
+	raw := make([]byte, 0, HandshakeHeaderByteSize)
+
+	raw = append(raw, byte(hm.Type))
+	raw = append(raw, byte(hm.Length>>16), byte(hm.Length>>8), byte(hm.Length))
+
+	setHandshakeLength(raw, &hm.Length)
+	return raw
+}
@@ -0,0 +1,94 @@
+package extensions
+
+import (
+	"errors"
+
+	"github.com/tls-handshake/internal/common"
+	typesizes "github.com/tls-handshake/pkg/type_sizes"
+)
+
+// ALPNExtension lists the application protocols the client can speak in order of preference. In EncryptedExtensions
+// it holds the single protocol the server selected, as defined in RFC 7301, Section 3.1.
+type ALPNExtension struct {
+	Type         ExtensionType
+	ExtensionLen uint16
+	ProtocolsLen uint16
+	Protocols    []string
+}
+
+func ParseALPNExtension(buf []byte, ctx MsgContext) (alpnext *ALPNExtension, err error) {
+	wi := 0 // write index
+	alpnext = &ALPNExtension{}
+
+	alpnext.Type, err = ParseExtensionType(buf)
+	if err != nil {
+		return nil, err
+	}
+	if alpnext.Type != ALPNType {
+		return nil, errors.New("not an application layer protocol negotiation extension type")
+	}
+	wi += int(typesizes.Uint16Bytes)
+
+	if len(buf[wi:]) < int(typesizes.Uint16Bytes) {
+		return nil, errors.New("alpn extension has invalid format")
+	}
+	alpnext.ExtensionLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+	wi += int(typesizes.Uint16Bytes)
+
+	if len(buf[wi:]) < int(typesizes.Uint16Bytes) {
+		return nil, errors.New("alpn extension has invalid format")
+	}
+	alpnext.ProtocolsLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+	wi += int(typesizes.Uint16Bytes)
+
+	if alpnext.ProtocolsLen == 0 || len(buf[wi:]) < int(alpnext.ProtocolsLen) {
+		return nil, errors.New("alpn extension has invalid protocols length")
+	}
+	protocols := buf[wi : wi+int(alpnext.ProtocolsLen)]
+	for len(protocols) > 0 {
+		nameLen := int(protocols[0])
+		if nameLen == 0 || len(protocols[typesizes.Uint8Bytes:]) < nameLen {
+			return nil, errors.New("alpn extension has invalid protocol name")
+		}
+		alpnext.Protocols = append(alpnext.Protocols, string(protocols[typesizes.Uint8Bytes:typesizes.Uint8Bytes+nameLen]))
+		protocols = protocols[typesizes.Uint8Bytes+nameLen:]
+	}
+	wi += int(alpnext.ProtocolsLen)
+
+	switch ctx {
+	case ClientHelloMsgContext:
+	case EncryptedExtensionsMsgContext:
+		if len(alpnext.Protocols) != 1 {
+			return nil, errors.New("alpn extension must hold exactly one selected protocol")
+		}
+	default:
+		return nil, errors.New("alpn extension is not allowed in this message")
+	}
+
+	// Final sanity check:
+	if wi != alpnext.GetFullExtLen() {
+		return nil, errors.New("alpn extension has invalid extension length")
+	}
+
+	return alpnext, nil
+}
+
+func (alpne *ALPNExtension) ToBinary() []byte {
+	common.AssertImpl(alpne != nil)
+	raw := make([]byte, 0, alpne.GetFullExtLen())
+	raw = append(raw, byte(alpne.Type>>8), byte(alpne.Type))
+	raw = append(raw, byte(alpne.ExtensionLen>>8), byte(alpne.ExtensionLen))
+	raw = append(raw, byte(alpne.ProtocolsLen>>8), byte(alpne.ProtocolsLen))
+	for _, p := range alpne.Protocols {
+		raw = append(raw, byte(len(p)))
+		raw = append(raw, p...)
+	}
+	return raw
+}
+
+func (alpne *ALPNExtension) GetType() ExtensionType { return alpne.Type }
+
+func (alpne *ALPNExtension) GetFullExtLen() int {
+	full := int(alpne.ExtensionLen) + (typesizes.Uint16Bytes * 2)
+	return full
+}
@@ -0,0 +1,73 @@
+package extensions
+
+import (
+	"errors"
+
+	"github.com/tls-handshake/internal/common"
+	typesizes "github.com/tls-handshake/pkg/type_sizes"
+)
+
+// CookieExtension is sent by the server in a HelloRetryRequest and must be echoed by the client in the second
+// ClientHello, as defined in RFC 8446, Section 4.2.2.
+type CookieExtension struct {
+	Type         ExtensionType
+	ExtensionLen uint16
+	CookieLen    uint16
+	Cookie       []byte
+}
+
+func ParseCookieExtension(buf []byte) (cext *CookieExtension, err error) {
+	wi := 0 // write index
+	cext = &CookieExtension{}
+
+	cext.Type, err = ParseExtensionType(buf)
+	if err != nil {
+		return nil, err
+	}
+	if cext.Type != CookieType {
+		return nil, errors.New("not a cookie extension type")
+	}
+	wi += int(typesizes.Uint16Bytes)
+
+	if len(buf[wi:]) < int(typesizes.Uint16Bytes) {
+		return nil, errors.New("cookie extension has invalid format")
+	}
+	cext.ExtensionLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+	wi += int(typesizes.Uint16Bytes)
+
+	if len(buf[wi:]) < int(typesizes.Uint16Bytes) {
+		return nil, errors.New("cookie extension has invalid format")
+	}
+	cext.CookieLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+	wi += int(typesizes.Uint16Bytes)
+
+	if cext.CookieLen == 0 || len(buf[wi:]) < int(cext.CookieLen) {
+		return nil, errors.New("cookie extension has invalid cookie length")
+	}
+	cext.Cookie = make([]byte, cext.CookieLen)
+	wi += copy(cext.Cookie[:], buf[wi:])
+
+	// Final sanity check:
+	if wi != cext.GetFullExtLen() {
+		return nil, errors.New("cookie extension has invalid extension length")
+	}
+
+	return cext, nil
+}
+
+func (ce *CookieExtension) ToBinary() []byte {
+	common.AssertImpl(ce != nil)
+	raw := make([]byte, 0, ce.GetFullExtLen())
+	raw = append(raw, byte(ce.Type>>8), byte(ce.Type))
+	raw = append(raw, byte(ce.ExtensionLen>>8), byte(ce.ExtensionLen))
+	raw = append(raw, byte(ce.CookieLen>>8), byte(ce.CookieLen))
+	raw = append(raw, ce.Cookie[:]...)
+	return raw
+}
+
+func (ce *CookieExtension) GetType() ExtensionType { return ce.Type }
+
+func (ce *CookieExtension) GetFullExtLen() int {
+	full := int(ce.ExtensionLen) + (typesizes.Uint16Bytes * 2)
+	return full
+}
@@ -0,0 +1,79 @@
+package extensions
+
+import (
+	"errors"
+
+	"github.com/tls-handshake/internal/common"
+	typesizes "github.com/tls-handshake/pkg/type_sizes"
+)
+
+// EarlyDataExtension announces early data in a ClientHello and accepts it in EncryptedExtensions, both without content.
+// In a NewSessionTicket it holds the maximum amount of early data the client may send with the ticket, as defined in
+// RFC 8446, Section 4.2.10.
+type EarlyDataExtension struct {
+	Type             ExtensionType
+	ExtensionLen     uint16
+	Context          MsgContext
+	MaxEarlyDataSize uint32 // only in a NewSessionTicket
+}
+
+func ParseEarlyDataExtension(buf []byte, ctx MsgContext) (edext *EarlyDataExtension, err error) {
+	wi := 0 // write index
+	edext = &EarlyDataExtension{Context: ctx}
+
+	edext.Type, err = ParseExtensionType(buf)
+	if err != nil {
+		return nil, err
+	}
+	if edext.Type != EarlyDataType {
+		return nil, errors.New("not an early data extension type")
+	}
+	wi += int(typesizes.Uint16Bytes)
+
+	if len(buf[wi:]) < int(typesizes.Uint16Bytes) {
+		return nil, errors.New("early data extension has invalid format")
+	}
+	edext.ExtensionLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+	wi += int(typesizes.Uint16Bytes)
+
+	switch ctx {
+	case ClientHelloMsgContext, EncryptedExtensionsMsgContext:
+		if edext.ExtensionLen != 0 {
+			return nil, errors.New("early data extension has invalid extension length")
+		}
+	case NewSessionTicketMsgContext:
+		if edext.ExtensionLen != typesizes.Uint32Bytes || len(buf[wi:]) < typesizes.Uint32Bytes {
+			return nil, errors.New("early data extension has invalid max early data size")
+		}
+		edext.MaxEarlyDataSize = uint32(buf[wi])<<24 + uint32(buf[wi+1])<<16 + uint32(buf[wi+2])<<8 + uint32(buf[wi+3])
+		wi += typesizes.Uint32Bytes
+	default:
+		return nil, errors.New("early data extension is not allowed in this message")
+	}
+
+	// Final sanity check:
+	if wi != edext.GetFullExtLen() {
+		return nil, errors.New("early data extension has invalid extension length")
+	}
+
+	return edext, nil
+}
+
+func (ede *EarlyDataExtension) ToBinary() []byte {
+	common.AssertImpl(ede != nil)
+	raw := make([]byte, 0, ede.GetFullExtLen())
+	raw = append(raw, byte(ede.Type>>8), byte(ede.Type))
+	raw = append(raw, byte(ede.ExtensionLen>>8), byte(ede.ExtensionLen))
+	if ede.Context == NewSessionTicketMsgContext {
+		size := ede.MaxEarlyDataSize
+		raw = append(raw, byte(size>>24), byte(size>>16), byte(size>>8), byte(size))
+	}
+	return raw
+}
+
+func (ede *EarlyDataExtension) GetType() ExtensionType { return ede.Type }
+
+func (ede *EarlyDataExtension) GetFullExtLen() int {
+	full := int(ede.ExtensionLen) + (typesizes.Uint16Bytes * 2)
+	return full
+}
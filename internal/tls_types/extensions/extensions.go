@@ -2,27 +2,73 @@ package extensions
 
 import (
 	"errors"
+	"fmt"
 	"math"
 
-	"github.com/tls-handshake/internal/common"
 	typesizes "github.com/tls-handshake/pkg/type_sizes"
 )
 
 type ExtensionType uint16
 
 const (
-	NotSetType           ExtensionType = math.MaxUint16
-	KeyShareType         ExtensionType = 0x33
-	SupporteVersionsType ExtensionType = 0x2b
+	NotSetType              ExtensionType = math.MaxUint16
+	ServerNameType          ExtensionType = 0x00
+	SupportedGroupsType     ExtensionType = 0x0a
+	SignatureAlgorithmsType ExtensionType = 0x0d
+	ALPNType                ExtensionType = 0x10
+	KeyShareType            ExtensionType = 0x33
+	PreSharedKeyType        ExtensionType = 0x29
+	EarlyDataType           ExtensionType = 0x2a
+	SupporteVersionsType    ExtensionType = 0x2b
+	CookieType              ExtensionType = 0x2c
+	PskKeyExchangeModesType ExtensionType = 0x2d
 )
 
+// MsgContext is the handshake message which carries the extensions. The format of some extensions depends on it.
+type MsgContext uint8
+
+const (
+	ClientHelloMsgContext MsgContext = iota
+	ServerHelloMsgContext
+	HelloRetryRequestMsgContext
+	EncryptedExtensionsMsgContext
+	CertificateRequestMsgContext
+	NewSessionTicketMsgContext
+	CertificateMsgContext
+)
+
+var (
+	// DuplicateExtensionErr is returned when an extension block holds two extensions of the same type.
+	DuplicateExtensionErr = errors.New("duplicate extension")
+	// IllegalExtensionErr is returned when a known or registered extension is in a message it's not allowed in.
+	IllegalExtensionErr = errors.New("extension is not allowed in this message")
+)
+
+// allowedContexts lists the messages in which each known extension may appear, as defined in RFC 8446, Section 4.2.
+var allowedContexts = map[ExtensionType][]MsgContext{
+	ServerNameType:          {ClientHelloMsgContext, EncryptedExtensionsMsgContext},
+	SupportedGroupsType:     {ClientHelloMsgContext, EncryptedExtensionsMsgContext},
+	SignatureAlgorithmsType: {ClientHelloMsgContext, CertificateRequestMsgContext},
+	ALPNType:                {ClientHelloMsgContext, EncryptedExtensionsMsgContext},
+	KeyShareType:            {ClientHelloMsgContext, ServerHelloMsgContext, HelloRetryRequestMsgContext},
+	PreSharedKeyType:        {ClientHelloMsgContext, ServerHelloMsgContext},
+	EarlyDataType:           {ClientHelloMsgContext, EncryptedExtensionsMsgContext, NewSessionTicketMsgContext},
+	SupporteVersionsType:    {ClientHelloMsgContext, ServerHelloMsgContext, HelloRetryRequestMsgContext},
+	CookieType:              {ClientHelloMsgContext, HelloRetryRequestMsgContext},
+	PskKeyExchangeModesType: {ClientHelloMsgContext},
+}
+
 type Extension interface {
 	GetType() ExtensionType
 	ToBinary() []byte
 	GetFullExtLen() int
 }
 
-func ParseExtensions(buf []byte, byteLen uint16) (exts []Extension, err error) {
+// ParseExtensions parses the extension block of a message. Extensions added with RegisterExtension are parsed by their
+// registered function, those of unknown types are kept as RawExtension and it's up to the receiver to ignore or reject
+// them. Extensions which are not allowed in the message fail with IllegalExtensionErr and repeated types with
+// DuplicateExtensionErr.
+func ParseExtensions(buf []byte, byteLen uint16, ctx MsgContext) (exts []Extension, err error) {
 	var t ExtensionType
 	var ri int // read index
 	exts = make([]Extension, 0)
@@ -31,15 +77,44 @@ func ParseExtensions(buf []byte, byteLen uint16) (exts []Extension, err error) {
 		if err != nil {
 			return nil, err
 		}
+		if FindExtension(exts, t) != nil {
+			return nil, fmt.Errorf("%w of type %#04x", DuplicateExtensionErr, uint16(t))
+		}
+		if contexts, ok := allowedContexts[t]; ok && !containsContext(contexts, ctx) {
+			return nil, fmt.Errorf("%w: type %#04x", IllegalExtensionErr, uint16(t))
+		}
 
 		var ex Extension
 		switch t {
+		case ServerNameType:
+			ex, err = ParseServerNameExtension(buf[ri:], ctx)
+		case SupportedGroupsType:
+			ex, err = ParseSupportedGroupsExtension(buf[ri:])
+		case SignatureAlgorithmsType:
+			ex, err = ParseSignatureAlgorithmsExtension(buf[ri:])
 		case KeyShareType:
-			ex, err = ParseKeyShareExtension(buf[ri:])
+			ex, err = ParseKeyShareExtension(buf[ri:], ctx)
 		case SupporteVersionsType:
-			ex, err = ParseSupporteVersionsExtension(buf[ri:])
+			ex, err = ParseSupporteVersionsExtension(buf[ri:], ctx)
+		case CookieType:
+			ex, err = ParseCookieExtension(buf[ri:])
+		case PreSharedKeyType:
+			ex, err = ParsePreSharedKeyExtension(buf[ri:], ctx)
+		case ALPNType:
+			ex, err = ParseALPNExtension(buf[ri:], ctx)
+		case EarlyDataType:
+			ex, err = ParseEarlyDataExtension(buf[ri:], ctx)
+		case PskKeyExchangeModesType:
+			ex, err = ParsePskKeyExchangeModesExtension(buf[ri:])
 		default:
-			err = errors.New("unsupported extension")
+			if re, ok := lookupExtension(t); ok {
+				if !containsContext(re.contexts, ctx) {
+					return nil, fmt.Errorf("%w: type %#04x", IllegalExtensionErr, uint16(t))
+				}
+				ex, err = parseRegisteredExtension(re, buf[ri:], ctx)
+			} else {
+				ex, err = ParseRawExtension(buf[ri:])
+			}
 		}
 
 		if err != nil {
@@ -49,8 +124,10 @@ func ParseExtensions(buf []byte, byteLen uint16) (exts []Extension, err error) {
 		ri += ex.GetFullExtLen()
 	}
 
-	common.AssertImpl(ri == len(buf))
-	common.AssertImpl(ri == int(byteLen))
+	// Final sanity check:
+	if ri != len(buf) || ri != int(byteLen) {
+		return nil, errors.New("extensions have invalid length")
+	}
 
 	return exts, nil
 }
@@ -63,6 +140,15 @@ func ParseExtensionType(buf []byte) (ExtensionType, error) {
 	return t, nil
 }
 
+func containsContext(contexts []MsgContext, ctx MsgContext) bool {
+	for _, c := range contexts {
+		if c == ctx {
+			return true
+		}
+	}
+	return false
+}
+
 func FindExtension(exts []Extension, exType ExtensionType) (ext Extension) {
 	for i := 0; i < len(exts); i++ {
 		if exts[i].GetType() == exType {
@@ -1,6 +1,9 @@
 package extensions
 
 import (
+	"bytes"
+	"crypto/tls"
+	"errors"
 	"testing"
 )
 
@@ -11,7 +14,7 @@ func TestParseKeyShareExtension(t *testing.T) {
 		0xd0, 0xd2, 0xcd, 0x16, 0x62, 0x54,
 	}
 
-	share, err := ParseKeyShareExtension(buf)
+	share, err := ParseKeyShareExtension(buf, ClientHelloMsgContext)
 	if err != nil {
 		t.Fatalf("ParseKeyShareExtension is broken")
 	}
@@ -26,7 +29,7 @@ func TestParseKeyShareExtension(t *testing.T) {
 func TestParseSupporteVersionsExtension(t *testing.T) {
 	var buf []byte = []byte{0x00, 0x2b, 0x00, 0x03, 0x02, 0x03, 0x04}
 
-	sve, err := ParseSupporteVersionsExtension(buf)
+	sve, err := ParseSupporteVersionsExtension(buf, ClientHelloMsgContext)
 	if err != nil {
 		t.Fatalf("ParseSupporteVersionsExtension is broken")
 	}
@@ -36,6 +39,26 @@ func TestParseSupporteVersionsExtension(t *testing.T) {
 	if !v {
 		t.Fatalf("ParseSupporteVersionsExtension.ToBinary is broken")
 	}
+
+	// A list with a GREASE value, TLS 1.3 and TLS 1.2:
+	buf = []byte{0x00, 0x2b, 0x00, 0x07, 0x06, 0x7a, 0x7a, 0x03, 0x04, 0x03, 0x03}
+	sve, err = ParseSupporteVersionsExtension(buf, ClientHelloMsgContext)
+	if err != nil || len(sve.TLSVersions) != 3 || !sve.Supports(tls.VersionTLS13) || sve.Supports(tls.VersionTLS11) {
+		t.Fatalf("ParseSupporteVersionsExtension is broken for a list of versions")
+	}
+	if string(sve.ToBinary()) != string(buf) {
+		t.Fatalf("ParseSupporteVersionsExtension.ToBinary is broken for a list of versions")
+	}
+
+	// The selected version of a ServerHello:
+	buf = []byte{0x00, 0x2b, 0x00, 0x02, 0x03, 0x04}
+	sve, err = ParseSupporteVersionsExtension(buf, ServerHelloMsgContext)
+	if err != nil || sve.TLSVersion != tls.VersionTLS13 {
+		t.Fatalf("ParseSupporteVersionsExtension is broken for a server hello")
+	}
+	if string(sve.ToBinary()) != string(buf) {
+		t.Fatalf("ParseSupporteVersionsExtension.ToBinary is broken for a server hello")
+	}
 }
 
 func TestParseExtensions(t *testing.T) {
@@ -49,7 +72,7 @@ func TestParseExtensions(t *testing.T) {
 		0x00, 0x2b, 0x00, 0x03, 0x02, 0x03, 0x04,
 	}
 
-	exts, err := ParseExtensions(buf[:], uint16(len(buf)))
+	exts, err := ParseExtensions(buf[:], uint16(len(buf)), ClientHelloMsgContext)
 	if err != nil || len(exts) != 2 {
 		t.Fatalf("ParseExtensions is broken")
 	}
@@ -78,3 +101,350 @@ func TestParseExtensions(t *testing.T) {
 		t.Fatalf("ToBinary or GetFullExtLen is broken in SupporteVersions")
 	}
 }
+
+func TestParseKeyShareExtensionEntries(t *testing.T) {
+	var buf []byte = []byte{
+		0x00, 0x33, 0x00, 0x10, 0x00, 0x0e,
+		0x00, 0x1d, 0x00, 0x03, 0x01, 0x02, 0x03, // x25519 share
+		0x00, 0x17, 0x00, 0x03, 0x04, 0x05, 0x06, // secp256r1 share
+	}
+
+	share, err := ParseKeyShareExtension(buf, ClientHelloMsgContext)
+	if err != nil {
+		t.Fatalf("ParseKeyShareExtension is broken: %v", err)
+	}
+	if len(share.Entries) != 2 || share.Entries[0].CurveID != 0x1d || share.Entries[1].CurveID != 0x17 {
+		t.Fatalf("ParseKeyShareExtension parsed wrong entries %+v", share.Entries)
+	}
+	if share.FindEntry(0x17) != &share.Entries[1] || share.FindEntry(0x18) != nil {
+		t.Fatalf("FindEntry is broken")
+	}
+	if string(share.ToBinary()) != string(buf) {
+		t.Fatalf("ParseKeyShareExtension.ToBinary is broken")
+	}
+
+	// A client may send no key shares at all:
+	empty, err := ParseKeyShareExtension([]byte{0x00, 0x33, 0x00, 0x02, 0x00, 0x00}, ClientHelloMsgContext)
+	if err != nil || len(empty.Entries) != 0 {
+		t.Fatalf("ParseKeyShareExtension fails on empty client shares")
+	}
+
+	// The server share is a single entry without a list length:
+	serverBuf := []byte{0x00, 0x33, 0x00, 0x07, 0x00, 0x1d, 0x00, 0x03, 0x01, 0x02, 0x03}
+	serverShare, err := ParseKeyShareExtension(serverBuf, ServerHelloMsgContext)
+	if err != nil || len(serverShare.Entries) != 1 {
+		t.Fatalf("ParseKeyShareExtension is broken for a server share")
+	}
+	if string(serverShare.ToBinary()) != string(serverBuf) {
+		t.Fatalf("ParseKeyShareExtension.ToBinary is broken for a server share")
+	}
+	if _, err := ParseKeyShareExtension(buf, ServerHelloMsgContext); err == nil {
+		t.Fatalf("ParseKeyShareExtension accepts multiple server shares")
+	}
+
+	invalid := [][]byte{
+		{0x00, 0x33, 0x00, 0x10, 0x00, 0x0e, 0x00, 0x1d, 0x00, 0x03, 0x01, 0x02},             // truncated
+		{0x00, 0x33, 0x00, 0x07, 0x00, 0x05, 0x00, 0x1d, 0x00, 0x00, 0x00},                   // empty key
+		{0x00, 0x33, 0x00, 0x08, 0x00, 0x05, 0x00, 0x1d, 0x00, 0x01, 0x01, 0x00},             // trailing byte
+		{0x00, 0x33, 0x00, 0x09, 0x00, 0x07, 0x00, 0x1d, 0x00, 0x04, 0x01, 0x02, 0x03, 0x04}, // key overflows list
+	}
+	for i, b := range invalid {
+		if _, err := ParseKeyShareExtension(b, ClientHelloMsgContext); err == nil {
+			t.Fatalf("ParseKeyShareExtension accepts invalid input %d", i)
+		}
+	}
+}
+
+func TestParseSupportedGroupsExtension(t *testing.T) {
+	var buf []byte = []byte{0x00, 0x0a, 0x00, 0x06, 0x00, 0x04, 0x00, 0x1d, 0x00, 0x17}
+
+	sge, err := ParseSupportedGroupsExtension(buf)
+	if err != nil {
+		t.Fatalf("ParseSupportedGroupsExtension is broken")
+	}
+	if len(sge.Groups) != 2 || sge.Groups[0] != 0x1d || sge.Groups[1] != 0x17 {
+		t.Fatalf("ParseSupportedGroupsExtension parsed wrong groups %v", sge.Groups)
+	}
+	if string(sge.ToBinary()) != string(buf) {
+		t.Fatalf("ParseSupportedGroupsExtension.ToBinary is broken")
+	}
+
+	if _, err := ParseSupportedGroupsExtension([]byte{0x00, 0x0a, 0x00, 0x03, 0x00, 0x01, 0x00}); err == nil {
+		t.Fatalf("ParseSupportedGroupsExtension accepts odd groups length")
+	}
+	if _, err := ParseSupportedGroupsExtension([]byte{0x00, 0x0a, 0x00, 0x02, 0x00, 0x00}); err == nil {
+		t.Fatalf("ParseSupportedGroupsExtension accepts empty groups")
+	}
+}
+
+func TestParseCookieExtension(t *testing.T) {
+	var buf []byte = []byte{0x00, 0x2c, 0x00, 0x05, 0x00, 0x03, 0x0a, 0x0b, 0x0c}
+
+	ce, err := ParseCookieExtension(buf)
+	if err != nil {
+		t.Fatalf("ParseCookieExtension is broken")
+	}
+	if string(ce.Cookie) != string(buf[6:]) {
+		t.Fatalf("ParseCookieExtension parsed wrong cookie %x", ce.Cookie)
+	}
+	if string(ce.ToBinary()) != string(buf) {
+		t.Fatalf("ParseCookieExtension.ToBinary is broken")
+	}
+
+	if _, err := ParseCookieExtension([]byte{0x00, 0x2c, 0x00, 0x02, 0x00, 0x00}); err == nil {
+		t.Fatalf("ParseCookieExtension accepts an empty cookie")
+	}
+	if _, err := ParseCookieExtension([]byte{0x00, 0x2c, 0x00, 0x05, 0x00, 0x04, 0x0a, 0x0b, 0x0c}); err == nil {
+		t.Fatalf("ParseCookieExtension accepts a truncated cookie")
+	}
+}
+
+func TestParseSignatureAlgorithmsExtension(t *testing.T) {
+	var buf []byte = []byte{0x00, 0x0d, 0x00, 0x06, 0x00, 0x04, 0x04, 0x03, 0x08, 0x04}
+
+	sae, err := ParseSignatureAlgorithmsExtension(buf)
+	if err != nil {
+		t.Fatalf("ParseSignatureAlgorithmsExtension is broken")
+	}
+	if len(sae.Schemes) != 2 || !sae.Contains(tls.ECDSAWithP256AndSHA256) || !sae.Contains(tls.PSSWithSHA256) {
+		t.Fatalf("ParseSignatureAlgorithmsExtension parsed wrong schemes %v", sae.Schemes)
+	}
+	if string(sae.ToBinary()) != string(buf) {
+		t.Fatalf("ParseSignatureAlgorithmsExtension.ToBinary is broken")
+	}
+
+	if _, err := ParseSignatureAlgorithmsExtension([]byte{0x00, 0x0d, 0x00, 0x03, 0x00, 0x01, 0x04}); err == nil {
+		t.Fatalf("ParseSignatureAlgorithmsExtension accepts an odd schemes length")
+	}
+}
+
+func TestParsePreSharedKeyExtension(t *testing.T) {
+	binder := bytes.Repeat([]byte{0x42}, 32)
+	var buf []byte = []byte{
+		0x00, 0x29, 0x00, 0x2e, // type and extension length
+		0x00, 0x09, // identities length
+		0x00, 0x03, 0xaa, 0xbb, 0xcc, 0x00, 0x00, 0x01, 0x00, // identity with obfuscated ticket age 256
+		0x00, 0x21, 0x20, // binders length and binder length
+	}
+	buf = append(buf, binder...)
+
+	pske, err := ParsePreSharedKeyExtension(buf, ClientHelloMsgContext)
+	if err != nil {
+		t.Fatalf("ParsePreSharedKeyExtension is broken: %v", err)
+	}
+	if len(pske.Identities) != 1 || pske.Identities[0].ObfuscatedTicketAge != 256 || len(pske.Binders) != 1 {
+		t.Fatalf("ParsePreSharedKeyExtension parsed wrong values %+v", pske)
+	}
+	if pske.BindersFullLen() != 2+1+len(binder) {
+		t.Fatalf("BindersFullLen is broken")
+	}
+	if string(pske.ToBinary()) != string(buf) {
+		t.Fatalf("ParsePreSharedKeyExtension.ToBinary is broken")
+	}
+	if _, err := ParsePreSharedKeyExtension(buf[:len(buf)-1], ClientHelloMsgContext); err == nil {
+		t.Fatalf("ParsePreSharedKeyExtension accepts a truncated binder")
+	}
+
+	buf = []byte{0x00, 0x29, 0x00, 0x02, 0x00, 0x00}
+	pske, err = ParsePreSharedKeyExtension(buf, ServerHelloMsgContext)
+	if err != nil || pske.SelectedIdentity != 0 {
+		t.Fatalf("ParsePreSharedKeyExtension is broken for a server hello")
+	}
+	if string(pske.ToBinary()) != string(buf) {
+		t.Fatalf("ParsePreSharedKeyExtension.ToBinary is broken for a server hello")
+	}
+}
+
+func TestParsePskKeyExchangeModesExtension(t *testing.T) {
+	var buf []byte = []byte{0x00, 0x2d, 0x00, 0x02, 0x01, 0x01}
+
+	pke, err := ParsePskKeyExchangeModesExtension(buf)
+	if err != nil {
+		t.Fatalf("ParsePskKeyExchangeModesExtension is broken")
+	}
+	if !pke.Contains(PskDheKeMode) || pke.Contains(PskKeMode) {
+		t.Fatalf("ParsePskKeyExchangeModesExtension parsed wrong modes %v", pke.Modes)
+	}
+	if string(pke.ToBinary()) != string(buf) {
+		t.Fatalf("ParsePskKeyExchangeModesExtension.ToBinary is broken")
+	}
+	if _, err := ParsePskKeyExchangeModesExtension([]byte{0x00, 0x2d, 0x00, 0x01, 0x00}); err == nil {
+		t.Fatalf("ParsePskKeyExchangeModesExtension accepts an empty modes list")
+	}
+}
+
+func TestParseEarlyDataExtension(t *testing.T) {
+	var buf []byte = []byte{0x00, 0x2a, 0x00, 0x04, 0x00, 0x00, 0x40, 0x00}
+
+	ede, err := ParseEarlyDataExtension(buf, NewSessionTicketMsgContext)
+	if err != nil {
+		t.Fatalf("ParseEarlyDataExtension is broken")
+	}
+	if ede.MaxEarlyDataSize != 0x4000 {
+		t.Fatalf("ParseEarlyDataExtension parsed wrong max early data size %d", ede.MaxEarlyDataSize)
+	}
+	if string(ede.ToBinary()) != string(buf) {
+		t.Fatalf("ParseEarlyDataExtension.ToBinary is broken")
+	}
+
+	buf = []byte{0x00, 0x2a, 0x00, 0x00}
+	ede, err = ParseEarlyDataExtension(buf, ClientHelloMsgContext)
+	if err != nil || string(ede.ToBinary()) != string(buf) {
+		t.Fatalf("ParseEarlyDataExtension is broken for an empty extension")
+	}
+	if _, err := ParseEarlyDataExtension(buf, NewSessionTicketMsgContext); err == nil {
+		t.Fatalf("ParseEarlyDataExtension accepts a ticket extension without a max early data size")
+	}
+	if _, err := ParseEarlyDataExtension(buf, ServerHelloMsgContext); err == nil {
+		t.Fatalf("ParseEarlyDataExtension accepts the extension in a ServerHello")
+	}
+}
+
+func TestParseALPNExtension(t *testing.T) {
+	var buf []byte = []byte{
+		0x00, 0x10, 0x00, 0x0e, // type and extension length
+		0x00, 0x0c, // protocols length
+		0x02, 0x68, 0x32, // "h2"
+		0x08, 0x68, 0x74, 0x74, 0x70, 0x2f, 0x31, 0x2e, 0x31, // "http/1.1"
+	}
+
+	alpne, err := ParseALPNExtension(buf, ClientHelloMsgContext)
+	if err != nil {
+		t.Fatalf("ParseALPNExtension is broken")
+	}
+	if len(alpne.Protocols) != 2 || alpne.Protocols[0] != "h2" || alpne.Protocols[1] != "http/1.1" {
+		t.Fatalf("ParseALPNExtension parsed wrong protocols %q", alpne.Protocols)
+	}
+	if string(alpne.ToBinary()) != string(buf) {
+		t.Fatalf("ParseALPNExtension.ToBinary is broken")
+	}
+	if _, err := ParseALPNExtension(buf, EncryptedExtensionsMsgContext); err == nil {
+		t.Fatalf("ParseALPNExtension accepts several selected protocols")
+	}
+	if _, err := ParseALPNExtension([]byte{0x00, 0x10, 0x00, 0x03, 0x00, 0x01, 0x00}, ClientHelloMsgContext); err == nil {
+		t.Fatalf("ParseALPNExtension accepts an empty protocol name")
+	}
+}
+
+func TestParseServerNameExtension(t *testing.T) {
+	var buf []byte = []byte{
+		0x00, 0x00, 0x00, 0x10, // type and extension length
+		0x00, 0x0e, // server name list length
+		0x00, 0x00, 0x0b, // host name type and length
+		0x65, 0x78, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x2e, 0x63, 0x6f, 0x6d, // "example.com"
+	}
+
+	sne, err := ParseServerNameExtension(buf, ClientHelloMsgContext)
+	if err != nil {
+		t.Fatalf("ParseServerNameExtension is broken")
+	}
+	if sne.HostName() != "example.com" {
+		t.Fatalf("ParseServerNameExtension parsed wrong host name %q", sne.HostName())
+	}
+	if string(sne.ToBinary()) != string(buf) {
+		t.Fatalf("ParseServerNameExtension.ToBinary is broken")
+	}
+	if _, err := ParseServerNameExtension(buf, EncryptedExtensionsMsgContext); err == nil {
+		t.Fatalf("ParseServerNameExtension accepts a server name in encrypted extensions")
+	}
+	if _, err := ParseServerNameExtension([]byte{0x00, 0x00, 0x00, 0x00}, EncryptedExtensionsMsgContext); err != nil {
+		t.Fatalf("ParseServerNameExtension rejects an empty acknowledgement")
+	}
+	twice := []byte{0x00, 0x00, 0x00, 0x0a, 0x00, 0x08, 0x00, 0x00, 0x01, 0x61, 0x00, 0x00, 0x01, 0x62}
+	if _, err := ParseServerNameExtension(twice, ClientHelloMsgContext); err == nil {
+		t.Fatalf("ParseServerNameExtension accepts two host names")
+	}
+}
+
+func TestParseRawExtension(t *testing.T) {
+	var buf []byte = []byte{
+		0x0a, 0x0a, 0x00, 0x01, 0x00, // GREASE
+		0x00, 0x15, 0x00, 0x03, 0x00, 0x00, 0x00, // padding
+	}
+
+	exts, err := ParseExtensions(buf, uint16(len(buf)), ClientHelloMsgContext)
+	if err != nil || len(exts) != 2 {
+		t.Fatalf("ParseExtensions fails on unknown extensions")
+	}
+	grease, ok := exts[0].(*RawExtension)
+	if !ok || grease.GetType() != 0x0a0a || len(grease.Data) != 1 {
+		t.Fatalf("ParseExtensions parsed wrong raw extension")
+	}
+	if string(append(exts[0].ToBinary(), exts[1].ToBinary()...)) != string(buf) {
+		t.Fatalf("RawExtension.ToBinary is broken")
+	}
+	if _, err := ParseRawExtension([]byte{0x00, 0x15, 0x00, 0x03, 0x00}); err == nil {
+		t.Fatalf("ParseRawExtension accepts a truncated extension")
+	}
+}
+
+func TestParseExtensionsRejectsDuplicates(t *testing.T) {
+	var buf []byte = []byte{
+		0x00, 0x2b, 0x00, 0x03, 0x02, 0x03, 0x04,
+		0x00, 0x2b, 0x00, 0x03, 0x02, 0x03, 0x04,
+	}
+	if _, err := ParseExtensions(buf, uint16(len(buf)), ClientHelloMsgContext); !errors.Is(err, DuplicateExtensionErr) {
+		t.Fatalf("ParseExtensions accepts duplicate extensions: %v", err)
+	}
+
+	buf = []byte{0x0a, 0x0a, 0x00, 0x00, 0x0a, 0x0a, 0x00, 0x00}
+	if _, err := ParseExtensions(buf, uint16(len(buf)), ClientHelloMsgContext); !errors.Is(err, DuplicateExtensionErr) {
+		t.Fatalf("ParseExtensions accepts duplicate unknown extensions: %v", err)
+	}
+}
+
+func TestParseExtensionsRejectsIllegalExtensions(t *testing.T) {
+	var buf []byte = []byte{0x00, 0x2d, 0x00, 0x02, 0x01, 0x01} // psk_key_exchange_modes
+	if _, err := ParseExtensions(buf, uint16(len(buf)), ClientHelloMsgContext); err != nil {
+		t.Fatalf("ParseExtensions rejects psk key exchange modes in a client hello: %v", err)
+	}
+	ctxs := []MsgContext{ServerHelloMsgContext, EncryptedExtensionsMsgContext, NewSessionTicketMsgContext}
+	for _, ctx := range ctxs {
+		if _, err := ParseExtensions(buf, uint16(len(buf)), ctx); !errors.Is(err, IllegalExtensionErr) {
+			t.Fatalf("ParseExtensions accepts psk key exchange modes in context %d: %v", ctx, err)
+		}
+	}
+}
+
+func TestParseExtensionsRejectsInvalidLength(t *testing.T) {
+	var buf []byte = []byte{0x00, 0x2b, 0x00, 0x03, 0x02, 0x03, 0x04}
+	// The extension runs past the length of the extensions block:
+	if _, err := ParseExtensions(buf, uint16(len(buf)-2), ClientHelloMsgContext); err == nil {
+		t.Fatalf("ParseExtensions accepts an extension which exceeds the extensions length")
+	}
+	// The extensions block is shorter than its length, or has bytes after the last extension:
+	if _, err := ParseExtensions(buf, uint16(len(buf)+1), ClientHelloMsgContext); err == nil {
+		t.Fatalf("ParseExtensions accepts a truncated extensions block")
+	}
+	if _, err := ParseExtensions(append(buf, 0x00), uint16(len(buf)), ClientHelloMsgContext); err == nil {
+		t.Fatalf("ParseExtensions accepts trailing bytes")
+	}
+}
+
+func TestRegisterExtension(t *testing.T) {
+	const tenantType ExtensionType = 0xff01
+	parse := func(buf []byte, ctx MsgContext) (Extension, error) {
+		return ParseRawExtension(buf)
+	}
+	if err := RegisterExtension(tenantType, parse, ClientHelloMsgContext, EncryptedExtensionsMsgContext); err != nil {
+		t.Fatalf("RegisterExtension is broken: %v", err)
+	}
+	if err := RegisterExtension(tenantType, parse, ClientHelloMsgContext); err == nil {
+		t.Fatalf("RegisterExtension registers a type twice")
+	}
+	if err := RegisterExtension(KeyShareType, parse, ClientHelloMsgContext); err == nil {
+		t.Fatalf("RegisterExtension replaces an implemented type")
+	}
+	if !IsRegistered(tenantType) || IsRegistered(KeyShareType) {
+		t.Fatalf("IsRegistered is broken")
+	}
+
+	var buf []byte = []byte{0xff, 0x01, 0x00, 0x02, 0x00, 0x2a}
+	exts, err := ParseExtensions(buf, uint16(len(buf)), EncryptedExtensionsMsgContext)
+	if err != nil || len(exts) != 1 || exts[0].GetType() != tenantType {
+		t.Fatalf("ParseExtensions fails on a registered extension: %v", err)
+	}
+	if _, err := ParseExtensions(buf, uint16(len(buf)), ServerHelloMsgContext); !errors.Is(err, IllegalExtensionErr) {
+		t.Fatalf("ParseExtensions accepts a registered extension in another message: %v", err)
+	}
+}
@@ -8,18 +8,26 @@ import (
 	typesizes "github.com/tls-handshake/pkg/type_sizes"
 )
 
+type KeyShareEntry struct {
+	CurveID        tls.CurveID
+	PubKeyBytesLen uint16
+	PublicKey      []byte
+}
+
+// KeyShareExtension holds a list of key shares in a ClientHello, exactly one key share in a ServerHello and only the
+// selected group in a HelloRetryRequest, as defined in RFC 8446, Section 4.2.8.
 type KeyShareExtension struct {
 	Type            ExtensionType
-	ExtensionLen    uint16 // ignore these
-	KeyShareDataLen uint16 // ignore these
-	CurveID         tls.CurveID
-	PubKeyBytesLen  uint16
-	PublicKey       []byte
+	ExtensionLen    uint16
+	Context         MsgContext
+	KeyShareDataLen uint16 // length of the client_shares list, not present in a ServerHello
+	Entries         []KeyShareEntry
+	SelectedGroup   tls.CurveID // only in a HelloRetryRequest
 }
 
-func ParseKeyShareExtension(buf []byte) (ksext *KeyShareExtension, err error) {
+func ParseKeyShareExtension(buf []byte, ctx MsgContext) (ksext *KeyShareExtension, err error) {
 	wi := 0 // write index
-	ksext = &KeyShareExtension{}
+	ksext = &KeyShareExtension{Context: ctx}
 
 	ksext.Type, err = ParseExtensionType(buf)
 	if err != nil {
@@ -36,39 +44,88 @@ func ParseKeyShareExtension(buf []byte) (ksext *KeyShareExtension, err error) {
 	ksext.ExtensionLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
 	wi += int(typesizes.Uint16Bytes)
 
-	if len(buf[wi:]) < int(typesizes.Uint16Bytes) {
-		return nil, errors.New("key share extension has invalid format")
+	if len(buf[wi:]) < int(ksext.ExtensionLen) {
+		return nil, errors.New("key share extension has invalid extension length")
+	}
+	end := wi + int(ksext.ExtensionLen)
+
+	switch ctx {
+	case ClientHelloMsgContext:
+		if end-wi < int(typesizes.Uint16Bytes) {
+			return nil, errors.New("key share extension has invalid format")
+		}
+		ksext.KeyShareDataLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+		wi += int(typesizes.Uint16Bytes)
+		if wi+int(ksext.KeyShareDataLen) != end {
+			return nil, errors.New("key share extension has invalid client shares length")
+		}
+
+		ksext.Entries = make([]KeyShareEntry, 0)
+		for wi < end {
+			entry, n, err := parseKeyShareEntry(buf[wi:end])
+			if err != nil {
+				return nil, err
+			}
+			ksext.Entries = append(ksext.Entries, entry)
+			wi += n
+		}
+	case ServerHelloMsgContext:
+		entry, n, err := parseKeyShareEntry(buf[wi:end])
+		if err != nil {
+			return nil, err
+		}
+		ksext.Entries = []KeyShareEntry{entry}
+		wi += n
+	case HelloRetryRequestMsgContext:
+		if end-wi != int(typesizes.Uint16Bytes) {
+			return nil, errors.New("key share extension has invalid selected group")
+		}
+		ksext.SelectedGroup = (tls.CurveID(buf[wi]) << 8) + tls.CurveID(buf[wi+1])
+		wi += int(typesizes.Uint16Bytes)
+	default:
+		return nil, errors.New("key share extension is not allowed in this message")
+	}
+
+	// Final sanity check:
+	if wi != ksext.GetFullExtLen() {
+		return nil, errors.New("key share extension has invalid extension length")
 	}
-	ksext.KeyShareDataLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
-	wi += int(typesizes.Uint16Bytes)
+
+	return ksext, nil
+}
+
+func parseKeyShareEntry(buf []byte) (entry KeyShareEntry, n int, err error) {
+	wi := 0 // write index
 
 	if len(buf[wi:]) < int(typesizes.Uint16Bytes) {
-		return nil, errors.New("key share extension has invalid format")
+		return entry, 0, errors.New("key share entry has invalid format")
 	}
-	ksext.CurveID = (tls.CurveID(buf[wi]) << 8) + tls.CurveID(buf[wi+1])
+	entry.CurveID = (tls.CurveID(buf[wi]) << 8) + tls.CurveID(buf[wi+1])
 	wi += int(typesizes.Uint16Bytes)
 
 	if len(buf[wi:]) < int(typesizes.Uint16Bytes) {
-		return nil, errors.New("key share extension has invalid format")
+		return entry, 0, errors.New("key share entry has invalid format")
 	}
-	ksext.PubKeyBytesLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+	entry.PubKeyBytesLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
 	wi += int(typesizes.Uint16Bytes)
 
-	if len(buf[wi:]) < int(ksext.PubKeyBytesLen) {
-		return nil, errors.New("key share extension invalid pub key length")
+	if entry.PubKeyBytesLen == 0 || len(buf[wi:]) < int(entry.PubKeyBytesLen) {
+		return entry, 0, errors.New("key share entry invalid pub key length")
 	}
-	ksext.PublicKey = make([]byte, ksext.PubKeyBytesLen)
-	wi += copy(ksext.PublicKey[:], buf[wi:])
+	entry.PublicKey = make([]byte, entry.PubKeyBytesLen)
+	wi += copy(entry.PublicKey[:], buf[wi:])
 
-	// Final sanity check:
-	if wi != (int(ksext.PubKeyBytesLen) + int(typesizes.Uint16Bytes)*5) {
-		return nil, errors.New("key share extension has invalid public key length")
-	}
-	if wi != ksext.GetFullExtLen() {
-		return nil, errors.New("key share extension has invalid extension length")
-	}
+	return entry, wi, nil
+}
 
-	return ksext, nil
+// FindEntry returns the key share for the given group or nil.
+func (kse *KeyShareExtension) FindEntry(curveID tls.CurveID) *KeyShareEntry {
+	for i := range kse.Entries {
+		if kse.Entries[i].CurveID == curveID {
+			return &kse.Entries[i]
+		}
+	}
+	return nil
 }
 
 func (kse *KeyShareExtension) ToBinary() []byte {
@@ -76,10 +133,20 @@ func (kse *KeyShareExtension) ToBinary() []byte {
 	raw := make([]byte, 0, kse.GetFullExtLen())
 	raw = append(raw, byte(kse.Type>>8), byte(kse.Type))
 	raw = append(raw, byte(kse.ExtensionLen>>8), byte(kse.ExtensionLen))
-	raw = append(raw, byte(kse.KeyShareDataLen>>8), byte(kse.KeyShareDataLen))
-	raw = append(raw, byte(kse.CurveID>>8), byte(kse.CurveID))
-	raw = append(raw, byte(kse.PubKeyBytesLen>>8), byte(kse.PubKeyBytesLen))
-	raw = append(raw, kse.PublicKey[:]...)
+	switch kse.Context {
+	case HelloRetryRequestMsgContext:
+		common.AssertImpl(len(kse.Entries) == 0)
+		return append(raw, byte(kse.SelectedGroup>>8), byte(kse.SelectedGroup))
+	case ServerHelloMsgContext:
+		common.AssertImpl(len(kse.Entries) == 1)
+	default:
+		raw = append(raw, byte(kse.KeyShareDataLen>>8), byte(kse.KeyShareDataLen))
+	}
+	for _, e := range kse.Entries {
+		raw = append(raw, byte(e.CurveID>>8), byte(e.CurveID))
+		raw = append(raw, byte(e.PubKeyBytesLen>>8), byte(e.PubKeyBytesLen))
+		raw = append(raw, e.PublicKey[:]...)
+	}
 	return raw
 }
 
@@ -0,0 +1,190 @@
+package extensions
+
+import (
+	"errors"
+
+	"github.com/tls-handshake/internal/common"
+	typesizes "github.com/tls-handshake/pkg/type_sizes"
+)
+
+const minPskBinderLen = 32
+
+type PskIdentity struct {
+	IdentityLen         uint16
+	Identity            []byte // a session ticket
+	ObfuscatedTicketAge uint32 // age of the ticket in milliseconds plus the ticket_age_add of the ticket
+}
+
+type PskBinderEntry struct {
+	BinderLen uint8
+	Binder    []byte
+}
+
+// PreSharedKeyExtension holds the offered PSK identities and their binders in a ClientHello and the index of the
+// selected identity in a ServerHello, as defined in RFC 8446, Section 4.2.11. It must be the last extension of a
+// ClientHello.
+type PreSharedKeyExtension struct {
+	Type             ExtensionType
+	ExtensionLen     uint16
+	Context          MsgContext
+	IdentitiesLen    uint16 // only in a ClientHello
+	Identities       []PskIdentity
+	BindersLen       uint16 // only in a ClientHello
+	Binders          []PskBinderEntry
+	SelectedIdentity uint16 // only in a ServerHello
+}
+
+func ParsePreSharedKeyExtension(buf []byte, ctx MsgContext) (pskext *PreSharedKeyExtension, err error) {
+	wi := 0 // write index
+	pskext = &PreSharedKeyExtension{Context: ctx}
+
+	pskext.Type, err = ParseExtensionType(buf)
+	if err != nil {
+		return nil, err
+	}
+	if pskext.Type != PreSharedKeyType {
+		return nil, errors.New("not a pre shared key extension type")
+	}
+	wi += int(typesizes.Uint16Bytes)
+
+	if len(buf[wi:]) < int(typesizes.Uint16Bytes) {
+		return nil, errors.New("pre shared key extension has invalid format")
+	}
+	pskext.ExtensionLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+	wi += int(typesizes.Uint16Bytes)
+
+	if len(buf[wi:]) < int(pskext.ExtensionLen) {
+		return nil, errors.New("pre shared key extension has invalid extension length")
+	}
+	end := wi + int(pskext.ExtensionLen)
+
+	switch ctx {
+	case ClientHelloMsgContext:
+		n, err := pskext.parseIdentities(buf[wi:end])
+		if err != nil {
+			return nil, err
+		}
+		wi += n
+		n, err = pskext.parseBinders(buf[wi:end])
+		if err != nil {
+			return nil, err
+		}
+		wi += n
+		if len(pskext.Binders) != len(pskext.Identities) {
+			return nil, errors.New("pre shared key extension has a binder count which does not match the identities")
+		}
+	case ServerHelloMsgContext:
+		if end-wi != int(typesizes.Uint16Bytes) {
+			return nil, errors.New("pre shared key extension has invalid selected identity")
+		}
+		pskext.SelectedIdentity = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+		wi += int(typesizes.Uint16Bytes)
+	default:
+		return nil, errors.New("pre shared key extension is not allowed in this message")
+	}
+
+	// Final sanity check:
+	if wi != pskext.GetFullExtLen() {
+		return nil, errors.New("pre shared key extension has invalid extension length")
+	}
+
+	return pskext, nil
+}
+
+func (pske *PreSharedKeyExtension) parseIdentities(buf []byte) (n int, err error) {
+	wi := 0 // write index
+
+	if len(buf[wi:]) < int(typesizes.Uint16Bytes) {
+		return 0, errors.New("pre shared key extension has invalid format")
+	}
+	pske.IdentitiesLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+	wi += int(typesizes.Uint16Bytes)
+	if pske.IdentitiesLen == 0 || len(buf[wi:]) < int(pske.IdentitiesLen) {
+		return 0, errors.New("pre shared key extension has invalid identities length")
+	}
+	end := wi + int(pske.IdentitiesLen)
+
+	pske.Identities = make([]PskIdentity, 0)
+	for wi < end {
+		var identity PskIdentity
+		if end-wi < int(typesizes.Uint16Bytes) {
+			return 0, errors.New("psk identity has invalid format")
+		}
+		identity.IdentityLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+		wi += int(typesizes.Uint16Bytes)
+		if identity.IdentityLen == 0 || end-wi < int(identity.IdentityLen)+typesizes.Uint32Bytes {
+			return 0, errors.New("psk identity has invalid identity length")
+		}
+		identity.Identity = make([]byte, identity.IdentityLen)
+		wi += copy(identity.Identity[:], buf[wi:])
+		identity.ObfuscatedTicketAge = uint32(buf[wi])<<24 + uint32(buf[wi+1])<<16 + uint32(buf[wi+2])<<8 + uint32(buf[wi+3])
+		wi += typesizes.Uint32Bytes
+		pske.Identities = append(pske.Identities, identity)
+	}
+	return wi, nil
+}
+
+func (pske *PreSharedKeyExtension) parseBinders(buf []byte) (n int, err error) {
+	wi := 0 // write index
+
+	if len(buf[wi:]) < int(typesizes.Uint16Bytes) {
+		return 0, errors.New("pre shared key extension has invalid format")
+	}
+	pske.BindersLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+	wi += int(typesizes.Uint16Bytes)
+	if pske.BindersLen == 0 || len(buf[wi:]) < int(pske.BindersLen) {
+		return 0, errors.New("pre shared key extension has invalid binders length")
+	}
+	end := wi + int(pske.BindersLen)
+
+	pske.Binders = make([]PskBinderEntry, 0)
+	for wi < end {
+		var binder PskBinderEntry
+		binder.BinderLen = uint8(buf[wi])
+		wi += typesizes.Uint8Bytes
+		if binder.BinderLen < minPskBinderLen || end-wi < int(binder.BinderLen) {
+			return 0, errors.New("psk binder has invalid length")
+		}
+		binder.Binder = make([]byte, binder.BinderLen)
+		wi += copy(binder.Binder[:], buf[wi:])
+		pske.Binders = append(pske.Binders, binder)
+	}
+	return wi, nil
+}
+
+// BindersFullLen is the length of the encoded binders list. The binders are computed over the ClientHello without
+// it, as defined in RFC 8446, Section 4.2.11.2.
+func (pske *PreSharedKeyExtension) BindersFullLen() int {
+	return int(pske.BindersLen) + typesizes.Uint16Bytes
+}
+
+func (pske *PreSharedKeyExtension) ToBinary() []byte {
+	common.AssertImpl(pske != nil)
+	raw := make([]byte, 0, pske.GetFullExtLen())
+	raw = append(raw, byte(pske.Type>>8), byte(pske.Type))
+	raw = append(raw, byte(pske.ExtensionLen>>8), byte(pske.ExtensionLen))
+	if pske.Context == ServerHelloMsgContext {
+		common.AssertImpl(len(pske.Identities) == 0)
+		return append(raw, byte(pske.SelectedIdentity>>8), byte(pske.SelectedIdentity))
+	}
+	raw = append(raw, byte(pske.IdentitiesLen>>8), byte(pske.IdentitiesLen))
+	for _, id := range pske.Identities {
+		raw = append(raw, byte(id.IdentityLen>>8), byte(id.IdentityLen))
+		raw = append(raw, id.Identity[:]...)
+		age := id.ObfuscatedTicketAge
+		raw = append(raw, byte(age>>24), byte(age>>16), byte(age>>8), byte(age))
+	}
+	raw = append(raw, byte(pske.BindersLen>>8), byte(pske.BindersLen))
+	for _, b := range pske.Binders {
+		raw = append(raw, b.BinderLen)
+		raw = append(raw, b.Binder[:]...)
+	}
+	return raw
+}
+
+func (pske *PreSharedKeyExtension) GetType() ExtensionType { return pske.Type }
+
+func (pske *PreSharedKeyExtension) GetFullExtLen() int {
+	full := int(pske.ExtensionLen) + (typesizes.Uint16Bytes * 2)
+	return full
+}
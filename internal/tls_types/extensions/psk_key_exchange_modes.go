@@ -0,0 +1,95 @@
+package extensions
+
+import (
+	"errors"
+
+	"github.com/tls-handshake/internal/common"
+	typesizes "github.com/tls-handshake/pkg/type_sizes"
+)
+
+type PskKeyExchangeMode uint8
+
+const (
+	PskKeMode    PskKeyExchangeMode = 0 // PSK-only key establishment
+	PskDheKeMode PskKeyExchangeMode = 1 // PSK with (EC)DHE key establishment
+)
+
+// PskKeyExchangeModesExtension lists the modes in which the client can use a PSK, as defined in RFC 8446,
+// Section 4.2.9.
+type PskKeyExchangeModesExtension struct {
+	Type         ExtensionType
+	ExtensionLen uint16
+	ModesLen     uint8
+	Modes        []PskKeyExchangeMode
+}
+
+func ParsePskKeyExchangeModesExtension(buf []byte) (pkext *PskKeyExchangeModesExtension, err error) {
+	wi := 0 // write index
+	pkext = &PskKeyExchangeModesExtension{}
+
+	pkext.Type, err = ParseExtensionType(buf)
+	if err != nil {
+		return nil, err
+	}
+	if pkext.Type != PskKeyExchangeModesType {
+		return nil, errors.New("not a psk key exchange modes extension type")
+	}
+	wi += int(typesizes.Uint16Bytes)
+
+	if len(buf[wi:]) < int(typesizes.Uint16Bytes) {
+		return nil, errors.New("psk key exchange modes extension has invalid format")
+	}
+	pkext.ExtensionLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+	wi += int(typesizes.Uint16Bytes)
+
+	if len(buf[wi:]) < int(typesizes.Uint8Bytes) {
+		return nil, errors.New("psk key exchange modes extension has invalid format")
+	}
+	pkext.ModesLen = uint8(buf[wi])
+	wi += int(typesizes.Uint8Bytes)
+
+	if pkext.ModesLen == 0 || len(buf[wi:]) < int(pkext.ModesLen) {
+		return nil, errors.New("psk key exchange modes extension has invalid modes length")
+	}
+	pkext.Modes = make([]PskKeyExchangeMode, 0, pkext.ModesLen)
+	for i := 0; i < int(pkext.ModesLen); i++ {
+		pkext.Modes = append(pkext.Modes, PskKeyExchangeMode(buf[wi+i]))
+	}
+	wi += int(pkext.ModesLen)
+
+	// Final sanity check:
+	if wi != pkext.GetFullExtLen() {
+		return nil, errors.New("psk key exchange modes extension has invalid extension length")
+	}
+
+	return pkext, nil
+}
+
+// Contains reports whether mode is one of the listed modes.
+func (pke *PskKeyExchangeModesExtension) Contains(mode PskKeyExchangeMode) bool {
+	for _, m := range pke.Modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+func (pke *PskKeyExchangeModesExtension) ToBinary() []byte {
+	common.AssertImpl(pke != nil)
+	raw := make([]byte, 0, pke.GetFullExtLen())
+	raw = append(raw, byte(pke.Type>>8), byte(pke.Type))
+	raw = append(raw, byte(pke.ExtensionLen>>8), byte(pke.ExtensionLen))
+	raw = append(raw, pke.ModesLen)
+	for _, m := range pke.Modes {
+		raw = append(raw, byte(m))
+	}
+	return raw
+}
+
+func (pke *PskKeyExchangeModesExtension) GetType() ExtensionType { return pke.Type }
+
+func (pke *PskKeyExchangeModesExtension) GetFullExtLen() int {
+	full := int(pke.ExtensionLen) + (typesizes.Uint16Bytes * 2)
+	return full
+}
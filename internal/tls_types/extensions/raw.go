@@ -0,0 +1,62 @@
+package extensions
+
+import (
+	"errors"
+
+	"github.com/tls-handshake/internal/common"
+	typesizes "github.com/tls-handshake/pkg/type_sizes"
+)
+
+// RawExtension is an extension of a type which is not implemented, e.g. GREASE or padding. Its body is kept as it is,
+// so it can be written back unchanged.
+type RawExtension struct {
+	Type         ExtensionType
+	ExtensionLen uint16
+	Data         []byte
+}
+
+func ParseRawExtension(buf []byte) (rawext *RawExtension, err error) {
+	wi := 0 // write index
+	rawext = &RawExtension{}
+
+	rawext.Type, err = ParseExtensionType(buf)
+	if err != nil {
+		return nil, err
+	}
+	wi += int(typesizes.Uint16Bytes)
+
+	if len(buf[wi:]) < int(typesizes.Uint16Bytes) {
+		return nil, errors.New("extension has invalid format")
+	}
+	rawext.ExtensionLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+	wi += int(typesizes.Uint16Bytes)
+
+	if len(buf[wi:]) < int(rawext.ExtensionLen) {
+		return nil, errors.New("extension has invalid extension length")
+	}
+	rawext.Data = buf[wi : wi+int(rawext.ExtensionLen)]
+	wi += int(rawext.ExtensionLen)
+
+	// Final sanity check:
+	if wi != rawext.GetFullExtLen() {
+		return nil, errors.New("extension has invalid extension length")
+	}
+
+	return rawext, nil
+}
+
+func (re *RawExtension) ToBinary() []byte {
+	common.AssertImpl(re != nil)
+	raw := make([]byte, 0, re.GetFullExtLen())
+	raw = append(raw, byte(re.Type>>8), byte(re.Type))
+	raw = append(raw, byte(re.ExtensionLen>>8), byte(re.ExtensionLen))
+	raw = append(raw, re.Data...)
+	return raw
+}
+
+func (re *RawExtension) GetType() ExtensionType { return re.Type }
+
+func (re *RawExtension) GetFullExtLen() int {
+	full := int(re.ExtensionLen) + (typesizes.Uint16Bytes * 2)
+	return full
+}
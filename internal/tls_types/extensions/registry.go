@@ -0,0 +1,76 @@
+package extensions
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	typesizes "github.com/tls-handshake/pkg/type_sizes"
+)
+
+// ParseFunc parses an extension of a registered type in the message ctx. The buffer starts at the extension type and
+// may hold further extensions, so the parsed extension must report its own length with GetFullExtLen.
+type ParseFunc func(buf []byte, ctx MsgContext) (Extension, error)
+
+type registeredExtension struct {
+	parse    ParseFunc
+	contexts []MsgContext
+}
+
+var (
+	registryMux sync.RWMutex
+	registry    = make(map[ExtensionType]registeredExtension)
+)
+
+// RegisterExtension adds a custom extension type, e.g. from the private use range 0xff00-0xffff, with the function
+// which parses it and the messages it may appear in. ParseExtensions parses registered extensions with parse instead
+// of keeping them as RawExtension. Types implemented by this package or registered before can't be registered.
+func RegisterExtension(t ExtensionType, parse ParseFunc, contexts ...MsgContext) error {
+	if parse == nil || len(contexts) == 0 {
+		return errors.New("extension needs a parse function and the messages it may appear in")
+	}
+	if _, ok := allowedContexts[t]; ok || t == NotSetType {
+		return fmt.Errorf("extension type %#04x is implemented by this package", uint16(t))
+	}
+
+	registryMux.Lock()
+	defer registryMux.Unlock()
+	if _, ok := registry[t]; ok {
+		return fmt.Errorf("extension type %#04x is already registered", uint16(t))
+	}
+	registry[t] = registeredExtension{
+		parse:    parse,
+		contexts: append([]MsgContext(nil), contexts...),
+	}
+	return nil
+}
+
+// IsRegistered reports whether the extension type was added with RegisterExtension.
+func IsRegistered(t ExtensionType) bool {
+	_, ok := lookupExtension(t)
+	return ok
+}
+
+func lookupExtension(t ExtensionType) (registeredExtension, bool) {
+	registryMux.RLock()
+	defer registryMux.RUnlock()
+	re, ok := registry[t]
+	return re, ok
+}
+
+// parseRegisteredExtension parses an extension with its registered parse function. The result is checked, because the
+// extension block can't be walked if the parser gets the type or the length wrong.
+func parseRegisteredExtension(re registeredExtension, buf []byte, ctx MsgContext) (Extension, error) {
+	t, err := ParseExtensionType(buf)
+	if err != nil {
+		return nil, err
+	}
+	ex, err := re.parse(buf, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ex == nil || ex.GetType() != t || ex.GetFullExtLen() < typesizes.Uint16Bytes*2 || ex.GetFullExtLen() > len(buf) {
+		return nil, fmt.Errorf("registered extension of type %#04x has invalid format", uint16(t))
+	}
+	return ex, nil
+}
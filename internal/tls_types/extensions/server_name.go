@@ -0,0 +1,129 @@
+package extensions
+
+import (
+	"errors"
+
+	"github.com/tls-handshake/internal/common"
+	typesizes "github.com/tls-handshake/pkg/type_sizes"
+)
+
+type NameType uint8
+
+const (
+	HostNameType NameType = 0
+)
+
+type ServerName struct {
+	NameType NameType
+	NameLen  uint16
+	Name     []byte
+}
+
+// ServerNameExtension holds the names of the server the client connects to, as defined in RFC 6066, Section 3. A
+// server which used the name acknowledges it with an empty extension in EncryptedExtensions.
+type ServerNameExtension struct {
+	Type              ExtensionType
+	ExtensionLen      uint16
+	Context           MsgContext
+	ServerNameListLen uint16 // only in a ClientHello
+	ServerNameList    []ServerName
+}
+
+func ParseServerNameExtension(buf []byte, ctx MsgContext) (snext *ServerNameExtension, err error) {
+	wi := 0 // write index
+	snext = &ServerNameExtension{Context: ctx}
+
+	snext.Type, err = ParseExtensionType(buf)
+	if err != nil {
+		return nil, err
+	}
+	if snext.Type != ServerNameType {
+		return nil, errors.New("not a server name extension type")
+	}
+	wi += int(typesizes.Uint16Bytes)
+
+	if len(buf[wi:]) < int(typesizes.Uint16Bytes) {
+		return nil, errors.New("server name extension has invalid format")
+	}
+	snext.ExtensionLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+	wi += int(typesizes.Uint16Bytes)
+
+	switch ctx {
+	case ClientHelloMsgContext:
+		if len(buf[wi:]) < int(typesizes.Uint16Bytes) {
+			return nil, errors.New("server name extension has invalid format")
+		}
+		snext.ServerNameListLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+		wi += int(typesizes.Uint16Bytes)
+
+		if snext.ServerNameListLen == 0 || len(buf[wi:]) < int(snext.ServerNameListLen) {
+			return nil, errors.New("server name extension has invalid server name list length")
+		}
+		list := buf[wi : wi+int(snext.ServerNameListLen)]
+		for len(list) > 0 {
+			if len(list) < typesizes.Uint8Bytes+typesizes.Uint16Bytes {
+				return nil, errors.New("server name extension has invalid server name")
+			}
+			sn := ServerName{NameType: NameType(list[0])}
+			sn.NameLen = (uint16(list[1]) << 8) + uint16(list[2])
+			list = list[typesizes.Uint8Bytes+typesizes.Uint16Bytes:]
+			if sn.NameLen == 0 || len(list) < int(sn.NameLen) {
+				return nil, errors.New("server name extension has invalid server name length")
+			}
+			for _, other := range snext.ServerNameList {
+				if other.NameType == sn.NameType {
+					return nil, errors.New("server name extension has several names of the same type")
+				}
+			}
+			sn.Name = list[:sn.NameLen]
+			snext.ServerNameList = append(snext.ServerNameList, sn)
+			list = list[sn.NameLen:]
+		}
+		wi += int(snext.ServerNameListLen)
+	case EncryptedExtensionsMsgContext:
+		if snext.ExtensionLen != 0 {
+			return nil, errors.New("server name extension has invalid extension length")
+		}
+	default:
+		return nil, errors.New("server name extension is not allowed in this message")
+	}
+
+	// Final sanity check:
+	if wi != snext.GetFullExtLen() {
+		return nil, errors.New("server name extension has invalid extension length")
+	}
+
+	return snext, nil
+}
+
+// HostName returns the DNS host name of the server, it's empty if the client sent none.
+func (sne *ServerNameExtension) HostName() string {
+	for _, sn := range sne.ServerNameList {
+		if sn.NameType == HostNameType {
+			return string(sn.Name)
+		}
+	}
+	return ""
+}
+
+func (sne *ServerNameExtension) ToBinary() []byte {
+	common.AssertImpl(sne != nil)
+	raw := make([]byte, 0, sne.GetFullExtLen())
+	raw = append(raw, byte(sne.Type>>8), byte(sne.Type))
+	raw = append(raw, byte(sne.ExtensionLen>>8), byte(sne.ExtensionLen))
+	if sne.Context == ClientHelloMsgContext {
+		raw = append(raw, byte(sne.ServerNameListLen>>8), byte(sne.ServerNameListLen))
+		for _, sn := range sne.ServerNameList {
+			raw = append(raw, byte(sn.NameType), byte(sn.NameLen>>8), byte(sn.NameLen))
+			raw = append(raw, sn.Name...)
+		}
+	}
+	return raw
+}
+
+func (sne *ServerNameExtension) GetType() ExtensionType { return sne.Type }
+
+func (sne *ServerNameExtension) GetFullExtLen() int {
+	full := int(sne.ExtensionLen) + (typesizes.Uint16Bytes * 2)
+	return full
+}
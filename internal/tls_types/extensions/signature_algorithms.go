@@ -0,0 +1,89 @@
+package extensions
+
+import (
+	"crypto/tls"
+	"errors"
+
+	"github.com/tls-handshake/internal/common"
+	typesizes "github.com/tls-handshake/pkg/type_sizes"
+)
+
+// SignatureAlgorithmsExtension lists the signature schemes which the sender accepts in a CertificateVerify, in order
+// of preference, as defined in RFC 8446, Section 4.2.3.
+type SignatureAlgorithmsExtension struct {
+	Type         ExtensionType
+	ExtensionLen uint16
+	SchemesLen   uint16
+	Schemes      []tls.SignatureScheme
+}
+
+func ParseSignatureAlgorithmsExtension(buf []byte) (saext *SignatureAlgorithmsExtension, err error) {
+	wi := 0 // write index
+	saext = &SignatureAlgorithmsExtension{}
+
+	saext.Type, err = ParseExtensionType(buf)
+	if err != nil {
+		return nil, err
+	}
+	if saext.Type != SignatureAlgorithmsType {
+		return nil, errors.New("not a signature algorithms extension type")
+	}
+	wi += int(typesizes.Uint16Bytes)
+
+	if len(buf[wi:]) < int(typesizes.Uint16Bytes) {
+		return nil, errors.New("signature algorithms extension has invalid format")
+	}
+	saext.ExtensionLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+	wi += int(typesizes.Uint16Bytes)
+
+	if len(buf[wi:]) < int(typesizes.Uint16Bytes) {
+		return nil, errors.New("signature algorithms extension has invalid format")
+	}
+	saext.SchemesLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+	wi += int(typesizes.Uint16Bytes)
+
+	if saext.SchemesLen == 0 || saext.SchemesLen%typesizes.Uint16Bytes != 0 || len(buf[wi:]) < int(saext.SchemesLen) {
+		return nil, errors.New("signature algorithms extension has invalid schemes length")
+	}
+	saext.Schemes = make([]tls.SignatureScheme, 0, saext.SchemesLen/typesizes.Uint16Bytes)
+	for i := 0; i < int(saext.SchemesLen); i += int(typesizes.Uint16Bytes) {
+		saext.Schemes = append(saext.Schemes, (tls.SignatureScheme(buf[wi+i])<<8)+tls.SignatureScheme(buf[wi+i+1]))
+	}
+	wi += int(saext.SchemesLen)
+
+	// Final sanity check:
+	if wi != saext.GetFullExtLen() {
+		return nil, errors.New("signature algorithms extension has invalid extension length")
+	}
+
+	return saext, nil
+}
+
+// Contains reports whether scheme is one of the listed signature schemes.
+func (sae *SignatureAlgorithmsExtension) Contains(scheme tls.SignatureScheme) bool {
+	for _, s := range sae.Schemes {
+		if s == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+func (sae *SignatureAlgorithmsExtension) ToBinary() []byte {
+	common.AssertImpl(sae != nil)
+	raw := make([]byte, 0, sae.GetFullExtLen())
+	raw = append(raw, byte(sae.Type>>8), byte(sae.Type))
+	raw = append(raw, byte(sae.ExtensionLen>>8), byte(sae.ExtensionLen))
+	raw = append(raw, byte(sae.SchemesLen>>8), byte(sae.SchemesLen))
+	for _, s := range sae.Schemes {
+		raw = append(raw, byte(s>>8), byte(s))
+	}
+	return raw
+}
+
+func (sae *SignatureAlgorithmsExtension) GetType() ExtensionType { return sae.Type }
+
+func (sae *SignatureAlgorithmsExtension) GetFullExtLen() int {
+	full := int(sae.ExtensionLen) + (typesizes.Uint16Bytes * 2)
+	return full
+}
@@ -0,0 +1,79 @@
+package extensions
+
+import (
+	"crypto/tls"
+	"errors"
+
+	"github.com/tls-handshake/internal/common"
+	typesizes "github.com/tls-handshake/pkg/type_sizes"
+)
+
+// SupportedGroupsExtension lists the key exchange groups the client supports in order of preference, as defined in
+// RFC 8446, Section 4.2.7.
+type SupportedGroupsExtension struct {
+	Type         ExtensionType
+	ExtensionLen uint16
+	GroupsLen    uint16
+	Groups       []tls.CurveID
+}
+
+func ParseSupportedGroupsExtension(buf []byte) (sgext *SupportedGroupsExtension, err error) {
+	wi := 0 // write index
+	sgext = &SupportedGroupsExtension{}
+
+	sgext.Type, err = ParseExtensionType(buf)
+	if err != nil {
+		return nil, err
+	}
+	if sgext.Type != SupportedGroupsType {
+		return nil, errors.New("not a supported groups extension type")
+	}
+	wi += int(typesizes.Uint16Bytes)
+
+	if len(buf[wi:]) < int(typesizes.Uint16Bytes) {
+		return nil, errors.New("supported groups extension has invalid format")
+	}
+	sgext.ExtensionLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+	wi += int(typesizes.Uint16Bytes)
+
+	if len(buf[wi:]) < int(typesizes.Uint16Bytes) {
+		return nil, errors.New("supported groups extension has invalid format")
+	}
+	sgext.GroupsLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+	wi += int(typesizes.Uint16Bytes)
+
+	if sgext.GroupsLen == 0 || sgext.GroupsLen%typesizes.Uint16Bytes != 0 || len(buf[wi:]) < int(sgext.GroupsLen) {
+		return nil, errors.New("supported groups extension has invalid groups length")
+	}
+	sgext.Groups = make([]tls.CurveID, 0, sgext.GroupsLen/typesizes.Uint16Bytes)
+	for i := 0; i < int(sgext.GroupsLen); i += int(typesizes.Uint16Bytes) {
+		sgext.Groups = append(sgext.Groups, (tls.CurveID(buf[wi+i])<<8)+tls.CurveID(buf[wi+i+1]))
+	}
+	wi += int(sgext.GroupsLen)
+
+	// Final sanity check:
+	if wi != sgext.GetFullExtLen() {
+		return nil, errors.New("supported groups extension has invalid extension length")
+	}
+
+	return sgext, nil
+}
+
+func (sge *SupportedGroupsExtension) ToBinary() []byte {
+	common.AssertImpl(sge != nil)
+	raw := make([]byte, 0, sge.GetFullExtLen())
+	raw = append(raw, byte(sge.Type>>8), byte(sge.Type))
+	raw = append(raw, byte(sge.ExtensionLen>>8), byte(sge.ExtensionLen))
+	raw = append(raw, byte(sge.GroupsLen>>8), byte(sge.GroupsLen))
+	for _, g := range sge.Groups {
+		raw = append(raw, byte(g>>8), byte(g))
+	}
+	return raw
+}
+
+func (sge *SupportedGroupsExtension) GetType() ExtensionType { return sge.Type }
+
+func (sge *SupportedGroupsExtension) GetFullExtLen() int {
+	full := int(sge.ExtensionLen) + (typesizes.Uint16Bytes * 2)
+	return full
+}
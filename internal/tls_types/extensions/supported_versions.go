@@ -1,26 +1,33 @@
 package extensions
 
 import (
-	"crypto/tls"
 	"errors"
 
 	"github.com/tls-handshake/internal/common"
 	typesizes "github.com/tls-handshake/pkg/type_sizes"
 )
 
-// 0 03 - 0x3 (3) bytes of "Supported Versions" extension data follows
-// 02 - 0x2 (2) bytes of TLS versions follow
-// 03 04 - assigned value for TLS 1.3
+// In a ClientHello:
+// 00 05 - 0x5 (5) bytes of "Supported Versions" extension data follows
+// 04 - 0x4 (4) bytes of TLS versions follow
+// 03 04 03 03 - TLS 1.3 and TLS 1.2
+// In a ServerHello or HelloRetryRequest:
+// 00 02 - 0x2 (2) bytes of "Supported Versions" extension data follows
+// 03 04 - the selected version, TLS 1.3
 
+// SupportedVersions lists the TLS versions of the client in a ClientHello. In a ServerHello and a HelloRetryRequest it
+// holds the single version selected by the server, as defined in RFC 8446, Section 4.2.1.
 type SupportedVersions struct {
 	Type          ExtensionType
 	ExtensionLen  uint16
-	TLSVersionLen uint8
-	TLSVersion    uint16
+	Context       MsgContext
+	TLSVersionLen uint8    // only in a ClientHello
+	TLSVersions   []uint16 // only in a ClientHello, unknown versions such as GREASE values are kept
+	TLSVersion    uint16   // the selected version, only in a ServerHello and a HelloRetryRequest
 }
 
-func ParseSupporteVersionsExtension(buf []byte) (supver *SupportedVersions, err error) {
-	supver = &SupportedVersions{}
+func ParseSupporteVersionsExtension(buf []byte, ctx MsgContext) (supver *SupportedVersions, err error) {
+	supver = &SupportedVersions{Context: ctx}
 	wi := 0 // write index
 
 	supver.Type, err = ParseExtensionType(buf)
@@ -38,23 +45,32 @@ func ParseSupporteVersionsExtension(buf []byte) (supver *SupportedVersions, err
 	supver.ExtensionLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
 	wi += int(typesizes.Uint16Bytes)
 
-	if len(buf[wi:]) < int(typesizes.Uint8Bytes) {
-		return nil, errors.New("supported version extension has invalid format")
-	}
-	supver.TLSVersionLen = uint8(buf[wi])
-	wi += int(typesizes.Uint8Bytes)
+	switch ctx {
+	case ClientHelloMsgContext:
+		if len(buf[wi:]) < int(typesizes.Uint8Bytes) {
+			return nil, errors.New("supported version extension has invalid format")
+		}
+		supver.TLSVersionLen = uint8(buf[wi])
+		wi += int(typesizes.Uint8Bytes)
 
-	if len(buf[wi:]) < int(typesizes.Uint16Bytes) {
-		return nil, errors.New("supported version extension has invalid format")
-	}
-	supver.TLSVersion = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
-	switch supver.TLSVersion {
-	case tls.VersionTLS13:
-		supver.TLSVersion = uint16(tls.VersionTLS13)
+		if supver.TLSVersionLen == 0 || supver.TLSVersionLen%typesizes.Uint16Bytes != 0 ||
+			len(buf[wi:]) < int(supver.TLSVersionLen) {
+			return nil, errors.New("supported version extension has invalid versions length")
+		}
+		supver.TLSVersions = make([]uint16, 0, supver.TLSVersionLen/typesizes.Uint16Bytes)
+		for i := 0; i < int(supver.TLSVersionLen); i += int(typesizes.Uint16Bytes) {
+			supver.TLSVersions = append(supver.TLSVersions, (uint16(buf[wi+i])<<8)+uint16(buf[wi+i+1]))
+		}
+		wi += int(supver.TLSVersionLen)
+	case ServerHelloMsgContext, HelloRetryRequestMsgContext:
+		if len(buf[wi:]) < int(typesizes.Uint16Bytes) {
+			return nil, errors.New("supported version extension has invalid format")
+		}
+		supver.TLSVersion = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+		wi += int(typesizes.Uint16Bytes)
 	default:
-		return nil, errors.New("unsupported version of TLS")
+		return nil, errors.New("supported version extension is not allowed in this message")
 	}
-	wi += int(typesizes.Uint16Bytes)
 
 	// Need to check extension length:
 	if wi != supver.GetFullExtLen() {
@@ -64,19 +80,35 @@ func ParseSupporteVersionsExtension(buf []byte) (supver *SupportedVersions, err
 	return supver, nil
 }
 
+// Supports reports whether the client listed the version.
+func (sve *SupportedVersions) Supports(version uint16) bool {
+	for _, v := range sve.TLSVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
 func (sve *SupportedVersions) ToBinary() []byte {
 	common.AssertImpl(sve != nil)
 	raw := make([]byte, 0, sve.GetFullExtLen())
 	raw = append(raw, byte(sve.Type>>8), byte(sve.Type))
 	raw = append(raw, byte(sve.ExtensionLen>>8), byte(sve.ExtensionLen))
-	raw = append(raw, byte(sve.TLSVersionLen))
-	raw = append(raw, byte(sve.TLSVersion>>8), byte(sve.TLSVersion))
+	if sve.Context == ClientHelloMsgContext {
+		raw = append(raw, byte(sve.TLSVersionLen))
+		for _, v := range sve.TLSVersions {
+			raw = append(raw, byte(v>>8), byte(v))
+		}
+	} else {
+		raw = append(raw, byte(sve.TLSVersion>>8), byte(sve.TLSVersion))
+	}
 	return raw
 }
 
 func (sve *SupportedVersions) GetType() ExtensionType { return sve.Type }
 
 func (sve *SupportedVersions) GetFullExtLen() int {
-	full := int(sve.ExtensionLen)+(int(typesizes.Uint16Bytes)*2)
+	full := int(sve.ExtensionLen) + (int(typesizes.Uint16Bytes) * 2)
 	return full
 }
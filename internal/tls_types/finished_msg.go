@@ -0,0 +1,53 @@
+package tlstypes
+
+import (
+	"errors"
+
+	"github.com/tls-handshake/internal/common"
+)
+
+type FinishedMsg struct {
+	Type       HandshakeMsgType
+	Length     uint
+	VerifyData []byte // the length is the size of the cipher suite hash
+}
+
+func ParseFinishedMsg(buf []byte) (hm *FinishedMsg, err error) {
+	if len(buf) < int(HandshakeHeaderByteSize) {
+		// must be able to, at least, read the HandshakeHeader
+		return nil, errors.New("unsupported handshake message size")
+	}
+
+	wi := 0 // write index
+	hm = &FinishedMsg{}
+
+	// Handshake Header:
+	hm.Type = HandshakeMsgType(buf[wi])
+	if hm.Type != FinishedMsgType {
+		return nil, errors.New("not a finished handshake message")
+	}
+	hm.Length = uint(buf[wi+1])<<16 + uint(buf[wi+2])<<8 + uint(buf[wi+3])
+	wi += int(HandshakeHeaderByteSize)
+	if hm.Length != uint(len(buf[wi:])) {
+		return nil, errors.New("finished message has invalid length")
+	}
+
+	// VerifyData:
+	hm.VerifyData = make([]byte, hm.Length)
+	copy(hm.VerifyData[:], buf[wi:])
+
+	return hm, nil
+}
+
+func (hm *FinishedMsg) ToBinary() []byte {
+	common.AssertImpl(hm != nil)
+	// Pre-allocate if length is known, else cap is HandshakeHeaderByteSize
+	raw := make([]byte, 0, hm.Length+uint(HandshakeHeaderByteSize))
+
+	raw = append(raw, byte(hm.Type))
+	raw = append(raw, byte(hm.Length>>16), byte(hm.Length>>8), byte(hm.Length))
+	raw = append(raw, hm.VerifyData[:]...)
+
+	setHandshakeLength(raw, &hm.Length)
+	return raw
+}
@@ -0,0 +1,59 @@
+package tlstypes
+
+import "testing"
+
+func TestParseFinishedMsg(t *testing.T) {
+	var buf []byte = []byte{0x14, 0x00, 0x00, 0x04, 0x01, 0x02, 0x03, 0x04}
+
+	hm, err := ParseFinishedMsg(buf)
+	if err != nil {
+		t.Fatalf("ParseFinishedMsg is broken")
+	}
+
+	binHm := hm.ToBinary()
+	v := string(binHm) == string(buf)
+	if !v {
+		t.Fatalf("ParseFinishedMsg.ToBinary is broken")
+	}
+
+	hm = MakeFinishedMessage(buf[HandshakeHeaderByteSize:])
+	binHm = hm.ToBinary()
+	v = string(binHm) == string(buf)
+	if !v {
+		t.Fatalf("MakeFinishedMessage is broken")
+	}
+}
+
+func TestParseEncryptedExtensionsMsg(t *testing.T) {
+	var buf []byte = []byte{0x08, 0x00, 0x00, 0x02, 0x00, 0x00}
+
+	hm, err := ParseEncryptedExtensionsMsg(buf)
+	if err != nil {
+		t.Fatalf("ParseEncryptedExtensionsMsg is broken")
+	}
+
+	binHm := hm.ToBinary()
+	v := string(binHm) == string(buf)
+	if !v {
+		t.Fatalf("ParseEncryptedExtensionsMsg.ToBinary is broken")
+	}
+}
+
+func TestParseEndOfEarlyDataMsg(t *testing.T) {
+	var buf []byte = []byte{0x05, 0x00, 0x00, 0x00}
+
+	hm, err := ParseEndOfEarlyDataMsg(buf)
+	if err != nil {
+		t.Fatalf("ParseEndOfEarlyDataMsg is broken")
+	}
+
+	binHm := MakeEndOfEarlyDataMessage().ToBinary()
+	v := string(binHm) == string(buf) && string(hm.ToBinary()) == string(buf)
+	if !v {
+		t.Fatalf("MakeEndOfEarlyDataMessage is broken")
+	}
+
+	if _, err := ParseEndOfEarlyDataMsg([]byte{0x05, 0x00, 0x00, 0x01, 0x00}); err == nil {
+		t.Fatalf("ParseEndOfEarlyDataMsg accepts a message with content")
+	}
+}
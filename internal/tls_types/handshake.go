@@ -0,0 +1,83 @@
+package tlstypes
+
+import (
+	"errors"
+
+	"github.com/tls-handshake/internal/common"
+)
+
+// ParseHandshakeHeader reads the message type and the length of the message body from the handshake header.
+func ParseHandshakeHeader(buf []byte) (HandshakeMsgType, uint, error) {
+	if len(buf) < int(HandshakeHeaderByteSize) {
+		return 0, 0, errors.New("unsupported handshake message size")
+	}
+	t := HandshakeMsgType(buf[0])
+	length := uint(buf[1])<<16 + uint(buf[2])<<8 + uint(buf[3])
+	return t, length, nil
+}
+
+// The largest handshake message which is accepted from the peer.
+const MaxSizeOfHandshakeMsg = 65536
+
+// HandshakeBuffer reassembles handshake messages from the content of handshake records. A message can be split over
+// several records and a record can carry several messages.
+type HandshakeBuffer struct {
+	buf []byte
+}
+
+func (hb *HandshakeBuffer) Write(fragment []byte) {
+	hb.buf = append(hb.buf, fragment...)
+}
+
+// Next returns the next complete handshake message, including the handshake header. It returns nil when more data is
+// needed to complete the message.
+func (hb *HandshakeBuffer) Next() ([]byte, error) {
+	if len(hb.buf) < int(HandshakeHeaderByteSize) {
+		return nil, nil
+	}
+	_, length, err := ParseHandshakeHeader(hb.buf)
+	if err != nil {
+		return nil, err
+	}
+	if length > MaxSizeOfHandshakeMsg {
+		return nil, errors.New("handshake message length exceeds the maximum")
+	}
+	fullLen := int(HandshakeHeaderByteSize) + int(length)
+	if len(hb.buf) < fullLen {
+		return nil, nil
+	}
+
+	msg := make([]byte, fullLen)
+	copy(msg, hb.buf)
+	hb.buf = hb.buf[fullLen:]
+	return msg, nil
+}
+
+// Len returns the number of buffered bytes which are not yet returned as a message.
+func (hb *HandshakeBuffer) Len() int {
+	return len(hb.buf)
+}
+
+func readUint24(buf []byte) uint32 {
+	return uint32(buf[0])<<16 + uint32(buf[1])<<8 + uint32(buf[2])
+}
+
+func appendUint24(raw []byte, v uint32) []byte {
+	return append(raw, byte(v>>16), byte(v>>8), byte(v))
+}
+
+// setHandshakeLength writes the handshake length in the header of raw when it is not known in advance, otherwise it
+// checks that the set length is correct.
+func setHandshakeLength(raw []byte, length *uint) {
+	if *length == 0 {
+		// Automatically figure out the length.
+		// Length was previously written as 0, now it's calculated and we need to update it.
+		*length = uint(len(raw)) - uint(HandshakeHeaderByteSize)
+		raw[1] = byte(*length >> 16)
+		raw[2] = byte(*length >> 8)
+		raw[3] = byte(*length)
+	} else {
+		// If length is set it should be correct!
+		common.AssertImpl(*length == uint(len(raw))-uint(HandshakeHeaderByteSize))
+	}
+}
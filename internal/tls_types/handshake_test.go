@@ -0,0 +1,42 @@
+package tlstypes
+
+import "testing"
+
+func TestHandshakeBuffer(t *testing.T) {
+	var (
+		finished []byte = []byte{0x14, 0x00, 0x00, 0x04, 0x01, 0x02, 0x03, 0x04}
+		ee       []byte = []byte{0x08, 0x00, 0x00, 0x02, 0x00, 0x00}
+		hb       HandshakeBuffer
+	)
+
+	// A message split over several records:
+	hb.Write(finished[:3])
+	if msg, err := hb.Next(); msg != nil || err != nil {
+		t.Fatalf("HandshakeBuffer.Next returns an incomplete header")
+	}
+	hb.Write(finished[3:6])
+	if msg, err := hb.Next(); msg != nil || err != nil {
+		t.Fatalf("HandshakeBuffer.Next returns an incomplete message")
+	}
+	hb.Write(finished[6:])
+	if msg, err := hb.Next(); string(msg) != string(finished) || err != nil {
+		t.Fatalf("HandshakeBuffer is broken when reassembling a message")
+	}
+
+	// Several messages packed in one record:
+	hb.Write(append(append([]byte{}, ee...), finished...))
+	if msg, err := hb.Next(); string(msg) != string(ee) || err != nil {
+		t.Fatalf("HandshakeBuffer is broken when splitting messages")
+	}
+	if msg, err := hb.Next(); string(msg) != string(finished) || err != nil {
+		t.Fatalf("HandshakeBuffer is broken when splitting messages")
+	}
+	if hb.Len() != 0 {
+		t.Fatalf("HandshakeBuffer.Len is broken")
+	}
+
+	hb.Write([]byte{0x0b, 0xff, 0xff, 0xff})
+	if _, err := hb.Next(); err == nil {
+		t.Fatalf("HandshakeBuffer accepts a message which is too large")
+	}
+}
@@ -0,0 +1,47 @@
+package tlstypes
+
+import (
+	"errors"
+
+	"github.com/tls-handshake/internal/common"
+)
+
+// InnerPlaintext is the TLSInnerPlaintext structure, which is the content of a protected record before encryption.
+// As defined in RFC 8446 section 5.2.
+type InnerPlaintext struct {
+	Content     []byte
+	ContentType RecordType // the real type of the record content
+	ZerosLen    int        // length of the padding
+}
+
+func ParseInnerPlaintext(raw []byte) (*InnerPlaintext, error) {
+	if len(raw) > MaxSizeOfPlaintextRecord {
+		return nil, errors.New("inner plaintext length exceeds the maximum for a record")
+	}
+
+	// The content type is the last non zero byte, everything after it is padding.
+	i := len(raw) - 1
+	for i >= 0 && raw[i] == 0 {
+		i--
+	}
+	if i < 0 {
+		return nil, errors.New("inner plaintext is missing a content type")
+	}
+
+	ret := &InnerPlaintext{
+		Content:     raw[:i],
+		ContentType: RecordType(raw[i]),
+		ZerosLen:    len(raw) - i - 1,
+	}
+	return ret, nil
+}
+
+func (ip *InnerPlaintext) ToBinary() []byte {
+	common.AssertImpl(ip != nil)
+	raw := make([]byte, 0, len(ip.Content)+1+ip.ZerosLen)
+	raw = append(raw, ip.Content...)
+	raw = append(raw, byte(ip.ContentType))
+	raw = append(raw, make([]byte, ip.ZerosLen)...)
+	common.AssertImpl(len(raw) <= MaxSizeOfPlaintextRecord)
+	return raw
+}
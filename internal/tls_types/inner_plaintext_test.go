@@ -0,0 +1,25 @@
+package tlstypes
+
+import "testing"
+
+func TestParseInnerPlaintext(t *testing.T) {
+	var buf []byte = []byte{0x50, 0x49, 0x4e, 0x47, 0x17, 0x00, 0x00, 0x00}
+
+	ip, err := ParseInnerPlaintext(buf)
+	if err != nil {
+		t.Fatalf("ParseInnerPlaintext is broken")
+	}
+	if string(ip.Content) != "PING" || ip.ContentType != ApplicationRecord || ip.ZerosLen != 3 {
+		t.Fatalf("ParseInnerPlaintext is broken when stripping padding")
+	}
+
+	ipBin := ip.ToBinary()
+	v := string(ipBin) == string(buf)
+	if !v {
+		t.Fatalf("InnerPlaintext.ToBinary is broken")
+	}
+
+	if _, err = ParseInnerPlaintext([]byte{0x00, 0x00}); err == nil {
+		t.Fatalf("ParseInnerPlaintext accepts an inner plaintext without a content type")
+	}
+}
@@ -0,0 +1,67 @@
+package tlstypes
+
+import (
+	"errors"
+
+	"github.com/tls-handshake/internal/common"
+)
+
+// KeyUpdateRequest tells the receiver of a KeyUpdate message whether it must update its own sending keys as well.
+type KeyUpdateRequest uint8
+
+const (
+	UpdateNotRequested KeyUpdateRequest = 0
+	UpdateRequested    KeyUpdateRequest = 1
+)
+
+// KeyUpdateMsg tells the peer that the sender switches to the next generation of its traffic keys, as defined in
+// RFC 8446, Section 4.6.3.
+type KeyUpdateMsg struct {
+	Type          HandshakeMsgType
+	Length        uint
+	RequestUpdate KeyUpdateRequest
+}
+
+func ParseKeyUpdateMsg(buf []byte) (hm *KeyUpdateMsg, err error) {
+	if len(buf) < int(HandshakeHeaderByteSize) {
+		// must be able to, at least, read the HandshakeHeader
+		return nil, errors.New("unsupported handshake message size")
+	}
+
+	wi := 0 // write index
+	hm = &KeyUpdateMsg{}
+
+	// Handshake Header:
+	hm.Type = HandshakeMsgType(buf[wi])
+	if hm.Type != KeyUpdateMsgType {
+		return nil, errors.New("not a key update handshake message")
+	}
+	hm.Length = uint(buf[wi+1])<<16 + uint(buf[wi+2])<<8 + uint(buf[wi+3])
+	wi += int(HandshakeHeaderByteSize)
+	if hm.Length != 1 || len(buf[wi:]) != 1 {
+		return nil, errors.New("key update message has invalid length")
+	}
+
+	// RequestUpdate:
+	hm.RequestUpdate = KeyUpdateRequest(buf[wi])
+	wi++
+
+	// Final sanity check:
+	if wi != len(buf) {
+		return nil, errors.New("key update message has invalid length")
+	}
+
+	return hm, nil
+}
+
+func (hm *KeyUpdateMsg) ToBinary() []byte {
+	common.AssertImpl(hm != nil)
+	raw := make([]byte, 0, HandshakeHeaderByteSize+1)
+
+	raw = append(raw, byte(hm.Type))
+	raw = append(raw, byte(hm.Length>>16), byte(hm.Length>>8), byte(hm.Length))
+	raw = append(raw, byte(hm.RequestUpdate))
+
+	setHandshakeLength(raw, &hm.Length)
+	return raw
+}
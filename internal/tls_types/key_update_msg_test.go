@@ -0,0 +1,32 @@
+package tlstypes
+
+import "testing"
+
+func TestParseKeyUpdateMsg(t *testing.T) {
+	var buf []byte = []byte{0x18, 0x00, 0x00, 0x01, 0x01}
+
+	hm, err := ParseKeyUpdateMsg(buf)
+	if err != nil {
+		t.Fatalf("ParseKeyUpdateMsg is broken")
+	}
+	if hm.RequestUpdate != UpdateRequested {
+		t.Fatalf("ParseKeyUpdateMsg parsed wrong request update %d", hm.RequestUpdate)
+	}
+
+	binHm := hm.ToBinary()
+	v := string(binHm) == string(buf)
+	if !v {
+		t.Fatalf("ParseKeyUpdateMsg.ToBinary is broken")
+	}
+
+	hm = MakeKeyUpdateMessage(UpdateRequested)
+	binHm = hm.ToBinary()
+	v = string(binHm) == string(buf)
+	if !v {
+		t.Fatalf("MakeKeyUpdateMessage is broken")
+	}
+
+	if _, err := ParseKeyUpdateMsg([]byte{0x18, 0x00, 0x00, 0x00}); err == nil {
+		t.Fatalf("ParseKeyUpdateMsg accepts a message without request update")
+	}
+}
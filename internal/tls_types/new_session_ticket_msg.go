@@ -0,0 +1,116 @@
+package tlstypes
+
+import (
+	"errors"
+
+	"github.com/tls-handshake/internal/common"
+	typesizes "github.com/tls-handshake/pkg/type_sizes"
+)
+
+// NewSessionTicketMsg is sent by the server after the handshake. The client can use the ticket to resume the session
+// in a later handshake, as defined in RFC 8446, Section 4.6.1.
+type NewSessionTicketMsg struct {
+	Type           HandshakeMsgType
+	Length         uint
+	TicketLifetime uint32 // in seconds
+	TicketAgeAdd   uint32 // obfuscates the ticket age the client sends back
+	TicketNonceLen uint8
+	TicketNonce    []byte // unique per ticket of the connection, used to derive the resumption PSK
+	TicketLen      uint16
+	Ticket         []byte // opaque label of the session
+	ExtensionsLen  uint16
+	ExtensionData  []byte
+}
+
+func ParseNewSessionTicketMsg(buf []byte) (hm *NewSessionTicketMsg, err error) {
+	if len(buf) < int(HandshakeHeaderByteSize) {
+		// must be able to, at least, read the HandshakeHeader
+		return nil, errors.New("unsupported handshake message size")
+	}
+
+	wi := 0 // write index
+	hm = &NewSessionTicketMsg{}
+
+	// Handshake Header:
+	hm.Type = HandshakeMsgType(buf[wi])
+	if hm.Type != NewSessionTicketMsgType {
+		return nil, errors.New("not a new session ticket handshake message")
+	}
+	hm.Length = uint(buf[wi+1])<<16 + uint(buf[wi+2])<<8 + uint(buf[wi+3])
+	wi += int(HandshakeHeaderByteSize)
+	if hm.Length > uint(len(buf[wi:])) {
+		return nil, errors.New("new session ticket message has invalid length")
+	}
+
+	// Lifetime and Age Add:
+	if len(buf[wi:]) < typesizes.Uint32Bytes*2 {
+		return nil, errors.New("new session ticket message has invalid format")
+	}
+	hm.TicketLifetime = uint32(buf[wi])<<24 + uint32(buf[wi+1])<<16 + uint32(buf[wi+2])<<8 + uint32(buf[wi+3])
+	wi += typesizes.Uint32Bytes
+	hm.TicketAgeAdd = uint32(buf[wi])<<24 + uint32(buf[wi+1])<<16 + uint32(buf[wi+2])<<8 + uint32(buf[wi+3])
+	wi += typesizes.Uint32Bytes
+
+	// Nonce:
+	if len(buf[wi:]) < typesizes.Uint8Bytes {
+		return nil, errors.New("new session ticket message has invalid format")
+	}
+	hm.TicketNonceLen = uint8(buf[wi])
+	wi += typesizes.Uint8Bytes
+	if len(buf[wi:]) < int(hm.TicketNonceLen) {
+		return nil, errors.New("new session ticket message has invalid nonce length")
+	}
+	hm.TicketNonce = make([]byte, hm.TicketNonceLen)
+	wi += copy(hm.TicketNonce[:], buf[wi:])
+
+	// Ticket:
+	if len(buf[wi:]) < typesizes.Uint16Bytes {
+		return nil, errors.New("new session ticket message has invalid format")
+	}
+	hm.TicketLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+	wi += typesizes.Uint16Bytes
+	if hm.TicketLen == 0 || len(buf[wi:]) < int(hm.TicketLen) {
+		return nil, errors.New("new session ticket message has invalid ticket length")
+	}
+	hm.Ticket = make([]byte, hm.TicketLen)
+	wi += copy(hm.Ticket[:], buf[wi:])
+
+	// Extensions:
+	if len(buf[wi:]) < int(ExtensionsLengthByteSize) {
+		return nil, errors.New("new session ticket message has invalid format")
+	}
+	hm.ExtensionsLen = (uint16(buf[wi]) << 8) + uint16(buf[wi+1])
+	wi += int(ExtensionsLengthByteSize)
+	if len(buf[wi:]) < int(hm.ExtensionsLen) {
+		return nil, errors.New("new session ticket message has invalid extensions length")
+	}
+	hm.ExtensionData = make([]byte, hm.ExtensionsLen)
+	wi += copy(hm.ExtensionData[:], buf[wi:])
+
+	// Final sanity check:
+	if wi-int(HandshakeHeaderByteSize) != int(hm.Length) {
+		return nil, errors.New("new session ticket message has invalid length")
+	}
+
+	return hm, nil
+}
+
+func (hm *NewSessionTicketMsg) ToBinary() []byte {
+	common.AssertImpl(hm != nil)
+	// Pre-allocate if length is known, else cap is HandshakeHeaderByteSize
+	raw := make([]byte, 0, hm.Length+uint(HandshakeHeaderByteSize))
+
+	raw = append(raw, byte(hm.Type))
+	raw = append(raw, byte(hm.Length>>16), byte(hm.Length>>8), byte(hm.Length))
+	raw = append(raw, byte(hm.TicketLifetime>>24), byte(hm.TicketLifetime>>16), byte(hm.TicketLifetime>>8), byte(hm.TicketLifetime))
+	raw = append(raw, byte(hm.TicketAgeAdd>>24), byte(hm.TicketAgeAdd>>16), byte(hm.TicketAgeAdd>>8), byte(hm.TicketAgeAdd))
+	raw = append(raw, hm.TicketNonceLen)
+	raw = append(raw, hm.TicketNonce[:]...)
+	raw = append(raw, byte(hm.TicketLen>>8), byte(hm.TicketLen))
+	raw = append(raw, hm.Ticket[:]...)
+	raw = append(raw, byte(hm.ExtensionsLen>>8), byte(hm.ExtensionsLen))
+	raw = append(raw, hm.ExtensionData[:]...)
+
+	setHandshakeLength(raw, &hm.Length)
+	return raw
+}
@@ -0,0 +1,46 @@
+package tlstypes
+
+import (
+	"testing"
+)
+
+func TestParseNewSessionTicketMsg(t *testing.T) {
+	var buf []byte = []byte{
+		0x04, 0x00, 0x00, 0x11, // handshake header
+		0x00, 0x09, 0x3a, 0x80, // ticket lifetime: 7 days
+		0x01, 0x02, 0x03, 0x04, // ticket age add
+		0x01, 0x00, // ticket nonce
+		0x00, 0x03, 0xaa, 0xbb, 0xcc, // ticket
+		0x00, 0x00, // extensions length
+	}
+
+	hm, err := ParseNewSessionTicketMsg(buf)
+	if err != nil {
+		t.Fatalf("ParseNewSessionTicketMsg is broken")
+	}
+	if hm.TicketLifetime != 604800 || hm.TicketAgeAdd != 0x01020304 || string(hm.Ticket) != "\xaa\xbb\xcc" {
+		t.Fatalf("ParseNewSessionTicketMsg parsed wrong values %+v", hm)
+	}
+
+	binHm := hm.ToBinary()
+	v := string(binHm) == string(buf)
+	if !v {
+		t.Fatalf("ParseNewSessionTicketMsg.ToBinary is broken")
+	}
+
+	hm = MakeNewSessionTicketMessage(604800, 0x01020304, []byte{0}, []byte{0xaa, 0xbb, 0xcc}, 0)
+	binHm = hm.ToBinary()
+	v = string(binHm) == string(buf)
+	if !v {
+		t.Fatalf("MakeNewSessionTicketMessage is broken")
+	}
+
+	if _, err := ParseNewSessionTicketMsg(buf[:len(buf)-1]); err == nil {
+		t.Fatalf("ParseNewSessionTicketMsg accepts a truncated message")
+	}
+
+	hm = MakeNewSessionTicketMessage(604800, 0x01020304, []byte{0}, []byte{0xaa, 0xbb, 0xcc}, 0x4000)
+	if hm.ExtensionsLen != 8 || string(hm.ExtensionData) != "\x00\x2a\x00\x04\x00\x00\x40\x00" {
+		t.Fatalf("MakeNewSessionTicketMessage does not encode the early data extension")
+	}
+}
@@ -12,13 +12,17 @@ import (
 type RecordType uint8
 
 const (
-	AlertRecord       RecordType = 0x15
-	HandshakeRecord   RecordType = 0x16
-	ApplicationRecord RecordType = 0x17
+	ChangeCipherSpecRecord RecordType = 0x14
+	AlertRecord            RecordType = 0x15
+	HandshakeRecord        RecordType = 0x16
+	ApplicationRecord      RecordType = 0x17
 
 	// This value is the length of the plaintext of a protected record. The value includes the content type and padding
 	// added in TLS 1.3 (that is, the complete length of TLSInnerPlaintext). TLS 1.3 uses a limit of 2^14+1 octets.
 	MaxSizeOfPlaintextRecord int = 16385 // maxPlaintext
+
+	// Protected records may be expanded by the AEAD up to 2^14+256 octets.
+	MaxSizeOfCiphertextRecord int = 16640 // maxCiphertextTLS13
 )
 
 type Record struct {
@@ -36,6 +40,8 @@ func ParseRecord(raw []byte) (*Record, error) {
 
 	ret := &Record{}
 	switch RecordType(raw[0]) {
+	case ChangeCipherSpecRecord:
+		ret.RecordType = ChangeCipherSpecRecord
 	case HandshakeRecord:
 		ret.RecordType = HandshakeRecord
 	case AlertRecord:
@@ -50,12 +56,22 @@ func ParseRecord(raw []byte) (*Record, error) {
 	switch ret.TLSVersion {
 	case tls.VersionTLS13:
 		ret.TLSVersion = uint16(tls.VersionTLS13)
+	case tls.VersionTLS12:
+		// legacy_record_version of protected records
+		ret.TLSVersion = uint16(tls.VersionTLS12)
+	case tls.VersionTLS10:
+		// legacy_record_version of the first ClientHello of most clients
+		ret.TLSVersion = uint16(tls.VersionTLS10)
 	default:
 		return nil, errors.New("unsupported version of TLS")
 	}
 
 	ret.Length = (uint16(raw[3]) << 8) + uint16(raw[4])
-	if int(ret.Length) > MaxSizeOfPlaintextRecord {
+	maxLength := MaxSizeOfPlaintextRecord
+	if ret.RecordType == ApplicationRecord {
+		maxLength = MaxSizeOfCiphertextRecord
+	}
+	if int(ret.Length) > maxLength {
 		return nil, errors.New("record length exceeds the maximum for a record")
 	}
 	if len(raw) < (int(RecordHeaderByteSize) + int(ret.Length)) {
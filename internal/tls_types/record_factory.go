@@ -14,7 +14,7 @@ func MakeAlertRecord(a *Alert) *Record {
 	common.AssertImpl(a != nil)
 	abin := a.ToBinary()
 	record := &Record{
-		TLSVersion: tls.VersionTLS13,
+		TLSVersion: tls.VersionTLS12,
 		RecordType: AlertRecord,
 		Length:     uint16(len(abin)),
 		Data:       abin,
@@ -22,12 +22,11 @@ func MakeAlertRecord(a *Alert) *Record {
 	return record
 }
 
-// The encryptedData does not include the record header. TODO: This does not follow the application recrod RFC 8446 and
-// previous TLS protocols. It's missing Auth Tag which additionally protects the integrity of the encrypted data and the
-// record header. With this implementation the record header could have been modified.
+// The encryptedData does not include the record header. Protected records are always sent with legacy_record_version
+// set to TLS 1.2 as required by RFC 8446, Section 5.2.
 func MakeAppliactionRecord(encryptedData []byte) *Record {
 	record := &Record{
-		TLSVersion: tls.VersionTLS13,
+		TLSVersion: tls.VersionTLS12,
 		RecordType: ApplicationRecord,
 		Length:     uint16(len(encryptedData)),
 		Data:       encryptedData,
@@ -35,28 +34,135 @@ func MakeAppliactionRecord(encryptedData []byte) *Record {
 	return record
 }
 
-func MakeServerHelloRecord(serverHelloMsg *ServerHelloMsg) *Record {
-	common.AssertImpl(serverHelloMsg != nil)
-	data := serverHelloMsg.ToBinary()
+// MakePlaintextRecord creates an unprotected record. The data must fit in a single record. Like protected records it's
+// sent with legacy_record_version set to TLS 1.2, which middleboxes expect, see RFC 8446, Section 5.1.
+func MakePlaintextRecord(recordType RecordType, data []byte) *Record {
+	common.AssertImpl(len(data) <= MaxSizeOfPlaintextRecord)
 	record := &Record{
-		TLSVersion: tls.VersionTLS13,
-		RecordType: HandshakeRecord,
+		TLSVersion: tls.VersionTLS12,
+		RecordType: recordType,
 		Length:     uint16(len(data)),
 		Data:       data,
 	}
 	return record
 }
 
+func MakeServerHelloRecord(serverHelloMsg *ServerHelloMsg) *Record {
+	common.AssertImpl(serverHelloMsg != nil)
+	return MakePlaintextRecord(HandshakeRecord, serverHelloMsg.ToBinary())
+}
+
 func MakeClientHelloRecord(clientHelloMsg *ClientHelloMsg) *Record {
 	common.AssertImpl(clientHelloMsg != nil)
-	data := clientHelloMsg.ToBinary()
-	record := &Record{
-		TLSVersion: tls.VersionTLS13,
-		RecordType: HandshakeRecord,
-		Length:     uint16(len(data)),
-		Data:       data,
+	return MakePlaintextRecord(HandshakeRecord, clientHelloMsg.ToBinary())
+}
+
+func MakeEncryptedExtensionsMessage(cfg *EncryptedExtensionsExtParams) *EncryptedExtensionsMsg {
+	encryptedExtensionsMsg := &EncryptedExtensionsMsg{
+		Type:          EncryptedExtensionsMsgType,
+		Length:        0, // will be auto calculated
+		ExtensionsLen: 0,
+		ExtensionData: []byte{},
 	}
-	return record
+
+	// Encode Extensions:
+	if cfg != nil {
+		var buf bytes.Buffer
+		if cfg.ServerName {
+			_, err := buf.Write(encodeServerNameExtension(extensions.EncryptedExtensionsMsgContext, ""))
+			common.AssertImpl(err == nil)
+		}
+		if cfg.ALPNProtocol != "" {
+			_, err := buf.Write(encodeALPNExtension([]string{cfg.ALPNProtocol}))
+			common.AssertImpl(err == nil)
+		}
+		if cfg.EarlyData {
+			_, err := buf.Write(encodeEarlyDataExtension(extensions.EncryptedExtensionsMsgContext, 0))
+			common.AssertImpl(err == nil)
+		}
+		_, err := buf.Write(encodeCustomExtensions(cfg.Extensions))
+		common.AssertImpl(err == nil)
+		encryptedExtensionsMsg.ExtensionsLen = uint16(buf.Len())
+		encryptedExtensionsMsg.ExtensionData = buf.Bytes()
+	}
+
+	return encryptedExtensionsMsg
+}
+
+// MakeEndOfEarlyDataMessage creates the message which ends the client's early data.
+func MakeEndOfEarlyDataMessage() *EndOfEarlyDataMsg {
+	endOfEarlyDataMsg := &EndOfEarlyDataMsg{
+		Type:   EndOfEarlyDataMsgType,
+		Length: 0,
+	}
+	return endOfEarlyDataMsg
+}
+
+// MakeCertificateMessage creates a certificate message from a DER encoded certificate chain. The leaf certificate must
+// be first.
+func MakeCertificateMessage(certificates [][]byte) *CertificateMsg {
+	certificateMsg := &CertificateMsg{
+		Type:              CertificateMsgType,
+		Length:            0, // will be auto calculated
+		RequestContextLen: 0,
+		RequestContext:    []byte{},
+		CertificateList:   make([]CertificateEntry, 0, len(certificates)),
+	}
+	for i := 0; i < len(certificates); i++ {
+		e := CertificateEntry{
+			CertDataLen:   uint32(len(certificates[i])),
+			CertData:      certificates[i],
+			ExtensionsLen: 0,
+			ExtensionData: []byte{},
+		}
+		certificateMsg.CertificateListLen += uint32(Uint24ByteSize) + e.CertDataLen + uint32(ExtensionsLengthByteSize)
+		certificateMsg.CertificateList = append(certificateMsg.CertificateList, e)
+	}
+	return certificateMsg
+}
+
+// MakeCertificateRequestMessage asks the client for a certificate which can sign with one of the signature schemes.
+func MakeCertificateRequestMessage(requestContext []byte, schemes []tls.SignatureScheme) *CertificateRequestMsg {
+	extData := encodeSignatureAlgorithmsExtension(schemes)
+	certificateRequestMsg := &CertificateRequestMsg{
+		Type:              CertificateRequestMsgType,
+		Length:            0, // will be auto calculated
+		RequestContextLen: uint8(len(requestContext)),
+		RequestContext:    requestContext,
+		ExtensionsLen:     uint16(len(extData)),
+		ExtensionData:     extData,
+	}
+	return certificateRequestMsg
+}
+
+func MakeCertificateVerifyMessage(scheme tls.SignatureScheme, signature []byte) *CertificateVerifyMsg {
+	certificateVerifyMsg := &CertificateVerifyMsg{
+		Type:            CertificateVerifyMsgType,
+		Length:          0, // will be auto calculated
+		SignatureScheme: scheme,
+		SignatureLen:    uint16(len(signature)),
+		Signature:       signature,
+	}
+	return certificateVerifyMsg
+}
+
+func MakeFinishedMessage(verifyData []byte) *FinishedMsg {
+	finishedMsg := &FinishedMsg{
+		Type:       FinishedMsgType,
+		Length:     0, // will be auto calculated
+		VerifyData: verifyData,
+	}
+	return finishedMsg
+}
+
+// MakeKeyUpdateMessage creates the message which switches the sent records to the next traffic keys.
+func MakeKeyUpdateMessage(requestUpdate KeyUpdateRequest) *KeyUpdateMsg {
+	keyUpdateMsg := &KeyUpdateMsg{
+		Type:          KeyUpdateMsgType,
+		Length:        0, // will be auto calculated
+		RequestUpdate: requestUpdate,
+	}
+	return keyUpdateMsg
 }
 
 type KeyShareExtParams struct {
@@ -65,23 +171,50 @@ type KeyShareExtParams struct {
 }
 
 type ClientHelloExtParams struct {
-	KeyShareExtParams *KeyShareExtParams
+	ServerName          string // the DNS host name of the server, empty if none is sent
+	KeyShares           []KeyShareExtParams
+	SupportedGroups     []tls.CurveID
+	SignatureAlgorithms []tls.SignatureScheme
+	Cookie              []byte // echoed from a HelloRetryRequest
+	PskModes            []extensions.PskKeyExchangeMode
+	PskIdentities       []extensions.PskIdentity // sent with a placeholder binder of PskBinderLen zeros each
+	PskBinderLen        int
+	EarlyData           bool // announces early data sent under the first offered PSK
+	ALPNProtocols       []string
+	Extensions          []extensions.Extension // custom extensions, sent before the pre_shared_key
 }
 
 type ServerHelloExtParams struct {
-	KeyShareExtParams *KeyShareExtParams
+	KeyShareExtParams   *KeyShareExtParams
+	SelectedPskIdentity *uint16                // set when the server accepts one of the offered PSKs
+	Extensions          []extensions.Extension // custom extensions
 }
 
-func MakeClientHelloMessage(cfg *ClientHelloExtParams) *ClientHelloMsg {
+type EncryptedExtensionsExtParams struct {
+	ServerName   bool                   // set when the server used the server name sent by the client
+	EarlyData    bool                   // set when the server accepts the client's early data
+	ALPNProtocol string                 // the application protocol selected by the server, empty if none
+	Extensions   []extensions.Extension // custom extensions, only of types the client sent
+}
+
+type HelloRetryRequestExtParams struct {
+	SelectedGroup tls.CurveID
+	Cookie        []byte
+}
+
+// MakeClientHelloMessage makes a ClientHello which offers the cipher suites in order of preference.
+func MakeClientHelloMessage(cipherSuites []CipherSuite, cfg *ClientHelloExtParams) *ClientHelloMsg {
+	common.AssertImpl(len(cipherSuites) > 0)
 	clientHelloMsg := &ClientHelloMsg{
-		Type:               ClientHelloMsgType,
-		Length:             0, // will be auto calculated
-		TLSVersion:         [2]byte{0x03, 0x01},
-		SessionIDLen:       32,
-		SessionID:          rand.CryptoRand(32), // session id is deprecated in TLS 1.3, but non zero value is set for compatibility
-		CipherSuiteLen:     2,
-		CipherSuite:        []CipherSuite{TLS_AES_128_GCM_SHA256},
-		CompressionMethods: [2]byte{1, 0},
+		Type:                  ClientHelloMsgType,
+		Length:                0, // will be auto calculated
+		TLSVersion:            [2]byte{0x03, 0x03},
+		SessionIDLen:          32,
+		SessionID:             rand.CryptoRand(32), // session id is deprecated in TLS 1.3, but non zero value is set for compatibility
+		CipherSuiteLen:        uint16(len(cipherSuites)) * typesizes.Uint16Bytes,
+		CipherSuite:           cipherSuites,
+		CompressionMethodsLen: 1,
+		CompressionMethods:    []byte{0},
 	}
 	copy(clientHelloMsg.Random[:], rand.CryptoRand(32))
 
@@ -97,18 +230,67 @@ func MakeClientHelloMessage(cfg *ClientHelloExtParams) *ClientHelloMsg {
 }
 
 func encodeClientHelloExtensions(cfg *ClientHelloExtParams) []byte {
-	exts := encodeCommonExtensions(cfg.KeyShareExtParams)
-	return exts
+	var buf bytes.Buffer
+	if cfg.ServerName != "" {
+		_, err := buf.Write(encodeServerNameExtension(extensions.ClientHelloMsgContext, cfg.ServerName))
+		common.AssertImpl(err == nil)
+	}
+	if len(cfg.SupportedGroups) > 0 {
+		sge := &extensions.SupportedGroupsExtension{
+			Type:      extensions.SupportedGroupsType,
+			GroupsLen: uint16(len(cfg.SupportedGroups)) * typesizes.Uint16Bytes,
+			Groups:    cfg.SupportedGroups,
+		}
+		sge.ExtensionLen = sge.GroupsLen + typesizes.Uint16Bytes
+		_, err := buf.Write(sge.ToBinary())
+		common.AssertImpl(err == nil)
+	}
+	if len(cfg.SignatureAlgorithms) > 0 {
+		_, err := buf.Write(encodeSignatureAlgorithmsExtension(cfg.SignatureAlgorithms))
+		common.AssertImpl(err == nil)
+	}
+	if cfg.KeyShares != nil {
+		_, err := buf.Write(encodeKeyShareExtension(extensions.ClientHelloMsgContext, cfg.KeyShares))
+		common.AssertImpl(err == nil)
+	}
+	if len(cfg.Cookie) > 0 {
+		_, err := buf.Write(encodeCookieExtension(cfg.Cookie))
+		common.AssertImpl(err == nil)
+	}
+	if len(cfg.PskModes) > 0 {
+		_, err := buf.Write(encodePskKeyExchangeModesExtension(cfg.PskModes))
+		common.AssertImpl(err == nil)
+	}
+	if len(cfg.ALPNProtocols) > 0 {
+		_, err := buf.Write(encodeALPNExtension(cfg.ALPNProtocols))
+		common.AssertImpl(err == nil)
+	}
+	if cfg.EarlyData {
+		_, err := buf.Write(encodeEarlyDataExtension(extensions.ClientHelloMsgContext, 0))
+		common.AssertImpl(err == nil)
+	}
+	_, err := buf.Write(encodeSupportedVersionsExtension(extensions.ClientHelloMsgContext))
+	common.AssertImpl(err == nil)
+	_, err = buf.Write(encodeCustomExtensions(cfg.Extensions))
+	common.AssertImpl(err == nil)
+	if len(cfg.PskIdentities) > 0 {
+		// RFC 8446, Section 4.2.11: the pre_shared_key extension must be the last one.
+		_, err = buf.Write(encodeClientPreSharedKeyExtension(cfg.PskIdentities, cfg.PskBinderLen))
+		common.AssertImpl(err == nil)
+	}
+	return buf.Bytes()
 }
 
-func MakeServerHelloMessage(cfg *ServerHelloExtParams) *ServerHelloMsg {
+// MakeServerHelloMessage makes a ServerHello which echoes the legacy session id of the ClientHello, as required by
+// RFC 8446, Section 4.1.3.
+func MakeServerHelloMessage(cipherSuite CipherSuite, sessionID []byte, cfg *ServerHelloExtParams) *ServerHelloMsg {
 	serverHelloMsg := &ServerHelloMsg{
 		Type:               ServerHelloMsgType,
 		Length:             0, // will be auto calculated
-		TLSVersion:         [2]byte{0x03, 0x01},
-		SessionIDLen:       32,
-		SessionID:          rand.CryptoRand(32), // session id is deprecated in TLS 1.3, but non zero value is set for compatibility
-		CipherSuite:        TLS_AES_128_GCM_SHA256,
+		TLSVersion:         [2]byte{0x03, 0x03},
+		SessionIDLen:       uint8(len(sessionID)),
+		SessionID:          sessionID,
+		CipherSuite:        cipherSuite,
 		CompressionMethods: [1]byte{0},
 	}
 	copy(serverHelloMsg.Random[:], rand.CryptoRand(32))
@@ -125,36 +307,230 @@ func MakeServerHelloMessage(cfg *ServerHelloExtParams) *ServerHelloMsg {
 }
 
 func encodeServerHelloExtensions(cfg *ServerHelloExtParams) []byte {
-	exts := encodeCommonExtensions(cfg.KeyShareExtParams)
-	return exts
-}
-
-func encodeCommonExtensions(ksep *KeyShareExtParams) []byte {
-	var (
-		buf  bytes.Buffer
-		err error
-	)
-
-	if ksep != nil {
-		kse := &extensions.KeyShareExtension{
-			Type:            extensions.KeyShareType,
-			KeyShareDataLen: 0, // does not seem to matters
-			CurveID:         ksep.CurveID,
-			PubKeyBytesLen:  uint16(len(ksep.PubKey)),
-			PublicKey:       ksep.PubKey,
+	var buf bytes.Buffer
+	if cfg.KeyShareExtParams != nil {
+		shares := []KeyShareExtParams{*cfg.KeyShareExtParams}
+		_, err := buf.Write(encodeKeyShareExtension(extensions.ServerHelloMsgContext, shares))
+		common.AssertImpl(err == nil)
+	}
+	if cfg.SelectedPskIdentity != nil {
+		pske := &extensions.PreSharedKeyExtension{
+			Type:             extensions.PreSharedKeyType,
+			ExtensionLen:     typesizes.Uint16Bytes,
+			Context:          extensions.ServerHelloMsgContext,
+			SelectedIdentity: *cfg.SelectedPskIdentity,
 		}
-		kse.ExtensionLen = kse.PubKeyBytesLen + typesizes.Uint16Bytes*3
-		_, err = buf.Write(kse.ToBinary())
+		_, err := buf.Write(pske.ToBinary())
 		common.AssertImpl(err == nil)
 	}
+	_, err := buf.Write(encodeSupportedVersionsExtension(extensions.ServerHelloMsgContext))
+	common.AssertImpl(err == nil)
+	_, err = buf.Write(encodeCustomExtensions(cfg.Extensions))
+	common.AssertImpl(err == nil)
+	return buf.Bytes()
+}
+
+// MakeHelloRetryRequestMessage makes a ServerHello with the special HelloRetryRequestRandom, which asks the client to
+// send a new ClientHello with a key share for the selected group. Like a ServerHello it echoes the legacy session id.
+func MakeHelloRetryRequestMessage(cipherSuite CipherSuite, sessionID []byte,
+	cfg *HelloRetryRequestExtParams) *ServerHelloMsg {
+
+	common.AssertImpl(cfg != nil)
+	helloRetryRequestMsg := &ServerHelloMsg{
+		Type:               ServerHelloMsgType,
+		Length:             0, // will be auto calculated
+		TLSVersion:         [2]byte{0x03, 0x03},
+		Random:             HelloRetryRequestRandom,
+		SessionIDLen:       uint8(len(sessionID)),
+		SessionID:          sessionID,
+		CipherSuite:        cipherSuite,
+		CompressionMethods: [1]byte{0},
+	}
 
-	sv := &extensions.SupportedVersions{
-		Type:          extensions.SupporteVersionsType,
-		ExtensionLen:  3,
-		TLSVersionLen: 2,
-		TLSVersion:    tls.VersionTLS13,
+	var buf bytes.Buffer
+	kse := &extensions.KeyShareExtension{
+		Type:          extensions.KeyShareType,
+		ExtensionLen:  typesizes.Uint16Bytes,
+		Context:       extensions.HelloRetryRequestMsgContext,
+		SelectedGroup: cfg.SelectedGroup,
 	}
-	_, err = buf.Write(sv.ToBinary())
+	_, err := buf.Write(kse.ToBinary())
 	common.AssertImpl(err == nil)
+	if len(cfg.Cookie) > 0 {
+		_, err = buf.Write(encodeCookieExtension(cfg.Cookie))
+		common.AssertImpl(err == nil)
+	}
+	_, err = buf.Write(encodeSupportedVersionsExtension(extensions.HelloRetryRequestMsgContext))
+	common.AssertImpl(err == nil)
+
+	helloRetryRequestMsg.ExtensionData = buf.Bytes()
+	helloRetryRequestMsg.ExtensionsLen = uint16(buf.Len())
+	return helloRetryRequestMsg
+}
+
+// MakeNewSessionTicketMessage creates a ticket which is valid for lifetime seconds. When maxEarlyData is not zero the
+// ticket allows the client to send up to maxEarlyData bytes of early data with it.
+func MakeNewSessionTicketMessage(lifetime, ageAdd uint32, nonce, ticket []byte, maxEarlyData uint32) *NewSessionTicketMsg {
+	newSessionTicketMsg := &NewSessionTicketMsg{
+		Type:           NewSessionTicketMsgType,
+		Length:         0, // will be auto calculated
+		TicketLifetime: lifetime,
+		TicketAgeAdd:   ageAdd,
+		TicketNonceLen: uint8(len(nonce)),
+		TicketNonce:    nonce,
+		TicketLen:      uint16(len(ticket)),
+		Ticket:         ticket,
+		ExtensionsLen:  0,
+		ExtensionData:  []byte{},
+	}
+	if maxEarlyData > 0 {
+		extData := encodeEarlyDataExtension(extensions.NewSessionTicketMsgContext, maxEarlyData)
+		newSessionTicketMsg.ExtensionsLen = uint16(len(extData))
+		newSessionTicketMsg.ExtensionData = extData
+	}
+	return newSessionTicketMsg
+}
+
+func encodeCustomExtensions(exts []extensions.Extension) []byte {
+	var buf bytes.Buffer
+	for _, ext := range exts {
+		_, err := buf.Write(ext.ToBinary())
+		common.AssertImpl(err == nil)
+	}
 	return buf.Bytes()
+}
+
+func encodeServerNameExtension(ctx extensions.MsgContext, hostName string) []byte {
+	sne := &extensions.ServerNameExtension{
+		Type:         extensions.ServerNameType,
+		ExtensionLen: 0,
+		Context:      ctx,
+	}
+	if ctx == extensions.ClientHelloMsgContext {
+		sne.ServerNameList = []extensions.ServerName{{
+			NameType: extensions.HostNameType,
+			NameLen:  uint16(len(hostName)),
+			Name:     []byte(hostName),
+		}}
+		sne.ServerNameListLen = typesizes.Uint8Bytes + typesizes.Uint16Bytes + uint16(len(hostName))
+		sne.ExtensionLen = sne.ServerNameListLen + typesizes.Uint16Bytes
+	}
+	return sne.ToBinary()
+}
+
+func encodeALPNExtension(protocols []string) []byte {
+	alpne := &extensions.ALPNExtension{
+		Type:      extensions.ALPNType,
+		Protocols: protocols,
+	}
+	for _, p := range protocols {
+		alpne.ProtocolsLen += uint16(len(p)) + typesizes.Uint8Bytes
+	}
+	alpne.ExtensionLen = alpne.ProtocolsLen + typesizes.Uint16Bytes
+	return alpne.ToBinary()
+}
+
+func encodeEarlyDataExtension(ctx extensions.MsgContext, maxEarlyData uint32) []byte {
+	ede := &extensions.EarlyDataExtension{
+		Type:             extensions.EarlyDataType,
+		ExtensionLen:     0,
+		Context:          ctx,
+		MaxEarlyDataSize: maxEarlyData,
+	}
+	if ctx == extensions.NewSessionTicketMsgContext {
+		ede.ExtensionLen = typesizes.Uint32Bytes
+	}
+	return ede.ToBinary()
+}
+
+func encodePskKeyExchangeModesExtension(modes []extensions.PskKeyExchangeMode) []byte {
+	pke := &extensions.PskKeyExchangeModesExtension{
+		Type:     extensions.PskKeyExchangeModesType,
+		ModesLen: uint8(len(modes)),
+		Modes:    modes,
+	}
+	pke.ExtensionLen = uint16(pke.ModesLen) + typesizes.Uint8Bytes
+	return pke.ToBinary()
+}
+
+// encodeClientPreSharedKeyExtension encodes the identities with zeroed binders of binderLen bytes, which are filled in
+// once the rest of the ClientHello is known.
+func encodeClientPreSharedKeyExtension(identities []extensions.PskIdentity, binderLen int) []byte {
+	pske := &extensions.PreSharedKeyExtension{
+		Type:       extensions.PreSharedKeyType,
+		Context:    extensions.ClientHelloMsgContext,
+		Identities: identities,
+		Binders:    make([]extensions.PskBinderEntry, 0, len(identities)),
+	}
+	for _, id := range identities {
+		pske.IdentitiesLen += id.IdentityLen + typesizes.Uint16Bytes + typesizes.Uint32Bytes
+		pske.Binders = append(pske.Binders, extensions.PskBinderEntry{
+			BinderLen: uint8(binderLen),
+			Binder:    make([]byte, binderLen),
+		})
+		pske.BindersLen += uint16(binderLen) + typesizes.Uint8Bytes
+	}
+	pske.ExtensionLen = pske.IdentitiesLen + pske.BindersLen + typesizes.Uint16Bytes*2
+	return pske.ToBinary()
+}
+
+func encodeSignatureAlgorithmsExtension(schemes []tls.SignatureScheme) []byte {
+	sae := &extensions.SignatureAlgorithmsExtension{
+		Type:       extensions.SignatureAlgorithmsType,
+		SchemesLen: uint16(len(schemes)) * typesizes.Uint16Bytes,
+		Schemes:    schemes,
+	}
+	sae.ExtensionLen = sae.SchemesLen + typesizes.Uint16Bytes
+	return sae.ToBinary()
+}
+
+func encodeCookieExtension(cookie []byte) []byte {
+	ce := &extensions.CookieExtension{
+		Type:      extensions.CookieType,
+		CookieLen: uint16(len(cookie)),
+		Cookie:    cookie,
+	}
+	ce.ExtensionLen = ce.CookieLen + typesizes.Uint16Bytes
+	return ce.ToBinary()
+}
+
+// encodeKeyShareExtension encodes the key shares in the format of the given message.
+func encodeKeyShareExtension(ctx extensions.MsgContext, shares []KeyShareExtParams) []byte {
+	kse := &extensions.KeyShareExtension{
+		Type:    extensions.KeyShareType,
+		Context: ctx,
+		Entries: make([]extensions.KeyShareEntry, 0, len(shares)),
+	}
+	for _, ksep := range shares {
+		entry := extensions.KeyShareEntry{
+			CurveID:        ksep.CurveID,
+			PubKeyBytesLen: uint16(len(ksep.PubKey)),
+			PublicKey:      ksep.PubKey,
+		}
+		kse.Entries = append(kse.Entries, entry)
+		kse.KeyShareDataLen += entry.PubKeyBytesLen + typesizes.Uint16Bytes*2
+	}
+	kse.ExtensionLen = kse.KeyShareDataLen
+	if ctx == extensions.ClientHelloMsgContext {
+		kse.ExtensionLen += typesizes.Uint16Bytes
+	} else {
+		kse.KeyShareDataLen = 0 // the server share is not a list
+	}
+	return kse.ToBinary()
+}
+
+func encodeSupportedVersionsExtension(ctx extensions.MsgContext) []byte {
+	sv := &extensions.SupportedVersions{
+		Type:    extensions.SupporteVersionsType,
+		Context: ctx,
+	}
+	if ctx == extensions.ClientHelloMsgContext {
+		sv.TLSVersions = []uint16{tls.VersionTLS13}
+		sv.TLSVersionLen = uint8(len(sv.TLSVersions)) * typesizes.Uint16Bytes
+		sv.ExtensionLen = uint16(sv.TLSVersionLen) + typesizes.Uint8Bytes
+	} else {
+		sv.TLSVersion = tls.VersionTLS13
+		sv.ExtensionLen = typesizes.Uint16Bytes
+	}
+	return sv.ToBinary()
 }
\ No newline at end of file
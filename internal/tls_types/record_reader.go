@@ -0,0 +1,66 @@
+package tlstypes
+
+import (
+	"errors"
+	"io"
+)
+
+const readChunkSize = 4096
+
+// RecordReader reads whole records from a stream. It waits until all bytes of a record split over several reads have
+// arrived, and hands out several records delivered in one read one at a time.
+type RecordReader struct {
+	r   io.Reader
+	buf []byte // bytes read from r which are not yet returned as a record
+}
+
+func NewRecordReader(r io.Reader) *RecordReader {
+	return &RecordReader{r: r}
+}
+
+// ReadRecord blocks until the next full record is available.
+func (rr *RecordReader) ReadRecord() (*Record, error) {
+	for {
+		if len(rr.buf) >= int(RecordHeaderByteSize) {
+			length := int(rr.buf[3])<<8 + int(rr.buf[4])
+			if length > MaxSizeOfCiphertextRecord {
+				return nil, errors.New("record length exceeds the maximum for a record")
+			}
+			fullLen := int(RecordHeaderByteSize) + length
+			if len(rr.buf) >= fullLen {
+				record, err := ParseRecord(rr.buf[:fullLen])
+				rr.buf = rr.buf[fullLen:]
+				return record, err
+			}
+		}
+
+		if err := rr.fill(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Buffered returns the number of bytes which were read from the stream, but are not yet returned as a record.
+func (rr *RecordReader) Buffered() int {
+	return len(rr.buf)
+}
+
+func (rr *RecordReader) fill() error {
+	if len(rr.buf) == 0 {
+		rr.buf = rr.buf[:0:0] // don't keep the memory of already returned records around
+	}
+
+	var chunk [readChunkSize]byte
+	n, err := rr.r.Read(chunk[:])
+	rr.buf = append(rr.buf, chunk[:n]...)
+	if n > 0 {
+		return nil
+	}
+	if err == io.EOF && len(rr.buf) > 0 {
+		return io.ErrUnexpectedEOF
+	}
+	if err == nil {
+		err = io.ErrNoProgress
+	}
+	return err
+}
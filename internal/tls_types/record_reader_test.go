@@ -0,0 +1,52 @@
+package tlstypes
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestRecordReader(t *testing.T) {
+	var buf []byte = []byte{
+		0x16, 0x03, 0x04, 0x00, 0x04, 0x14, 0x00, 0x00, 0x00,
+		0x17, 0x03, 0x03, 0x00, 0x03, 0x01, 0x02, 0x03,
+	}
+
+	// Every read returns a single byte, the records must still come out whole:
+	rr := NewRecordReader(iotest.OneByteReader(bytes.NewReader(buf)))
+	r, err := rr.ReadRecord()
+	if err != nil || r.RecordType != HandshakeRecord || string(r.ToBinary()) != string(buf[:9]) {
+		t.Fatalf("RecordReader is broken on partial reads")
+	}
+	r, err = rr.ReadRecord()
+	if err != nil || r.RecordType != ApplicationRecord || string(r.ToBinary()) != string(buf[9:]) {
+		t.Fatalf("RecordReader is broken on partial reads")
+	}
+	if _, err = rr.ReadRecord(); err != io.EOF {
+		t.Fatalf("RecordReader does not return io.EOF at the end of the stream")
+	}
+
+	// Both records arrive in one read:
+	rr = NewRecordReader(bytes.NewReader(buf))
+	r, err = rr.ReadRecord()
+	if err != nil || string(r.ToBinary()) != string(buf[:9]) {
+		t.Fatalf("RecordReader is broken on coalesced reads")
+	}
+	if rr.Buffered() != len(buf)-9 {
+		t.Fatalf("RecordReader.Buffered is broken")
+	}
+	r, err = rr.ReadRecord()
+	if err != nil || string(r.ToBinary()) != string(buf[9:]) {
+		t.Fatalf("RecordReader is broken on coalesced reads")
+	}
+
+	// The stream ends in the middle of a record:
+	rr = NewRecordReader(bytes.NewReader(buf[:12]))
+	if _, err = rr.ReadRecord(); err != nil {
+		t.Fatalf("RecordReader is broken")
+	}
+	if _, err = rr.ReadRecord(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("RecordReader does not detect a truncated record")
+	}
+}
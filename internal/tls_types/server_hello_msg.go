@@ -7,6 +7,13 @@ import (
 	typesizes "github.com/tls-handshake/pkg/type_sizes"
 )
 
+// HelloRetryRequestRandom is the Random of a ServerHello which is a HelloRetryRequest, it's the SHA-256 of
+// "HelloRetryRequest" as defined in RFC 8446, Section 4.1.3.
+var HelloRetryRequestRandom = [RandomByteSize]byte{
+	0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11, 0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8, 0x91,
+	0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e, 0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c,
+}
+
 type ServerHelloMsg struct {
 	Type               HandshakeMsgType
 	Length             uint
@@ -90,7 +97,9 @@ func ParseServerHelloMsg(buf []byte) (hm *ServerHelloMsg, err error) {
 	wi += copy(hm.ExtensionData[:], buf[wi:])
 
 	// Final sanity check:
-	common.AssertImpl(wi-int(HandshakeHeaderByteSize) == int(hm.Length))
+	if wi-int(HandshakeHeaderByteSize) != int(hm.Length) {
+		return nil, errors.New("server hello message has invalid length")
+	}
 
 	return hm, nil
 }
@@ -125,3 +134,7 @@ func (hm *ServerHelloMsg) ToBinary() []byte {
 
 	return raw
 }
+
+func (hm *ServerHelloMsg) IsHelloRetryRequest() bool {
+	return hm.Random == HelloRetryRequestRandom
+}
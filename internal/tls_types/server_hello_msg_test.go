@@ -1,6 +1,10 @@
 package tlstypes
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/tls-handshake/internal/tls_types/extensions"
+)
 
 func TestParseServerHelloMsg(t *testing.T) {
 	var buf []byte = []byte{
@@ -42,4 +46,61 @@ func TestParseServerHelloMsg(t *testing.T) {
 	if !v {
 		t.Fatalf("ParseServerHelloMsg.ToBinary is broken when Length is 0")
 	}
-}
\ No newline at end of file
+}
+func TestHelloRetryRequestMsg(t *testing.T) {
+	cookie := []byte{0x01, 0x02, 0x03, 0x04}
+	sessionID := []byte{0x0a, 0x0b, 0x0c}
+	hrr := MakeHelloRetryRequestMessage(TLS_AES_128_GCM_SHA256, sessionID,
+		&HelloRetryRequestExtParams{SelectedGroup: 0x17, Cookie: cookie})
+	raw := hrr.ToBinary()
+
+	parsed, err := ParseServerHelloMsg(raw)
+	if err != nil {
+		t.Fatalf("ParseServerHelloMsg fails on a hello retry request")
+	}
+	if !parsed.IsHelloRetryRequest() {
+		t.Fatalf("IsHelloRetryRequest is broken")
+	}
+	if string(parsed.SessionID) != string(sessionID) {
+		t.Fatalf("hello retry request does not echo the session id")
+	}
+	if string(parsed.ToBinary()) != string(raw) {
+		t.Fatalf("ServerHelloMsg.ToBinary is broken for a hello retry request")
+	}
+
+	exts, err := extensions.ParseExtensions(parsed.ExtensionData, parsed.ExtensionsLen, extensions.HelloRetryRequestMsgContext)
+	if err != nil {
+		t.Fatalf("ParseExtensions fails on hello retry request extensions: %v", err)
+	}
+	kse, ok := extensions.FindExtension(exts, extensions.KeyShareType).(*extensions.KeyShareExtension)
+	if !ok || kse.SelectedGroup != 0x17 || len(kse.Entries) != 0 {
+		t.Fatalf("hello retry request has an invalid key share")
+	}
+	ce, ok := extensions.FindExtension(exts, extensions.CookieType).(*extensions.CookieExtension)
+	if !ok || string(ce.Cookie) != string(cookie) {
+		t.Fatalf("hello retry request has an invalid cookie")
+	}
+
+	sh := MakeServerHelloMessage(TLS_AES_128_GCM_SHA256, sessionID, &ServerHelloExtParams{})
+	if sh.IsHelloRetryRequest() {
+		t.Fatalf("IsHelloRetryRequest is true for a server hello")
+	}
+}
+
+func TestParseMalformedServerHelloMsg(t *testing.T) {
+	raw := MakeServerHelloMessage(TLS_AES_128_GCM_SHA256, []byte{0x01}, &ServerHelloExtParams{}).ToBinary()
+
+	for n := int(HandshakeHeaderByteSize); n < len(raw); n++ {
+		truncated := append([]byte(nil), raw[:n]...)
+		setHandshakeLength(truncated, new(uint))
+		if _, err := ParseServerHelloMsg(truncated); err == nil {
+			t.Fatalf("ParseServerHelloMsg accepts a server hello truncated to %d bytes", n)
+		}
+	}
+
+	trailing := append(append([]byte(nil), raw...), 0x00)
+	setHandshakeLength(trailing, new(uint))
+	if _, err := ParseServerHelloMsg(trailing); err == nil {
+		t.Fatalf("ParseServerHelloMsg accepts a trailing byte")
+	}
+}
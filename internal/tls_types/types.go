@@ -10,11 +10,22 @@ const (
 	VersionByteSize            TLSFieldSize = 2
 	RandomByteSize             TLSFieldSize = 32
 	ExtensionsLengthByteSize   TLSFieldSize = 2
+	Uint24ByteSize             TLSFieldSize = 3
+	SignatureSchemeByteSize    TLSFieldSize = 2
 )
 
 type HandshakeMsgType uint8
 
 const (
-	ClientHelloMsgType HandshakeMsgType = 0x1
-	ServerHelloMsgType HandshakeMsgType = 0x2
+	ClientHelloMsgType         HandshakeMsgType = 0x1
+	ServerHelloMsgType         HandshakeMsgType = 0x2
+	NewSessionTicketMsgType    HandshakeMsgType = 0x4
+	EndOfEarlyDataMsgType      HandshakeMsgType = 0x5
+	EncryptedExtensionsMsgType HandshakeMsgType = 0x8
+	CertificateMsgType         HandshakeMsgType = 0xb
+	CertificateRequestMsgType  HandshakeMsgType = 0xd
+	CertificateVerifyMsgType   HandshakeMsgType = 0xf
+	FinishedMsgType            HandshakeMsgType = 0x14
+	KeyUpdateMsgType           HandshakeMsgType = 0x18
+	MessageHashMsgType         HandshakeMsgType = 0xfe // synthetic message which replaces the first ClientHello after a HelloRetryRequest
 )
\ No newline at end of file
@@ -54,66 +54,45 @@ func (w *Wrapper) IsConnClosed() bool {
 
 func (w *Wrapper) Read(p []byte) (n int, err error) {
 	w.mux.Lock()
-	defer w.mux.Unlock()
 	if w.closed {
+		w.mux.Unlock()
 		return 0, errors.New("read on closed connection")
 	}
+	limit := w.readLimit
+	w.mux.Unlock()
 
-	var done chan struct{}
-	if w.readLimit != nil {
-		timer := time.NewTimer(*w.readLimit)
-		done = make(chan struct{}, 1)
-		defer close(done)
-		go func() {
-			select {
-			case <-timer.C:
-				// time limit exceeded:
-				fmt.Println("Connection closed on slow read")
-				_ = w.Close()
-				w.closed = true
-			case <-done:
-				return
-			}
-		}()
-	}
-
-	n, err = w.rawConn.Read(p)
-	if done != nil {
-		done <- struct{}{}
+	// The lock is not held during the blocking read, so that a concurrent Write or Close is not blocked by it.
+	if limit != nil {
+		stop := w.closeAfter(*limit, "Connection closed on slow read")
+		defer stop()
 	}
-	return n, err
+	return w.rawConn.Read(p)
 }
 
 func (w *Wrapper) Write(p []byte) (n int, err error) {
 	w.mux.Lock()
-	defer w.mux.Unlock()
 	if w.closed {
+		w.mux.Unlock()
 		return 0, errors.New("write on closed connection")
 	}
+	limit := w.writeLimit
+	w.mux.Unlock()
 
-	var done chan struct{}
-	if w.writeLimit != nil {
-		timer := time.NewTimer(*w.writeLimit)
-		done = make(chan struct{}, 1)
-		defer close(done)
-		go func() {
-			select {
-			case <-timer.C:
-				// time limit exceeded:
-				fmt.Println("Connection closed on slow write")
-				_ = w.Close()
-				w.closed = true
-			case <-done:
-				return
-			}
-		}()
+	if limit != nil {
+		stop := w.closeAfter(*limit, "Connection closed on slow write")
+		defer stop()
 	}
+	return w.rawConn.Write(p)
+}
 
-	n, err = w.rawConn.Write(p)
-	if done != nil {
-		done <- struct{}{}
-	}
-	return n, err
+// closeAfter closes the connection when d elapses before the returned stop function is called.
+func (w *Wrapper) closeAfter(d time.Duration, reason string) (stop func()) {
+	timer := time.AfterFunc(d, func() {
+		// time limit exceeded:
+		fmt.Println(reason)
+		_ = w.Close()
+	})
+	return func() { timer.Stop() }
 }
 
 func (w *Wrapper) Close() error {
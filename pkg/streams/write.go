@@ -4,7 +4,8 @@ import "io"
 
 func WriteAllBytes(dest io.Writer, src []byte) error {
 	currRead := 0
-	for {
+	// Don't issue an empty write once everything is written, some writers (e.g. net.Pipe) block on it.
+	for currRead < len(src) {
 		n, err := dest.Write(src[currRead:])
 		if err == io.EOF {
 			break